@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/importfile"
+	"github.com/AlexZav1327/name-enricher/internal/service"
+	"github.com/sirupsen/logrus"
+)
+
+// importActor is recorded in the history of the users saved by the import command.
+const importActor = "import"
+
+var errImportUsage = errors.New("usage: enricher-service import [-format csv|ndjson] [-enrich] FILE")
+
+// runImport runs the import subcommand: it saves the users of the file and prints the report as JSON.
+func runImport(ctx context.Context, enricherService *service.Service, args []string, logger *logrus.Logger) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	format := flags.String("format", "", "csv or ndjson; by default .ndjson and .jsonl files are NDJSON")
+	enrich := flags.Bool("enrich", false, "request the providers for the values missing from a row")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errImportUsage
+	}
+
+	fileName := flags.Arg(0)
+	if *format == "" {
+		*format = importfile.FormatOf(fileName)
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("os.Open(fileName): %w", err)
+	}
+
+	defer file.Close()
+
+	records, err := importfile.Read(file, *format)
+	if err != nil {
+		return fmt.Errorf("importfile.Read(file, *format): %w", err)
+	}
+
+	ctx = audit.WithMeta(ctx, audit.Meta{Actor: importActor})
+
+	report, err := enricherService.ImportUsers(ctx, records, *enrich)
+	if err != nil {
+		return fmt.Errorf("enricherService.ImportUsers(ctx, records, *enrich): %w", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err = encoder.Encode(report); err != nil {
+		return fmt.Errorf("encoder.Encode(report): %w", err)
+	}
+
+	logger.Infof("%d users were inserted, %d updated and %d rows rejected", report.Inserted, report.Updated,
+		report.Rejected)
+
+	return nil
+}
@@ -2,17 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
-	"github.com/AlexZav1327/name-enricher/internal/age"
-	"github.com/AlexZav1327/name-enricher/internal/country"
-	"github.com/AlexZav1327/name-enricher/internal/gender"
+	"github.com/AlexZav1327/name-enricher/internal/provider"
 	"github.com/AlexZav1327/name-enricher/internal/server"
 	"github.com/AlexZav1327/name-enricher/internal/service"
 	"github.com/AlexZav1327/name-enricher/internal/storage"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	migrate "github.com/rubenv/sql-migrate"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -29,33 +29,184 @@ func main() {
 		logrus.Panicf("viper.ReadInConfig(): %s", err)
 	}
 
+	var providerConfigs []provider.Config
+
+	if err := viper.UnmarshalKey("providers", &providerConfigs); err != nil {
+		logrus.Panicf(`viper.UnmarshalKey("providers", &providerConfigs): %s`, err)
+	}
+
 	var (
-		pgDSN = viper.GetString("database.dsn")
-		host  = viper.GetString("server.host")
-		port  = viper.GetInt("server.port")
+		pgCfg = storage.Config{
+			DSN:               viper.GetString("database.dsn"),
+			MaxConns:          viper.GetInt32("database.max_conns"),
+			MinConns:          viper.GetInt32("database.min_conns"),
+			MaxConnLifetime:   viper.GetDuration("database.max_conn_lifetime"),
+			MaxConnIdleTime:   viper.GetDuration("database.max_conn_idle_time"),
+			HealthCheckPeriod: viper.GetDuration("database.health_check_period"),
+			AcquireTimeout:    viper.GetDuration("database.acquire_timeout"),
+		}
+		autoMigrate = viper.GetBool("database.auto_migrate")
+		host        = viper.GetString("server.host")
+		port        = viper.GetInt("server.port")
+		cfg         = service.Config{
+			SurnameNationality: viper.GetBool("enrichment.surname_nationality"),
+			SurnameWeight:      viper.GetFloat64("enrichment.surname_weight"),
+			EnrichTimeout:      viper.GetDuration("enrichment.timeout"),
+			PhoneticReuse:      viper.GetBool("enrichment.phonetic_reuse"),
+			PhoneticMinCount:   viper.GetInt("enrichment.phonetic_min_count"),
+			PurgeRetention:     viper.GetDuration("purge.retention"),
+			PurgeInterval:      viper.GetDuration("purge.interval"),
+			JobWorkers:         viper.GetInt("jobs.workers"),
+			JobPollInterval:    viper.GetDuration("jobs.poll_interval"),
+			JobLease:           viper.GetDuration("jobs.lease"),
+			JobRetryBackoff:    viper.GetDuration("jobs.retry_backoff"),
+			RefreshMaxAge:      viper.GetDuration("refresh.max_age"),
+			RefreshInterval:    viper.GetDuration("refresh.interval"),
+			RefreshBatchSize:   viper.GetInt("refresh.batch_size"),
+			RefreshPause:       viper.GetDuration("refresh.pause"),
+		}
 	)
 
+	if err := cfg.Validate(); err != nil {
+		logrus.Panicf("cfg.Validate(): %s", err)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer cancel()
 
 	logger := logrus.StandardLogger()
 
-	pg, err := storage.ConnectDB(ctx, pgDSN, logger)
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "", "import":
+	case "migrate":
+		if err := runMigrate(ctx, pgCfg, os.Args[2:], logger); err != nil {
+			logger.Panicf("runMigrate(ctx, pgCfg, os.Args[2:], logger): %s", err)
+		}
+
+		return
+	default:
+		logger.Panicf("unknown command %q; %s; %s", command, errMigrateUsage, errImportUsage)
+	}
+
+	store, closeStore, err := newStore(ctx, pgCfg, autoMigrate, logger)
 	if err != nil {
-		logger.Panicf("storage.ConnectDB(ctx, pgDSN, logger): %s", err)
+		logger.Panicf("newStore(ctx, pgCfg, autoMigrate, logger): %s", err)
 	}
 
-	if err = pg.Migrate(migrate.Up); err != nil {
-		logger.Panicf("pg.Migrate(migrate.Up): %s", err)
+	defer closeStore()
+
+	providers, err := provider.NewSet(providerConfigs, logger)
+	if err != nil {
+		logger.Panicf("provider.NewSet(providerConfigs, logger): %s", err)
+	}
+
+	resolvers, err := newResolvers(providers)
+	if err != nil {
+		logger.Panicf("newResolvers(providers): %s", err)
+	}
+
+	enricherService := service.New(store, resolvers, cfg, logger)
+
+	if command == "import" {
+		if err = runImport(ctx, enricherService, os.Args[2:], logger); err != nil {
+			logger.Panicf("runImport(ctx, enricherService, os.Args[2:], logger): %s", err)
+		}
+
+		return
 	}
 
-	ageEnrich := age.New(logger)
-	genderEnrich := gender.New(logger)
-	countryEnrich := country.New(logger)
-	enricherService := service.New(pg, ageEnrich, genderEnrich, countryEnrich, logger)
 	s := server.New(host, port, enricherService, logger)
 
+	go enricherService.RunPurge(ctx)
+
+	// The job workers save the items they are enriching before the store is closed.
+	jobsStopped := make(chan struct{})
+
+	go func() {
+		defer close(jobsStopped)
+
+		enricherService.RunJobs(ctx)
+	}()
+
+	// A refresh run is recorded before the store is closed.
+	refreshStopped := make(chan struct{})
+
+	go func() {
+		defer close(refreshStopped)
+
+		enricherService.RunRefresh(ctx)
+	}()
+
 	if err = s.Run(ctx); err != nil {
 		logger.Panicf("s.Run(ctx): %s", err)
 	}
+
+	<-jobsStopped
+	<-refreshStopped
+}
+
+// newStore picks the store by the DSN scheme: memory:// keeps the users in memory, sqlite:// in a SQLite
+// file and any other DSN in Postgres. The returned function releases the store.
+func newStore(ctx context.Context, cfg storage.Config, autoMigrate bool, logger *logrus.Logger) (
+	service.Store, func(), error,
+) {
+	if strings.HasPrefix(cfg.DSN, storage.MemoryScheme) {
+		logger.Warning("Users are kept in memory and will be lost on exit")
+
+		return storage.NewMemory(), func() {}, nil
+	}
+
+	store, err := connectStore(ctx, cfg, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connectStore(ctx, cfg, logger): %w", err)
+	}
+
+	if err = prepareSchema(ctx, store, autoMigrate, logger); err != nil {
+		store.Close()
+
+		return nil, nil, fmt.Errorf("prepareSchema(ctx, store, autoMigrate, logger): %w", err)
+	}
+
+	return store, store.Close, nil
+}
+
+// newResolvers takes the age, gender and country providers as built-in dimensions and registers
+// every other configured provider as a custom attribute.
+func newResolvers(providers provider.Set) (service.Resolvers, error) {
+	ageEnrich, err := providers.Get("age")
+	if err != nil {
+		return service.Resolvers{}, fmt.Errorf(`providers.Get("age"): %w`, err)
+	}
+
+	genderEnrich, err := providers.Get("gender")
+	if err != nil {
+		return service.Resolvers{}, fmt.Errorf(`providers.Get("gender"): %w`, err)
+	}
+
+	countryEnrich, err := providers.Get("country")
+	if err != nil {
+		return service.Resolvers{}, fmt.Errorf(`providers.Get("country"): %w`, err)
+	}
+
+	resolvers := service.Resolvers{
+		Age:        ageEnrich,
+		Gender:     genderEnrich,
+		Country:    countryEnrich,
+		Attributes: make(map[string]service.Resolver),
+	}
+
+	for name, p := range providers {
+		if p == ageEnrich || p == genderEnrich || p == countryEnrich {
+			continue
+		}
+
+		resolvers.Attributes[name] = p
+	}
+
+	return resolvers, nil
 }
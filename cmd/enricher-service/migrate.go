@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/service"
+	"github.com/AlexZav1327/name-enricher/internal/storage"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errMigrateUsage  = errors.New("usage: enricher-service migrate up|down|status|redo")
+	errNoSchema      = errors.New("the in-memory store has no schema")
+	errNothingToRedo = errors.New("no migration is applied")
+)
+
+// migratedStore is a store with a versioned schema.
+type migratedStore interface {
+	service.Store
+	MigrateMax(direction migrate.MigrationDirection, max int) (int, error)
+	MigrationStatus() ([]storage.MigrationStatus, error)
+	Close()
+}
+
+// connectStore connects to SQLite for sqlite:// DSNs and to Postgres otherwise, without migrating.
+func connectStore(ctx context.Context, cfg storage.Config, logger *logrus.Logger) (migratedStore, error) {
+	if strings.HasPrefix(cfg.DSN, storage.SQLiteScheme) {
+		lite, err := storage.ConnectSQLite(ctx, cfg.DSN, logger)
+		if err != nil {
+			return nil, fmt.Errorf("storage.ConnectSQLite(ctx, cfg.DSN, logger): %w", err)
+		}
+
+		return lite, nil
+	}
+
+	pg, err := storage.ConnectDB(ctx, cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("storage.ConnectDB(ctx, cfg, logger): %w", err)
+	}
+
+	return pg, nil
+}
+
+// prepareSchema applies the pending migrations if autoMigrate is set and refuses to go on with a schema
+// that is behind the service.
+func prepareSchema(ctx context.Context, store migratedStore, autoMigrate bool, logger *logrus.Logger) error {
+	if autoMigrate {
+		applied, err := store.MigrateMax(migrate.Up, 0)
+		if err != nil {
+			return fmt.Errorf("store.MigrateMax(migrate.Up, 0): %w", err)
+		}
+
+		if applied > 0 {
+			logger.Infof("%d migrations were applied", applied)
+		}
+	}
+
+	statuses, err := store.MigrationStatus()
+	if err != nil {
+		return fmt.Errorf("store.MigrationStatus(): %w", err)
+	}
+
+	if err = storage.CheckSchema(statuses); err != nil {
+		return fmt.Errorf("storage.CheckSchema(statuses): %w", err)
+	}
+
+	backfiller, ok := store.(interface {
+		BackfillPhoneticKeys(ctx context.Context) (int, error)
+	})
+	if !ok {
+		return nil
+	}
+
+	backfilled, err := backfiller.BackfillPhoneticKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("backfiller.BackfillPhoneticKeys(ctx): %w", err)
+	}
+
+	if backfilled > 0 {
+		logger.Infof("Phonetic keys of %d users were filled in", backfilled)
+	}
+
+	return nil
+}
+
+// runMigrate runs the migrate subcommand: up applies the pending migrations, down rolls back the last
+// applied one, redo rolls it back and applies it again and status lists the migrations.
+func runMigrate(ctx context.Context, cfg storage.Config, args []string, logger *logrus.Logger) error {
+	if len(args) != 1 {
+		return errMigrateUsage
+	}
+
+	switch args[0] {
+	case "up", "down", "status", "redo":
+	default:
+		return errMigrateUsage
+	}
+
+	if strings.HasPrefix(cfg.DSN, storage.MemoryScheme) {
+		return errNoSchema
+	}
+
+	store, err := connectStore(ctx, cfg, logger)
+	if err != nil {
+		return fmt.Errorf("connectStore(ctx, cfg, logger): %w", err)
+	}
+
+	defer store.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateMax(migrate.Up, 0)
+		if err != nil {
+			return fmt.Errorf("store.MigrateMax(migrate.Up, 0): %w", err)
+		}
+
+		logger.Infof("%d migrations were applied", applied)
+	case "down":
+		rolledBack, err := store.MigrateMax(migrate.Down, 1)
+		if err != nil {
+			return fmt.Errorf("store.MigrateMax(migrate.Down, 1): %w", err)
+		}
+
+		logger.Infof("%d migrations were rolled back", rolledBack)
+	case "redo":
+		rolledBack, err := store.MigrateMax(migrate.Down, 1)
+		if err != nil {
+			return fmt.Errorf("store.MigrateMax(migrate.Down, 1): %w", err)
+		}
+
+		if rolledBack == 0 {
+			return errNothingToRedo
+		}
+
+		if _, err = store.MigrateMax(migrate.Up, 1); err != nil {
+			return fmt.Errorf("store.MigrateMax(migrate.Up, 1): %w", err)
+		}
+
+		logger.Info("The last migration was redone")
+	case "status":
+		statuses, err := store.MigrationStatus()
+		if err != nil {
+			return fmt.Errorf("store.MigrationStatus(): %w", err)
+		}
+
+		return printMigrationStatus(statuses)
+	}
+
+	return nil
+}
+
+func printMigrationStatus(statuses []storage.MigrationStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(w, "MIGRATION\tAPPLIED AT")
+
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", status.ID, appliedAt)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("w.Flush(): %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package audit
+
+import "context"
+
+type ctxKey struct{}
+
+// Meta describes who made a change and within which request.
+type Meta struct {
+	Actor     string
+	RequestID string
+}
+
+func WithMeta(ctx context.Context, meta Meta) context.Context {
+	return context.WithValue(ctx, ctxKey{}, meta)
+}
+
+// FromContext returns the meta stored in ctx or an empty Meta for changes made outside of a request.
+func FromContext(ctx context.Context) Meta {
+	meta, _ := ctx.Value(ctxKey{}).(Meta)
+
+	return meta
+}
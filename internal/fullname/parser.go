@@ -0,0 +1,133 @@
+package fullname
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+const (
+	confidenceHigh   = 0.95
+	confidenceMedium = 0.8
+	confidenceLow    = 0.5
+)
+
+var particles = map[string]struct{}{
+	"al": {}, "bin": {}, "da": {}, "dal": {}, "de": {}, "del": {}, "della": {}, "den": {}, "der": {},
+	"di": {}, "dos": {}, "das": {}, "du": {}, "el": {}, "la": {}, "le": {}, "ten": {}, "ter": {},
+	"van": {}, "von": {}, "zu": {},
+}
+
+var patronymicSuffixes = []string{
+	"ovich", "evich", "ich", "ovna", "evna", "ichna", "inichna", "ogly", "kyzy",
+	"ович", "евич", "ич", "овна", "евна", "ична", "инична", "оглы", "кызы",
+}
+
+// Parse splits a full name into name, surname and patronymic. It recognises the Western
+// "First Middle Last" order, the Russian "Surname Name Patronymic" and "Name Patronymic Surname"
+// orders, the "Surname, Name" form and surname particles like "van der".
+func Parse(fullName string) (models.ParsedName, error) {
+	if surnamePart, namePart, ok := strings.Cut(fullName, ","); ok {
+		return parseCommaSeparated(surnamePart, namePart)
+	}
+
+	tokens := strings.Fields(fullName)
+	if !validTokens(tokens) {
+		return models.ParsedName{}, models.ErrFullNameNotValid
+	}
+
+	switch {
+	case len(tokens) == 1:
+		return models.ParsedName{Name: tokens[0], Confidence: confidenceLow}, nil
+	case len(tokens) == 3 && isPatronymic(tokens[2]) && !isPatronymic(tokens[1]):
+		return models.ParsedName{
+			Name:       tokens[1],
+			Surname:    tokens[0],
+			Patronymic: tokens[2],
+			Confidence: confidenceHigh,
+		}, nil
+	case len(tokens) == 3 && isPatronymic(tokens[1]):
+		return models.ParsedName{
+			Name:       tokens[0],
+			Surname:    tokens[2],
+			Patronymic: tokens[1],
+			Confidence: confidenceHigh,
+		}, nil
+	}
+
+	return parseWestern(tokens), nil
+}
+
+func parseCommaSeparated(surnamePart, namePart string) (models.ParsedName, error) {
+	surnameTokens := strings.Fields(surnamePart)
+	nameTokens := strings.Fields(namePart)
+
+	if !validTokens(surnameTokens) || !validTokens(nameTokens) {
+		return models.ParsedName{}, models.ErrFullNameNotValid
+	}
+
+	return models.ParsedName{
+		Name:       nameTokens[0],
+		Surname:    strings.Join(surnameTokens, " "),
+		Patronymic: strings.Join(nameTokens[1:], " "),
+		Confidence: confidenceHigh,
+	}, nil
+}
+
+func parseWestern(tokens []string) models.ParsedName {
+	surnameStart := len(tokens) - 1
+	for surnameStart > 1 && isParticle(tokens[surnameStart-1]) {
+		surnameStart--
+	}
+
+	confidence := confidenceHigh
+	if surnameStart < len(tokens)-1 {
+		confidence = confidenceMedium
+	}
+
+	if surnameStart > 2 {
+		confidence = confidenceLow
+	}
+
+	return models.ParsedName{
+		Name:       tokens[0],
+		Surname:    strings.Join(tokens[surnameStart:], " "),
+		Patronymic: strings.Join(tokens[1:surnameStart], " "),
+		Confidence: confidence,
+	}
+}
+
+func validTokens(tokens []string) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+
+	for _, token := range tokens {
+		for _, r := range token {
+			if !unicode.IsLetter(r) && r != '-' && r != '\'' && r != '.' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func isParticle(token string) bool {
+	_, ok := particles[strings.ToLower(token)]
+
+	return ok
+}
+
+func isPatronymic(token string) bool {
+	lower := strings.ToLower(token)
+
+	for _, suffix := range patronymicSuffixes {
+		if strings.HasSuffix(lower, suffix) && len([]rune(lower)) > len([]rune(suffix))+2 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,239 @@
+// Package importfile reads the users of the CSV and NDJSON files given to the import.
+package importfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	// maxLineSize limits an NDJSON line.
+	maxLineSize = 1 << 20
+)
+
+var (
+	ErrFormat = errors.New("format must be csv or ndjson")
+	ErrHeader = errors.New("header is not valid")
+)
+
+// ignoredColumns are written by the users export but set by the store, so an export can be imported back.
+var ignoredColumns = map[string]bool{"id": true, "created_at": true, "updated_at": true, "deleted_at": true}
+
+// ndjsonRow is a line of an NDJSON file; other fields, e.g. those of an export, are ignored.
+type ndjsonRow struct {
+	models.RequestEnrich
+	Age        int                    `json:"age"`
+	Gender     string                 `json:"gender"`
+	Country    string                 `json:"country"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// Read reads the users of the file. A row that cannot be read is returned with the reason instead of
+// failing the file; only an error of r or a broken CSV header or quoting fail it.
+func Read(r io.Reader, format string) ([]models.ImportRecord, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatNDJSON:
+		return readNDJSON(r)
+	}
+
+	return nil, ErrFormat
+}
+
+// FormatOf guesses the format by the file name, CSV unless it is .ndjson or .jsonl.
+func FormatOf(fileName string) string {
+	if strings.HasSuffix(fileName, ".ndjson") || strings.HasSuffix(fileName, ".jsonl") {
+		return FormatNDJSON
+	}
+
+	return FormatCSV
+}
+
+func readCSV(r io.Reader) ([]models.ImportRecord, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: the file is empty", ErrHeader)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reader.Read(): %w", err)
+	}
+
+	columns, err := parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]models.ImportRecord, 0)
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			records = append(records, models.ImportRecord{Line: parseErr.StartLine, Error: parseErr.Err.Error()})
+
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reader.Read(): %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		records = append(records, csvRecord(line, columns, row))
+	}
+}
+
+// parseHeader returns the column names, leaving the ignored columns empty.
+func parseHeader(header []string) ([]string, error) {
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+
+	for i, column := range header {
+		// Spreadsheets often save CSV with a byte order mark.
+		if i == 0 {
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+
+		column = strings.ToLower(strings.TrimSpace(column))
+
+		switch {
+		case seen[column]:
+			return nil, fmt.Errorf("%w: column %q is repeated", ErrHeader, column)
+		case ignoredColumns[column]:
+			seen[column] = true
+
+			continue
+		}
+
+		switch column {
+		case "name", "surname", "patronymic", "full_name", "age", "gender", "country", "attributes":
+		default:
+			return nil, fmt.Errorf("%w: unknown column %q", ErrHeader, column)
+		}
+
+		seen[column] = true
+		columns[i] = column
+	}
+
+	if !seen["name"] && !seen["full_name"] {
+		return nil, fmt.Errorf("%w: name or full_name column is required", ErrHeader)
+	}
+
+	return columns, nil
+}
+
+func csvRecord(line int, columns, row []string) models.ImportRecord {
+	record := models.ImportRecord{Line: line}
+	user := &record.User
+
+	for i, column := range columns {
+		value := strings.TrimSpace(row[i])
+
+		switch column {
+		case "name":
+			user.Name = value
+		case "surname":
+			user.Surname = value
+		case "patronymic":
+			user.Patronymic = value
+		case "full_name":
+			user.FullName = value
+		case "gender":
+			user.Gender = value
+		case "country":
+			user.Country = value
+		case "age":
+			if value == "" {
+				continue
+			}
+
+			age, err := strconv.Atoi(value)
+			if err != nil {
+				record.Error = "age must be an integer"
+
+				return record
+			}
+
+			user.Age = age
+		case "attributes":
+			if value == "" {
+				continue
+			}
+
+			if err := json.Unmarshal([]byte(value), &user.Attributes); err != nil {
+				record.Error = "attributes must be a JSON object"
+
+				return record
+			}
+		}
+	}
+
+	return record
+}
+
+func readNDJSON(r io.Reader) ([]models.ImportRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+
+	records := make([]models.ImportRecord, 0)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var row ndjsonRow
+
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			records = append(records, models.ImportRecord{Line: line, Error: ndjsonError(err)})
+
+			continue
+		}
+
+		records = append(records, models.ImportRecord{
+			Line: line,
+			User: models.ResponseEnrich{
+				RequestEnrich: row.RequestEnrich,
+				Age:           row.Age,
+				Gender:        row.Gender,
+				Country:       row.Country,
+				Attributes:    row.Attributes,
+			},
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Err(): %w", err)
+	}
+
+	return records, nil
+}
+
+// ndjsonError tells what is wrong with a line without the details of the decoding.
+func ndjsonError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Sprintf("%s has a wrong type", typeErr.Field)
+	}
+
+	return "line is not valid JSON"
+}
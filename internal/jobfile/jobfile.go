@@ -0,0 +1,212 @@
+// Package jobfile reads the CSV files uploaded for an enrichment job and writes them back with the results.
+package jobfile
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+var (
+	ErrHeader = errors.New("header is not valid")
+	ErrRow    = errors.New("row is not valid")
+)
+
+// Columns map the columns of the file to the parts of the name.
+type Columns struct {
+	Name       string
+	Surname    string
+	Patronymic string
+}
+
+var (
+	// DefaultColumns are taken when Columns leave a part empty; only the name column is required then.
+	DefaultColumns = Columns{Name: "name", Surname: "surname", Patronymic: "patronymic"}
+	// requestHeader is the header written for a job submitted without a file.
+	requestHeader = []string{"name", "surname", "patronymic", "full_name"}
+	// resultColumns are filled with the results; the ones the file lacks are added at the end.
+	resultColumns = []string{"age", "gender", "country", "error"}
+)
+
+// Read reads the header and the rows of the file as the items of a job, skipping blank rows. Column
+// names are compared case-insensitively. An item keeps the cells of its row, padded to the header.
+func Read(r io.Reader, columns Columns) ([]string, []models.JobItem, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("%w: the file is empty", ErrHeader)
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("reader.Read(): %w", err)
+	}
+
+	// Spreadsheets often save CSV with a byte order mark.
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	nameIndex, err := columnIndex(header, columns.Name, DefaultColumns.Name, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	surnameIndex, err := columnIndex(header, columns.Surname, DefaultColumns.Surname, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	patronymicIndex, err := columnIndex(header, columns.Patronymic, DefaultColumns.Patronymic, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := make([]models.JobItem, 0)
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return header, items, nil
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("reader.Read(): %w", err)
+		}
+
+		if len(row) > len(header) {
+			line, _ := reader.FieldPos(0)
+
+			return nil, nil, fmt.Errorf("%w: line %d has more values than the header", ErrRow, line)
+		}
+
+		if isBlank(row) {
+			continue
+		}
+
+		row = append(row, make([]string, len(header)-len(row))...)
+		items = append(items, models.JobItem{
+			Request: models.RequestEnrich{
+				Name:       cell(row, nameIndex),
+				Surname:    cell(row, surnameIndex),
+				Patronymic: cell(row, patronymicIndex),
+			},
+			Cells: row,
+		})
+	}
+}
+
+// Write writes the items of the job with their results. The header of the file is kept, or the parts of
+// the name are written for a job submitted without a file.
+func Write(w io.Writer, job models.Job) error {
+	header := job.Header
+	if header == nil {
+		header = requestHeader
+	}
+
+	header = append([]string(nil), header...)
+	resultIndex := make([]int, len(resultColumns))
+
+	for i, column := range resultColumns {
+		resultIndex[i] = findColumn(header, column)
+		if resultIndex[i] < 0 {
+			resultIndex[i] = len(header)
+			header = append(header, column)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("writer.Write(header): %w", err)
+	}
+
+	for _, item := range job.Items {
+		cells := item.Cells
+		if job.Header == nil {
+			cells = []string{item.Request.Name, item.Request.Surname, item.Request.Patronymic, item.Request.FullName}
+		}
+
+		row := make([]string, len(header))
+		copy(row, cells)
+
+		for i, value := range resultValues(item) {
+			row[resultIndex[i]] = value
+		}
+
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("writer.Write(row): %w", err)
+		}
+	}
+
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("writer.Error(): %w", err)
+	}
+
+	return nil
+}
+
+// resultValues are the values of resultColumns for the item.
+func resultValues(item models.JobItem) []string {
+	if item.Status != models.JobItemDone || item.User == nil {
+		return []string{"", "", "", item.Error}
+	}
+
+	age := ""
+	if item.User.Age != 0 {
+		age = strconv.Itoa(item.User.Age)
+	}
+
+	return []string{age, item.User.Gender, item.User.Country, ""}
+}
+
+// columnIndex finds the column given for a part of the name or, when none is given, the default one.
+// It returns -1 for a missing optional column.
+func columnIndex(header []string, column, defaultColumn string, required bool) (int, error) {
+	if column == "" {
+		column = defaultColumn
+	} else {
+		required = true
+	}
+
+	i := findColumn(header, column)
+	if i < 0 && required {
+		return -1, fmt.Errorf("%w: column %q is missing", ErrHeader, column)
+	}
+
+	return i, nil
+}
+
+func findColumn(header []string, column string) int {
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(column)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func cell(row []string, i int) string {
+	if i < 0 {
+		return ""
+	}
+
+	return strings.TrimSpace(row[i])
+}
+
+func isBlank(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+
+	return true
+}
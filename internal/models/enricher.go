@@ -16,3 +16,10 @@ type CountryEnriched struct {
 type CountryEnrichedList struct {
 	Country []CountryEnriched `json:"country"`
 }
+
+type CountryScore struct {
+	CountryID           string  `json:"country_id"`
+	Probability         float64 `json:"probability"`
+	NameContribution    float64 `json:"name_contribution"`
+	SurnameContribution float64 `json:"surname_contribution"`
+}
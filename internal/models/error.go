@@ -2,4 +2,16 @@ package models
 
 import "errors"
 
-var ErrNameNotValid = errors.New("name is not valid")
+var (
+	ErrNameNotValid     = errors.New("name is not valid")
+	ErrUserNotFound     = errors.New("no such user")
+	ErrFullNameNotValid = errors.New("full name is not valid")
+	ErrUserAmbiguous    = errors.New("more than one user has the name")
+	ErrCursorNotValid   = errors.New("cursor is not valid")
+	ErrSortNotValid     = errors.New("sort is not valid")
+	ErrPageNotValid     = errors.New("page is not valid")
+	ErrJobNotValid      = errors.New("job is not valid")
+	ErrQuotaExceeded    = errors.New("provider quota is exceeded")
+	ErrProviderFailed   = errors.New("provider request failed")
+	ErrLockNotValid     = errors.New("lock is not valid")
+)
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Statuses of an enrichment job.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+)
+
+// Statuses of an item of an enrichment job. Failed items are retried as pending until they run out of
+// attempts.
+const (
+	JobItemPending = "pending"
+	JobItemRunning = "running"
+	JobItemDone    = "done"
+	JobItemFailed  = "failed"
+)
+
+type RequestJob struct {
+	Items []RequestEnrich `json:"items"`
+}
+
+// Job enriches a list of names in the background.
+type Job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Total  int    `json:"total"`
+	Done   int    `json:"done"`
+	Failed int    `json:"failed"`
+	// FileName is the name of the uploaded CSV the job enriches.
+	FileName string `json:"file_name,omitempty"`
+	// Items are only returned when a single job is requested.
+	Items []JobItem `json:"items,omitempty"`
+	// Header is the header of the uploaded CSV, written back with the result.
+	Header []string `json:"-"`
+	// Actor and RequestID of the submission are recorded in the history of the users the job saves.
+	Actor      string     `json:"-"`
+	RequestID  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobItem is a name of a job and the result of its enrichment.
+type JobItem struct {
+	JobID    string          `json:"-"`
+	Position int             `json:"position"`
+	Request  RequestEnrich   `json:"request"`
+	Status   string          `json:"status"`
+	Attempts int             `json:"attempts"`
+	User     *ResponseEnrich `json:"user,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	// Cells are the values of the CSV row the item was read from.
+	Cells []string `json:"-"`
+	// Actor and RequestID of the job are set on claimed items.
+	Actor     string `json:"-"`
+	RequestID string `json:"-"`
+	// NotBefore delays the next claim of a pending item that failed; nil means it is claimed at once.
+	NotBefore *time.Time `json:"-"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// RefreshRun is a re-enrichment of the users whose provider values are stale.
+type RefreshRun struct {
+	ID         string    `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Checked is the number of users the providers were requested for.
+	Checked int `json:"checked"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+	// Stopped tells why the run ended before its batch did, e.g. a provider quota.
+	Stopped string `json:"stopped,omitempty"`
+	// Changes are only returned when a single run is requested.
+	Changes []RefreshChange `json:"changes,omitempty"`
+}
+
+// RefreshChange is a value of a user changed by a refresh run.
+type RefreshChange struct {
+	UserID   string      `json:"user_id"`
+	Name     string      `json:"name"`
+	Field    string      `json:"field"`
+	OldValue interface{} `json:"old_value"`
+	NewValue interface{} `json:"new_value"`
+}
@@ -1,22 +1,162 @@
 package models
 
+import "time"
+
+const (
+	FieldAge     = "age"
+	FieldGender  = "gender"
+	FieldCountry = "country"
+	// SourceManual is the provenance source of values set through the update endpoints.
+	SourceManual = "manual"
+)
+
 type RequestEnrich struct {
 	Name       string `json:"name"`
 	Surname    string `json:"surname"`
 	Patronymic string `json:"patronymic"`
+	FullName   string `json:"full_name,omitempty"`
 }
 
 type ResponseEnrich struct {
+	ID string `json:"id"`
 	RequestEnrich
-	Age     int    `json:"age"`
-	Gender  string `json:"gender"`
-	Country string `json:"country"`
+	Age       int            `json:"age"`
+	Gender    string         `json:"gender"`
+	Country   string         `json:"country"`
+	Countries []CountryScore `json:"countries,omitempty"`
+	// Attributes holds the values of custom enrichment dimensions by their names.
+	Attributes      map[string]interface{} `json:"attributes,omitempty"`
+	ParseConfidence float64                `json:"parse_confidence,omitempty"`
+	// Provenance tells where the value of every enriched field (age, gender, country and
+	// attributes) came from.
+	Provenance map[string]Provenance `json:"provenance,omitempty"`
+	CreatedAt  time.Time             `json:"created_at"`
+	UpdatedAt  time.Time             `json:"updated_at"`
+	DeletedAt  *time.Time            `json:"deleted_at,omitempty"`
+	// Score is the relevance of the user to the search query of the listing.
+	Score float32 `json:"score,omitempty"`
+	// Locks locks or unlocks fields by their names in an update request.
+	Locks map[string]bool `json:"locks,omitempty"`
+}
+
+type Provenance struct {
+	Source    string    `json:"source"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Manual    bool      `json:"manual"`
+	// Locked values are never replaced by provider results. Manually set values are locked.
+	Locked bool `json:"locked"`
+}
+
+type RequestParse struct {
+	FullName string `json:"full_name"`
+}
+
+type ParsedName struct {
+	Name       string  `json:"name"`
+	Surname    string  `json:"surname"`
+	Patronymic string  `json:"patronymic"`
+	Confidence float64 `json:"confidence"`
 }
 
 type ListingQueryParams struct {
-	TextFilter   string
+	TextFilter string
+	// Query searches users by name, surname and patronymic tolerating typos.
+	Query        string
 	ItemsPerPage int
 	Offset       int
-	Sorting      string
-	Descending   bool
+	// Sort orders users by the fields in turn; empty means by name.
+	Sort []SortKey
+	// Attributes filters users by exact values of custom enrichment dimensions.
+	Attributes    map[string]string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// ManualOverride filters users whose field was set manually.
+	ManualOverride string
+	IncludeDeleted bool
+	// AgeMin and AgeMax bound the age inclusively when set.
+	AgeMin *int
+	AgeMax *int
+	Gender string
+	// Countries filters users whose country is one of the codes.
+	Countries     []string
+	SurnamePrefix string
+	// Cursor continues the listing from a page returned earlier; Offset is ignored with it.
+	Cursor string
+	// EstimateTotal takes the total from the planner statistics instead of counting the users.
+	EstimateTotal bool
+}
+
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// UsersPage is a page of the users list with the cursors of the neighbouring pages.
+type UsersPage struct {
+	Items          []ResponseEnrich `json:"items"`
+	Total          int64            `json:"total"`
+	TotalEstimated bool             `json:"total_estimated,omitempty"`
+	NextCursor     string           `json:"next_cursor,omitempty"`
+	PrevCursor     string           `json:"prev_cursor,omitempty"`
+}
+
+const (
+	OperationCreate  = "create"
+	OperationUpdate  = "update"
+	OperationDelete  = "delete"
+	OperationRestore = "restore"
+	OperationPurge   = "purge"
+)
+
+type HistoryRecord struct {
+	ID        int64           `json:"id"`
+	UserID    string          `json:"user_id"`
+	Name      string          `json:"name"`
+	Operation string          `json:"operation"`
+	OldValue  *ResponseEnrich `json:"old_value"`
+	NewValue  *ResponseEnrich `json:"new_value"`
+	Actor     string          `json:"actor"`
+	RequestID string          `json:"request_id"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SourceImport is the provenance source of values taken from an import file.
+const SourceImport = "import"
+
+// Statuses of the rows of an import.
+const (
+	ImportInserted = "inserted"
+	ImportUpdated  = "updated"
+	ImportRejected = "rejected"
+)
+
+// ImportRecord is a row of an import file. Missing values are zero; Error tells why the row could
+// not be read.
+type ImportRecord struct {
+	Line  int
+	User  ResponseEnrich
+	Error string
+}
+
+// ImportedUser is a user saved by an import with the operation it was saved with.
+type ImportedUser struct {
+	User      ResponseEnrich
+	Operation string
+}
+
+type ImportRowReport struct {
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport tells what became of every row of an import file, in the order of the file.
+type ImportReport struct {
+	Inserted int               `json:"inserted"`
+	Updated  int               `json:"updated"`
+	Rejected int               `json:"rejected"`
+	Rows     []ImportRowReport `json:"rows"`
 }
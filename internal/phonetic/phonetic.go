@@ -0,0 +1,39 @@
+package phonetic
+
+import (
+	"strings"
+
+	"github.com/antzucaro/matchr"
+)
+
+// cyrillic maps Cyrillic letters to their Latin transliteration, so that Double Metaphone, which
+// only knows Latin spelling rules, can encode Russian and Ukrainian names.
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// Keys returns the primary and alternate Double Metaphone codes of the name. Names spelled in
+// Cyrillic are transliterated first, so "Екатерина" gets the codes of "Ekaterina". Both codes are
+// empty for a name without letters.
+func Keys(name string) (primary, alternate string) {
+	return matchr.DoubleMetaphone(transliterate(name))
+}
+
+func transliterate(name string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(name) {
+		if latin, ok := cyrillic[r]; ok {
+			b.WriteString(latin)
+
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// NotFoundMissing treats only an absent or null value as not found.
+	NotFoundMissing = "missing"
+	// NotFoundEmpty additionally treats zero values, empty strings and empty lists as not found.
+	NotFoundEmpty = "empty"
+)
+
+var ErrConfigNotValid = errors.New("provider config is not valid")
+
+type Config struct {
+	// Name identifies the provider, e.g. "age".
+	Name string `mapstructure:"name"`
+	// Source is recorded as the provenance of enriched values; the URL host by default.
+	Source string `mapstructure:"source"`
+	// URL is a text/template executed with the name being enriched, e.g. "https://api.agify.io/".
+	URL string `mapstructure:"url"`
+	// QueryParams are added to the URL; values are templates like "{{.Name}}".
+	QueryParams map[string]string `mapstructure:"query_params"`
+	// Headers are sent with every request; values are templates and may read env, e.g. `{{env "HR_TOKEN"}}`.
+	Headers map[string]string `mapstructure:"headers"`
+	// ValuePath is a dot separated path to the value in the JSON response, e.g. "country.0.country_id".
+	ValuePath string `mapstructure:"value_path"`
+	// CountPath is a dot separated path to the number of samples the prediction is based on, e.g. "count".
+	CountPath string `mapstructure:"count_path"`
+	// NotFound is the rule deciding when the name is not valid for the provider: "missing" or "empty".
+	NotFound string `mapstructure:"not_found"`
+	// Metric is the name of the response duration histogram, e.g. "age_enrich_duration".
+	Metric string `mapstructure:"metric"`
+	// Timeout limits a single request to the provider; zero means no limit.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("%w: name is required", ErrConfigNotValid)
+	case c.URL == "":
+		return fmt.Errorf("%w: %s: url is required", ErrConfigNotValid, c.Name)
+	case c.ValuePath == "":
+		return fmt.Errorf("%w: %s: value_path is required", ErrConfigNotValid, c.Name)
+	case c.Metric == "":
+		return fmt.Errorf("%w: %s: metric is required", ErrConfigNotValid, c.Name)
+	case c.NotFound != "" && c.NotFound != NotFoundMissing && c.NotFound != NotFoundEmpty:
+		return fmt.Errorf("%w: %s: unknown not_found rule %q", ErrConfigNotValid, c.Name, c.NotFound)
+	}
+
+	return nil
+}
@@ -1,4 +1,4 @@
-package gender
+package provider
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
@@ -9,14 +9,14 @@ type metrics struct {
 	duration prometheus.Histogram
 }
 
-func newMetrics() *metrics {
+func newMetrics(name, provider string) *metrics {
 	return &metrics{
 		duration: promauto.NewHistogram(
 			prometheus.HistogramOpts{
 				Namespace: "name_enricher_service",
 				Subsystem: "",
-				Name:      "gender_enrich_duration",
-				Help:      "gender enrichment server response duration",
+				Name:      name,
+				Help:      provider + " enrichment server response duration",
 				Buckets:   []float64{0.0001, 0.0005, 0.001, 0.003, 0.005, 0.01, 0.05, 0.1, 1},
 			}),
 	}
@@ -0,0 +1,291 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+type Provider struct {
+	name        string
+	source      string
+	url         *template.Template
+	queryParams map[string]*template.Template
+	headers     map[string]*template.Template
+	valuePath   []string
+	countPath   []string
+	notFound    string
+	client      *http.Client
+	log         *logrus.Entry
+	metrics     *metrics
+}
+
+type templateData struct {
+	Name string
+}
+
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+func New(cfg Config, log *logrus.Logger) (*Provider, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	urlTemplate, err := template.New(cfg.Name).Funcs(templateFuncs).Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("template.Parse(cfg.URL): %w", err)
+	}
+
+	queryParams, err := parseTemplates(cfg.Name, cfg.QueryParams)
+	if err != nil {
+		return nil, fmt.Errorf("parseTemplates(cfg.Name, cfg.QueryParams): %w", err)
+	}
+
+	headers, err := parseTemplates(cfg.Name, cfg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("parseTemplates(cfg.Name, cfg.Headers): %w", err)
+	}
+
+	source := cfg.Source
+	if source == "" {
+		source = cfg.Name
+
+		if u, err := url.Parse(cfg.URL); err == nil && u.Host != "" {
+			source = u.Host
+		}
+	}
+
+	var countPath []string
+	if cfg.CountPath != "" {
+		countPath = strings.Split(cfg.CountPath, ".")
+	}
+
+	notFound := cfg.NotFound
+	if notFound == "" {
+		notFound = NotFoundEmpty
+	}
+
+	return &Provider{
+		name:        cfg.Name,
+		source:      source,
+		url:         urlTemplate,
+		queryParams: queryParams,
+		headers:     headers,
+		valuePath:   strings.Split(cfg.ValuePath, "."),
+		countPath:   countPath,
+		notFound:    notFound,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		log:         log.WithField("module", cfg.Name),
+		metrics:     newMetrics(cfg.Metric, cfg.Name),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// Source identifies where the provider values come from, e.g. "api.agify.io".
+func (p *Provider) Source() string {
+	return p.source
+}
+
+// Resolve requests the provider for the name and decodes the value found at the configured path
+// into dest. It returns models.ErrNameNotValid when the value matches the not-found rule.
+func (p *Provider) Resolve(ctx context.Context, name string, dest interface{}) error {
+	_, err := p.ResolveCounted(ctx, name, dest)
+
+	return err
+}
+
+// ResolveCounted is Resolve that also returns the number of samples the prediction is based on,
+// read at the configured count path. The count is -1 when the provider does not report it.
+func (p *Provider) ResolveCounted(ctx context.Context, name string, dest interface{}) (int, error) {
+	endpoint, err := p.endpoint(name)
+	if err != nil {
+		return -1, fmt.Errorf("p.endpoint(name): %w", err)
+	}
+
+	var respData interface{}
+
+	if err = p.sendRequest(ctx, endpoint, name, &respData); err != nil {
+		return -1, fmt.Errorf("p.sendRequest(ctx, endpoint, name, &respData): %w", err)
+	}
+
+	value, ok := lookup(respData, p.valuePath)
+	if !ok || value == nil || (p.notFound == NotFoundEmpty && isEmpty(value)) {
+		return -1, models.ErrNameNotValid
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return -1, fmt.Errorf("json.Marshal(value): %w", err)
+	}
+
+	if err = json.Unmarshal(raw, dest); err != nil {
+		return -1, fmt.Errorf("json.Unmarshal(raw, dest): %w", err)
+	}
+
+	count := -1
+
+	if p.countPath != nil {
+		if n, ok := lookup(respData, p.countPath); ok {
+			if f, ok := n.(float64); ok {
+				count = int(f)
+			}
+		}
+	}
+
+	return count, nil
+}
+
+func (p *Provider) endpoint(name string) (string, error) {
+	data := templateData{Name: name}
+
+	rawURL, err := execute(p.url, data)
+	if err != nil {
+		return "", fmt.Errorf("execute(p.url, data): %w", err)
+	}
+
+	endpoint, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("url.Parse(rawURL): %w", err)
+	}
+
+	query := endpoint.Query()
+
+	for key, tmpl := range p.queryParams {
+		value, err := execute(tmpl, data)
+		if err != nil {
+			return "", fmt.Errorf("execute(tmpl, data): %w", err)
+		}
+
+		query.Set(key, value)
+	}
+
+	endpoint.RawQuery = query.Encode()
+
+	return endpoint.String(), nil
+}
+
+func (p *Provider) sendRequest(ctx context.Context, endpoint, name string, respData interface{}) error {
+	started := time.Now()
+	defer func() {
+		p.metrics.duration.Observe(time.Since(started).Seconds())
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil): %w", err)
+	}
+
+	for key, tmpl := range p.headers {
+		value, err := execute(tmpl, templateData{Name: name})
+		if err != nil {
+			return fmt.Errorf("execute(tmpl, data): %w", err)
+		}
+
+		request.Header.Set(key, value)
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("p.client.Do(request): %w", err)
+	}
+
+	defer func() {
+		err = response.Body.Close()
+		if err != nil {
+			p.log.Warningf("response.Body.Close(): %s", err)
+		}
+	}()
+
+	// The body of a rejected or failed request has no prediction and would be taken for an unknown name.
+	if response.StatusCode == http.StatusTooManyRequests {
+		return models.ErrQuotaExceeded
+	}
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: %s answered %s", models.ErrProviderFailed, p.name, response.Status)
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(&respData); err != nil {
+		return fmt.Errorf("json.NewDecoder(response.Body).Decode(&respData): %w", err)
+	}
+
+	return nil
+}
+
+func parseTemplates(name string, values map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(values))
+
+	for key, value := range values {
+		tmpl, err := template.New(name + "." + key).Funcs(templateFuncs).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("template.Parse(value): %w", err)
+		}
+
+		templates[key] = tmpl
+	}
+
+	return templates, nil
+}
+
+func execute(tmpl *template.Template, data templateData) (string, error) {
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("tmpl.Execute(&buf, data): %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+func lookup(value interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func isEmpty(value interface{}) bool {
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() { //nolint:exhaustive
+	case reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
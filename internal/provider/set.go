@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Set holds the configured providers by name.
+type Set map[string]*Provider
+
+func NewSet(configs []Config, log *logrus.Logger) (Set, error) {
+	set := make(Set, len(configs))
+	metricNames := make(map[string]struct{}, len(configs))
+
+	for _, cfg := range configs {
+		if _, ok := set[cfg.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate provider %q", ErrConfigNotValid, cfg.Name)
+		}
+
+		if _, ok := metricNames[cfg.Metric]; ok {
+			return nil, fmt.Errorf("%w: duplicate metric %q", ErrConfigNotValid, cfg.Metric)
+		}
+
+		p, err := New(cfg, log)
+		if err != nil {
+			return nil, fmt.Errorf("New(cfg, log): %w", err)
+		}
+
+		set[cfg.Name] = p
+		metricNames[cfg.Metric] = struct{}{}
+	}
+
+	return set, nil
+}
+
+// Get returns the provider with the given name or an error if it is not configured.
+func (s Set) Get(name string) (*Provider, error) {
+	p, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: provider %q is not configured", ErrConfigNotValid, name)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+	// exportFlushEvery is the number of users written between flushes of the response.
+	exportFlushEvery = 100
+)
+
+var errExportFormat = errors.New("format must be csv or ndjson")
+
+// exportCSVHeader names the CSV columns; nested countries and provenance are only exported as NDJSON.
+var exportCSVHeader = []string{
+	"id", "name", "surname", "patronymic", "age", "gender", "country", "attributes", "created_at", "updated_at",
+	"deleted_at",
+}
+
+// parseExportParams reads the export query: the format and the filters and sort of the users list.
+// The whole list is exported, so pagination params are unknown to it.
+func parseExportParams(query url.Values) (models.ListingQueryParams, string, error) {
+	format := query.Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		return models.ListingQueryParams{}, "", errExportFormat
+	}
+
+	listingQuery := url.Values{}
+
+	for key, values := range query {
+		switch key {
+		case "format":
+			continue
+		case "itemsPerPage", "offset", "cursor", "estimateTotal":
+			return models.ListingQueryParams{}, "", fmt.Errorf("%w: %s", errFilterUnknown, key)
+		}
+
+		listingQuery[key] = values
+	}
+
+	params, err := parseListingParams(listingQuery)
+	if err != nil {
+		return models.ListingQueryParams{}, "", err
+	}
+
+	return params, format, nil
+}
+
+// userExporter writes users to the response as they come. The headers are sent with the first user,
+// so an error that happens before it can still be answered with a status code.
+type userExporter struct {
+	w       http.ResponseWriter
+	format  string
+	started bool
+	written int
+	csv     *csv.Writer
+	json    *json.Encoder
+}
+
+func newUserExporter(w http.ResponseWriter, format string) *userExporter {
+	return &userExporter{w: w, format: format}
+}
+
+func (e *userExporter) start() error {
+	e.started = true
+
+	contentType := "text/csv; charset=utf-8"
+	if e.format == exportFormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+
+	fileName := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102T150405Z"), e.format)
+
+	e.w.Header().Set("Content-Type", contentType)
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	e.w.WriteHeader(http.StatusOK)
+
+	if e.format == exportFormatNDJSON {
+		e.json = json.NewEncoder(e.w)
+
+		return nil
+	}
+
+	e.csv = csv.NewWriter(e.w)
+
+	if err := e.csv.Write(exportCSVHeader); err != nil {
+		return fmt.Errorf("e.csv.Write(exportCSVHeader): %w", err)
+	}
+
+	return nil
+}
+
+func (e *userExporter) write(user models.ResponseEnrich) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	if e.json != nil {
+		if err := e.json.Encode(user); err != nil {
+			return fmt.Errorf("e.json.Encode(user): %w", err)
+		}
+	} else if err := e.csv.Write(csvRecord(user)); err != nil {
+		return fmt.Errorf("e.csv.Write: %w", err)
+	}
+
+	e.written++
+	if e.written%exportFlushEvery == 0 {
+		return e.flush()
+	}
+
+	return nil
+}
+
+// finish sends the headers of an empty export and flushes the rest of the users.
+func (e *userExporter) finish() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	return e.flush()
+}
+
+func (e *userExporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+
+		if err := e.csv.Error(); err != nil {
+			return fmt.Errorf("e.csv.Error(): %w", err)
+		}
+	}
+
+	if err := http.NewResponseController(e.w).Flush(); err != nil {
+		return fmt.Errorf("http.NewResponseController(e.w).Flush(): %w", err)
+	}
+
+	return nil
+}
+
+func csvRecord(user models.ResponseEnrich) []string {
+	attributes := ""
+
+	if len(user.Attributes) > 0 {
+		raw, err := json.Marshal(user.Attributes)
+		if err == nil {
+			attributes = string(raw)
+		}
+	}
+
+	deletedAt := ""
+	if user.DeletedAt != nil {
+		deletedAt = user.DeletedAt.Format(time.RFC3339Nano)
+	}
+
+	return []string{
+		user.ID,
+		user.Name,
+		user.Surname,
+		user.Patronymic,
+		strconv.Itoa(user.Age),
+		user.Gender,
+		user.Country,
+		attributes,
+		user.CreatedAt.Format(time.RFC3339Nano),
+		user.UpdatedAt.Format(time.RFC3339Nano),
+		deletedAt,
+	}
+}
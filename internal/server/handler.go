@@ -5,15 +5,26 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"strconv"
+	"strings"
 
+	"github.com/AlexZav1327/name-enricher/internal/importfile"
+	"github.com/AlexZav1327/name-enricher/internal/jobfile"
 	"github.com/AlexZav1327/name-enricher/internal/models"
 	"github.com/AlexZav1327/name-enricher/internal/storage"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
-const defaultLimit = 20
+const (
+	defaultLimit          = 20
+	attributeFilterPrefix = "attr."
+	// maxItemsPerPage limits the users listed at once.
+	maxItemsPerPage = 100
+	// statusClientClosedRequest is the non-standard code nginx uses when the client goes away
+	// before the response is ready.
+	statusClientClosedRequest = 499
+)
 
 type Handler struct {
 	service EnricherService
@@ -22,10 +33,23 @@ type Handler struct {
 }
 
 type EnricherService interface {
+	ParseFullName(ctx context.Context, fullName string) (models.ParsedName, error)
 	EnrichUser(ctx context.Context, userName models.RequestEnrich) (models.ResponseEnrich, error)
-	GetUsersList(ctx context.Context, params models.ListingQueryParams) ([]models.ResponseEnrich, error)
+	GetUsersList(ctx context.Context, params models.ListingQueryParams) (models.UsersPage, error)
+	ExportUsers(ctx context.Context, params models.ListingQueryParams, fn func(user models.ResponseEnrich) error) error
+	ImportUsers(ctx context.Context, records []models.ImportRecord, enrich bool) (models.ImportReport, error)
+	GetUser(ctx context.Context, userID string) (models.ResponseEnrich, error)
+	FindUserID(ctx context.Context, userName string) (string, error)
+	FindSimilarUsers(ctx context.Context, userName string) ([]models.ResponseEnrich, error)
 	UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error)
-	DeleteUser(ctx context.Context, userName string) error
+	DeleteUser(ctx context.Context, userID string) error
+	RestoreUser(ctx context.Context, userID string) (models.ResponseEnrich, error)
+	GetUserHistory(ctx context.Context, userRef string) ([]models.HistoryRecord, error)
+	SubmitJob(ctx context.Context, requests []models.RequestEnrich) (models.Job, error)
+	SubmitJobFile(ctx context.Context, fileName string, header []string, items []models.JobItem) (models.Job, error)
+	GetJob(ctx context.Context, jobID string) (models.Job, error)
+	GetRefreshRuns(ctx context.Context, limit int) ([]models.RefreshRun, error)
+	GetRefreshRun(ctx context.Context, runID string) (models.RefreshRun, error)
 }
 
 func NewHandler(service EnricherService, log *logrus.Logger) *Handler {
@@ -36,6 +60,35 @@ func NewHandler(service EnricherService, log *logrus.Logger) *Handler {
 	}
 }
 
+func (h *Handler) parse(w http.ResponseWriter, r *http.Request) {
+	var req models.RequestParse
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	parsedName, err := h.service.ParseFullName(r.Context(), req.FullName)
+	if errors.Is(err, models.ErrFullNameNotValid) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(parsedName); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(parsedName): %s", err)
+	}
+}
+
 func (h *Handler) enrich(w http.ResponseWriter, r *http.Request) {
 	var userName models.RequestEnrich
 
@@ -46,12 +99,43 @@ func (h *Handler) enrich(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userNameEnriched, err := h.service.EnrichUser(r.Context(), userName)
+	if errors.Is(err, context.Canceled) {
+		h.log.Infof("client closed request: %s", err)
+		w.WriteHeader(statusClientClosedRequest)
+
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+
+		return
+	}
+
+	if errors.Is(err, models.ErrFullNameNotValid) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
 	if errors.Is(err, models.ErrNameNotValid) {
 		w.WriteHeader(http.StatusNotFound)
 
 		return
 	}
 
+	if errors.Is(err, models.ErrQuotaExceeded) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	if errors.Is(err, models.ErrProviderFailed) {
+		w.WriteHeader(http.StatusBadGateway)
+
+		return
+	}
+
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 
@@ -67,20 +151,110 @@ func (h *Handler) enrich(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) getList(w http.ResponseWriter, r *http.Request) {
-	var params models.ListingQueryParams
+	params, err := parseListingParams(r.URL.Query())
+	if err != nil {
+		h.log.Infof("parseListingParams: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	usersPage, err := h.service.GetUsersList(r.Context(), params)
+	if errors.Is(err, models.ErrCursorNotValid) || errors.Is(err, models.ErrSortNotValid) ||
+		errors.Is(err, models.ErrPageNotValid) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	if link := pageLinks(r.URL, usersPage); link != "" {
+		w.Header().Set("Link", link)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(usersPage); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(usersPage): %s", err)
+	}
+}
+
+func (h *Handler) export(w http.ResponseWriter, r *http.Request) {
+	params, format, err := parseExportParams(r.URL.Query())
+	if err != nil {
+		h.log.Infof("parseExportParams: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	exporter := newUserExporter(w, format)
+
+	err = h.service.ExportUsers(r.Context(), params, exporter.write)
+	if err == nil {
+		err = exporter.finish()
+	}
+
+	if err == nil {
+		return
+	}
+
+	// Once the users are being sent the status cannot change: the client gets a truncated file.
+	if exporter.started {
+		h.log.Warningf("export interrupted after %d users: %s", exporter.written, err)
+
+		return
+	}
+
+	if errors.Is(err, models.ErrSortNotValid) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// importUsers saves the users of the CSV or NDJSON file in the body. Rows that are not valid are rejected
+// in the report; only a file that cannot be read at all fails the request.
+func (h *Handler) importUsers(w http.ResponseWriter, r *http.Request) {
+	format, enrich, err := parseImportParams(r.URL.Query())
+	if err != nil {
+		h.log.Infof("parseImportParams: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
 
-	params.TextFilter = r.URL.Query().Get("textFilter")
+		return
+	}
+
+	records, err := importfile.Read(http.MaxBytesReader(w, r.Body, maxImportSize), format)
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if err != nil {
+		h.log.Infof("importfile.Read: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
 
-	params.ItemsPerPage, _ = strconv.Atoi(r.URL.Query().Get("itemsPerPage"))
-	if params.ItemsPerPage == 0 {
-		params.ItemsPerPage = defaultLimit
+		return
 	}
 
-	params.Offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
-	params.Sorting = r.URL.Query().Get("sorting")
-	params.Descending, _ = strconv.ParseBool(r.URL.Query().Get("descending"))
+	report, err := h.service.ImportUsers(r.Context(), records, enrich)
+	if errors.Is(err, context.Canceled) {
+		h.log.Infof("client closed request: %s", err)
+		w.WriteHeader(statusClientClosedRequest)
+
+		return
+	}
 
-	usersList, err := h.service.GetUsersList(r.Context(), params)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 
@@ -89,12 +263,101 @@ func (h *Handler) getList(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 
-	if err = json.NewEncoder(w).Encode(usersList); err != nil {
-		h.log.Warningf("json.NewEncoder(w).Encode(usersList): %s", err)
+	if err = json.NewEncoder(w).Encode(report); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(report): %s", err)
+	}
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.service.GetUser(r.Context(), userID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(user); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(user): %s", err)
+	}
+}
+
+func (h *Handler) getSimilar(w http.ResponseWriter, r *http.Request) {
+	userName := r.URL.Query().Get("name")
+	if strings.TrimSpace(userName) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	users, err := h.service.FindSimilarUsers(r.Context(), userName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(users); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(users): %s", err)
+	}
+}
+
+// getHistory accepts either a user ID or a name, as the history of deleted users can only be
+// found by name.
+func (h *Handler) getHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := h.service.GetUserHistory(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	if len(history) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(history); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(history): %s", err)
 	}
 }
 
 func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDByName(w, r)
+	if !ok {
+		return
+	}
+
+	h.updateUser(w, r, userID)
+}
+
+func (h *Handler) updateByID(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.updateUser(w, r, userID)
+}
+
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request, userID string) {
 	var user models.ResponseEnrich
 
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
@@ -103,15 +366,27 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user.Name = chi.URLParam(r, "name")
+	user.ID = userID
 
 	updatedUser, err := h.service.UpdateUser(r.Context(), user)
+	if errors.Is(err, models.ErrLockNotValid) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
 	if errors.Is(err, storage.ErrUserNotFound) {
 		w.WriteHeader(http.StatusNotFound)
 
 		return
 	}
 
+	if errors.Is(err, storage.ErrUserExists) {
+		w.WriteHeader(http.StatusConflict)
+
+		return
+	}
+
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 
@@ -126,9 +401,25 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
-	userName := chi.URLParam(r, "name")
+	userID, ok := h.userIDByName(w, r)
+	if !ok {
+		return
+	}
+
+	h.deleteUser(w, r, userID)
+}
+
+func (h *Handler) deleteByID(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.deleteUser(w, r, userID)
+}
 
-	err := h.service.DeleteUser(r.Context(), userName)
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, userID string) {
+	err := h.service.DeleteUser(r.Context(), userID)
 	if errors.Is(err, storage.ErrUserNotFound) {
 		w.WriteHeader(http.StatusNotFound)
 
@@ -143,3 +434,282 @@ func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *Handler) restore(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	restoredUser, err := h.service.RestoreUser(r.Context(), userID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	if errors.Is(err, storage.ErrUserExists) {
+		w.WriteHeader(http.StatusConflict)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(restoredUser); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(restoredUser): %s", err)
+	}
+}
+
+// submitJob queues the names of the body for the enrichment in the background. The job is polled at
+// the URL of the Location header.
+func (h *Handler) submitJob(w http.ResponseWriter, r *http.Request) {
+	var req models.RequestJob
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestSize)).Decode(&req)
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	job, err := h.service.SubmitJob(r.Context(), req.Items)
+	if errors.Is(err, models.ErrJobNotValid) {
+		h.log.Infof("h.service.SubmitJob: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+
+	if err = json.NewEncoder(w).Encode(job); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(job): %s", err)
+	}
+}
+
+// uploadJob queues the rows of the CSV in the file field of the multipart form for the enrichment like
+// submitJob. The name_column, surname_column and patronymic_column fields map the columns of the file.
+func (h *Handler) uploadJob(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJobFileSize)
+
+	file, fileHeader, err := r.FormFile("file")
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if err != nil {
+		h.log.Infof("r.FormFile: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	defer func() {
+		if err := r.MultipartForm.RemoveAll(); err != nil {
+			h.log.Warningf("r.MultipartForm.RemoveAll(): %s", err)
+		}
+	}()
+
+	header, items, err := jobfile.Read(file, jobColumns(r))
+	if err != nil {
+		h.log.Infof("jobfile.Read: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	job, err := h.service.SubmitJobFile(r.Context(), fileHeader.Filename, header, items)
+	if errors.Is(err, models.ErrJobNotValid) {
+		h.log.Infof("h.service.SubmitJobFile: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+
+	if err = json.NewEncoder(w).Encode(job); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(job): %s", err)
+	}
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	jobID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.service.GetJob(r.Context(), jobID)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(job); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(job): %s", err)
+	}
+}
+
+// getJobResult sends the items of a completed job with their results as CSV, the uploaded file with the
+// age, gender, country and error columns filled in. A job that is not completed yet is answered with 409.
+func (h *Handler) getJobResult(w http.ResponseWriter, r *http.Request) {
+	jobID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.service.GetJob(r.Context(), jobID)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	if job.Status != models.JobCompleted {
+		w.WriteHeader(http.StatusConflict)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", jobResultDisposition(job))
+	w.WriteHeader(http.StatusOK)
+
+	if err = jobfile.Write(w, job); err != nil {
+		h.log.Warningf("jobfile.Write(w, job): %s", err)
+	}
+}
+
+// userIDByName resolves the {name} URL parameter of the legacy routes to a user ID. A name shared
+// by several users is answered with 409, as the caller has to switch to the ID routes.
+func (h *Handler) userIDByName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, err := h.service.FindUserID(r.Context(), chi.URLParam(r, "name"))
+	if errors.Is(err, storage.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return "", false
+	}
+
+	if errors.Is(err, models.ErrUserAmbiguous) {
+		w.WriteHeader(http.StatusConflict)
+
+		return "", false
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return "", false
+	}
+
+	return userID, true
+}
+
+// getRefreshRuns lists the latest refresh runs without the values they changed.
+func (h *Handler) getRefreshRuns(w http.ResponseWriter, r *http.Request) {
+	limit, err := refreshRunsLimit(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	runs, err := h.service.GetRefreshRuns(r.Context(), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(runs); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(runs): %s", err)
+	}
+}
+
+func (h *Handler) getRefreshRun(w http.ResponseWriter, r *http.Request) {
+	runID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	run, err := h.service.GetRefreshRun(r.Context(), runID)
+	if errors.Is(err, storage.ErrRefreshRunNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(run); err != nil {
+		h.log.Warningf("json.NewEncoder(w).Encode(run): %s", err)
+	}
+}
+
+// userIDParam parses the {id} URL parameter, the ID of a user, a job or a refresh run.
+func userIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return "", false
+	}
+
+	return userID.String(), true
+}
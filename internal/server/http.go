@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
@@ -40,12 +41,29 @@ func New(host string, port int, service EnricherService, log *logrus.Logger) *Se
 	r.Group(func(r chi.Router) {
 		r.Use(h.metric)
 		r.Route("/api/v1", func(r chi.Router) {
+			r.Use(middleware.RequestID)
 			r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log, NoColor: true}))
+			r.Use(h.auditMeta)
 			r.Use(h.commonMiddleware)
+			r.Post("/user/parse", h.parse)
 			r.Post("/user/enrich", h.enrich)
 			r.Get("/users", h.getList)
+			r.Get("/users/similar", h.getSimilar)
+			r.Get("/users/export", h.export)
+			r.Post("/users/import", h.importUsers)
+			r.Get("/users/{id}", h.getUser)
+			r.Get("/users/{id}/history", h.getHistory)
+			r.Patch("/users/{id}", h.updateByID)
+			r.Delete("/users/{id}", h.deleteByID)
+			r.Post("/users/{id}/restore", h.restore)
 			r.Patch("/user/update/{name}", h.update)
 			r.Delete("/user/delete/{name}", h.delete)
+			r.Post("/jobs", h.submitJob)
+			r.Post("/jobs/upload", h.uploadJob)
+			r.Get("/jobs/{id}", h.getJob)
+			r.Get("/jobs/{id}/result", h.getJobResult)
+			r.Get("/refresh/runs", h.getRefreshRuns)
+			r.Get("/refresh/runs/{id}", h.getRefreshRun)
 		})
 	})
 
@@ -72,6 +90,10 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}()
 
+	s.server.BaseContext = func(net.Listener) context.Context {
+		return ctx
+	}
+
 	s.log.Infof("Server is running at port %d", s.port)
 
 	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/AlexZav1327/name-enricher/internal/importfile"
+)
+
+// maxImportSize limits the file of an import request.
+const maxImportSize = 32 << 20
+
+var errImportParams = errors.New("import params are not valid")
+
+// parseImportParams reads the format of the file, CSV by default, and whether the missing values are
+// to be enriched.
+func parseImportParams(query url.Values) (string, bool, error) {
+	format := query.Get("format")
+	if format == "" {
+		format = importfile.FormatCSV
+	}
+
+	if format != importfile.FormatCSV && format != importfile.FormatNDJSON {
+		return "", false, fmt.Errorf("%w: %w", errImportParams, importfile.ErrFormat)
+	}
+
+	if !query.Has("enrich") {
+		return format, false, nil
+	}
+
+	enrich, err := strconv.ParseBool(query.Get("enrich"))
+	if err != nil {
+		return "", false, fmt.Errorf("%w: enrich must be a boolean", errImportParams)
+	}
+
+	return format, enrich, nil
+}
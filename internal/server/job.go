@@ -0,0 +1,38 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexZav1327/name-enricher/internal/jobfile"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+const (
+	// maxJobFileSize limits the request uploading a CSV for a job.
+	maxJobFileSize = 32 << 20
+	// maxJobRequestSize limits the JSON body of a submitted job.
+	maxJobRequestSize = 4 << 20
+)
+
+// jobColumns reads the columns of the uploaded CSV the parts of the name are taken from.
+func jobColumns(r *http.Request) jobfile.Columns {
+	return jobfile.Columns{
+		Name:       r.FormValue("name_column"),
+		Surname:    r.FormValue("surname_column"),
+		Patronymic: r.FormValue("patronymic_column"),
+	}
+}
+
+// jobResultDisposition offers the result of the job as the uploaded file name with an -enriched suffix.
+func jobResultDisposition(job models.Job) string {
+	fileName := "job-" + job.ID + ".csv"
+
+	if job.FileName != "" {
+		fileName = strings.TrimSuffix(job.FileName, filepath.Ext(job.FileName)) + "-enriched.csv"
+	}
+
+	return mime.FormatMediaType("attachment", map[string]string{"filename": fileName})
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+var (
+	errFilterUnknown  = errors.New("unknown filter")
+	errFilterNotValid = errors.New("filter is not valid")
+
+	countryCode = regexp.MustCompile(`^[A-Za-z]{2}$`)
+	// fieldName matches age, gender, country and the attribute names.
+	fieldName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// listingKeys are the query parameters of the users list besides the attr.<name> filters.
+var listingKeys = map[string]struct{}{
+	"textFilter":     {},
+	"q":              {},
+	"itemsPerPage":   {},
+	"offset":         {},
+	"sort":           {},
+	"sorting":        {},
+	"descending":     {},
+	"createdAfter":   {},
+	"createdBefore":  {},
+	"updatedAfter":   {},
+	"updatedBefore":  {},
+	"manualOverride": {},
+	"includeDeleted": {},
+	"ageMin":         {},
+	"ageMax":         {},
+	"gender":         {},
+	"country":        {},
+	"surnamePrefix":  {},
+	"cursor":         {},
+	"estimateTotal":  {},
+}
+
+// parseListingParams reads the users list query. Unknown keys and malformed typed filters are
+// reported as errors so that a mistyped filter is not silently ignored.
+func parseListingParams(query url.Values) (models.ListingQueryParams, error) {
+	var params models.ListingQueryParams
+
+	for key, values := range query {
+		if attrName, ok := strings.CutPrefix(key, attributeFilterPrefix); ok && attrName != "" {
+			if params.Attributes == nil {
+				params.Attributes = make(map[string]string)
+			}
+
+			params.Attributes[attrName] = values[0]
+
+			continue
+		}
+
+		if _, ok := listingKeys[key]; !ok {
+			return models.ListingQueryParams{}, fmt.Errorf("%w: %s", errFilterUnknown, key)
+		}
+	}
+
+	params.TextFilter = query.Get("textFilter")
+	params.Query = strings.TrimSpace(query.Get("q"))
+
+	params.ItemsPerPage = defaultLimit
+
+	if query.Has("itemsPerPage") {
+		itemsPerPage, err := strconv.Atoi(query.Get("itemsPerPage"))
+		if err != nil || itemsPerPage < 1 || itemsPerPage > maxItemsPerPage {
+			return models.ListingQueryParams{}, fmt.Errorf("%w: itemsPerPage must be an integer from 1 to %d",
+				errFilterNotValid, maxItemsPerPage)
+		}
+
+		params.ItemsPerPage = itemsPerPage
+	}
+
+	if query.Has("offset") {
+		offset, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || offset < 0 {
+			return models.ListingQueryParams{}, fmt.Errorf("%w: offset must be a non-negative integer", errFilterNotValid)
+		}
+
+		params.Offset = offset
+	}
+
+	params.Cursor = query.Get("cursor")
+
+	if params.Cursor != "" && query.Has("offset") {
+		return models.ListingQueryParams{}, fmt.Errorf("%w: cursor and offset are exclusive", errFilterNotValid)
+	}
+
+	sort, err := parseSort(query)
+	if err != nil {
+		return models.ListingQueryParams{}, err
+	}
+
+	params.Sort = sort
+
+	timeFilters := map[string]*time.Time{
+		"createdAfter":  &params.CreatedAfter,
+		"createdBefore": &params.CreatedBefore,
+		"updatedAfter":  &params.UpdatedAfter,
+		"updatedBefore": &params.UpdatedBefore,
+	}
+
+	for key, dest := range timeFilters {
+		if value := query.Get(key); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return models.ListingQueryParams{}, fmt.Errorf("%w: %s: %w", errFilterNotValid, key, err)
+			}
+
+			*dest = t
+		}
+	}
+
+	if query.Has("manualOverride") {
+		params.ManualOverride = query.Get("manualOverride")
+		if !fieldName.MatchString(params.ManualOverride) {
+			return models.ListingQueryParams{}, fmt.Errorf("%w: manualOverride must be a field name", errFilterNotValid)
+		}
+	}
+
+	boolFilters := map[string]*bool{
+		"includeDeleted": &params.IncludeDeleted,
+		"estimateTotal":  &params.EstimateTotal,
+	}
+
+	for key, dest := range boolFilters {
+		if value := query.Get(key); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return models.ListingQueryParams{}, fmt.Errorf("%w: %s must be true or false", errFilterNotValid, key)
+			}
+
+			*dest = b
+		}
+	}
+
+	ageFilters := map[string]**int{
+		"ageMin": &params.AgeMin,
+		"ageMax": &params.AgeMax,
+	}
+
+	for key, dest := range ageFilters {
+		if !query.Has(key) {
+			continue
+		}
+
+		age, err := strconv.Atoi(query.Get(key))
+		if err != nil || age < 0 {
+			return models.ListingQueryParams{}, fmt.Errorf("%w: %s must be a non-negative integer", errFilterNotValid, key)
+		}
+
+		*dest = &age
+	}
+
+	if params.AgeMin != nil && params.AgeMax != nil && *params.AgeMin > *params.AgeMax {
+		return models.ListingQueryParams{}, fmt.Errorf("%w: ageMin is greater than ageMax", errFilterNotValid)
+	}
+
+	if query.Has("gender") {
+		params.Gender = strings.TrimSpace(query.Get("gender"))
+		if params.Gender == "" {
+			return models.ListingQueryParams{}, fmt.Errorf("%w: gender is empty", errFilterNotValid)
+		}
+	}
+
+	if query.Has("country") {
+		for _, country := range strings.Split(query.Get("country"), ",") {
+			country = strings.TrimSpace(country)
+			if !countryCode.MatchString(country) {
+				return models.ListingQueryParams{}, fmt.Errorf("%w: country %q is not a two-letter code",
+					errFilterNotValid, country)
+			}
+
+			params.Countries = append(params.Countries, strings.ToUpper(country))
+		}
+	}
+
+	if query.Has("surnamePrefix") {
+		params.SurnamePrefix = strings.TrimSpace(query.Get("surnamePrefix"))
+		if params.SurnamePrefix == "" {
+			return models.ListingQueryParams{}, fmt.Errorf("%w: surnamePrefix is empty", errFilterNotValid)
+		}
+	}
+
+	return params, nil
+}
+
+// pageLinks makes the RFC 8288 Link header value pointing to the neighbouring pages. The links keep
+// the filters of the request and replace its offset with the cursor.
+func pageLinks(requestURL *url.URL, page models.UsersPage) string {
+	var links []string
+
+	for _, l := range []struct{ rel, cursor string }{{"next", page.NextCursor}, {"prev", page.PrevCursor}} {
+		if l.cursor == "" {
+			continue
+		}
+
+		query := requestURL.Query()
+		query.Del("offset")
+		query.Set("cursor", l.cursor)
+
+		link := url.URL{Path: requestURL.Path, RawQuery: query.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, link.String(), l.rel))
+	}
+
+	return strings.Join(links, ", ")
+}
+
+// parseSort reads the order like "country,-age,name", where a leading minus sorts the field in the
+// descending order. The legacy sorting and descending parameters are taken for a single field.
+func parseSort(query url.Values) ([]models.SortKey, error) {
+	if query.Has("sort") {
+		if query.Has("sorting") || query.Has("descending") {
+			return nil, fmt.Errorf("%w: sort excludes sorting and descending", errFilterNotValid)
+		}
+
+		fields := strings.Split(query.Get("sort"), ",")
+		sort := make([]models.SortKey, 0, len(fields))
+
+		for _, field := range fields {
+			field = strings.TrimSpace(field)
+			descending := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")
+
+			if field == "" {
+				return nil, fmt.Errorf("%w: sort has an empty field", errFilterNotValid)
+			}
+
+			sort = append(sort, models.SortKey{Field: field, Descending: descending})
+		}
+
+		return sort, nil
+	}
+
+	descending, _ := strconv.ParseBool(query.Get("descending"))
+
+	field := query.Get("sorting")
+	if field == "" {
+		if !descending {
+			return nil, nil
+		}
+
+		field = "name"
+	}
+
+	return []models.SortKey{{Field: field, Descending: descending}}, nil
+}
@@ -1,8 +1,9 @@
 package server
 
 import (
+	"errors"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type metrics struct {
@@ -12,20 +13,39 @@ type metrics struct {
 
 func newMetrics() *metrics {
 	return &metrics{
-		requests: promauto.NewCounterVec(
+		requests: register(prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Namespace: "name_enricher_service",
 				Subsystem: "",
 				Name:      "http_req_total",
 				Help:      "total quantity of http requests",
-			}, []string{"code", "method", "path"}),
-		duration: promauto.NewHistogramVec(
+			}, []string{"code", "method", "path"})),
+		duration: register(prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: "name_enricher_service",
 				Subsystem: "",
 				Name:      "http_req_duration",
 				Help:      "http requests duration",
 				Buckets:   []float64{0.0001, 0.0005, 0.001, 0.003, 0.005, 0.01, 0.05, 0.1, 1},
-			}, []string{"code", "method", "path"}),
+			}, []string{"code", "method", "path"})),
+	}
+}
+
+// register registers the collector or returns the one registered before under the same name, so several
+// instances in one process, e.g. in tests, share their metrics.
+func register[T prometheus.Collector](collector T) T {
+	err := prometheus.Register(collector)
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+
+	if err != nil {
+		panic(err)
 	}
+
+	return collector
 }
@@ -2,12 +2,19 @@ package server
 
 import (
 	"net/http"
+	"regexp"
 	"time"
 
+	"github.com/AlexZav1327/name-enricher/internal/audit"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+const actorHeader = "X-Actor"
+
+// actorName limits the X-Actor header, which is taken on trust, to a short user or service name.
+var actorName = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
 func (h *Handler) metric(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		started := time.Now()
@@ -16,14 +23,37 @@ func (h *Handler) metric(next http.Handler) http.Handler {
 		next.ServeHTTP(ww, r)
 		pattern := chi.RouteContext(r.Context()).RoutePattern()
 
-		h.metrics.requests.WithLabelValues(http.StatusText(ww.Status()), r.Method, pattern).Inc()
-		h.metrics.duration.WithLabelValues(http.StatusText(ww.Status()), r.Method,
+		h.metrics.requests.WithLabelValues(statusText(ww.Status()), r.Method, pattern).Inc()
+		h.metrics.duration.WithLabelValues(statusText(ww.Status()), r.Method,
 			pattern).Observe(time.Since(started).Seconds())
 	}
 
 	return http.HandlerFunc(fn)
 }
 
+// auditMeta puts the actor from the X-Actor header and the request ID into the request context
+// so that changes can be traced in the user history. The service has no authentication, so the
+// actor is advisory; an actor that is not a plain name is answered with 400.
+func (h *Handler) auditMeta(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get(actorHeader)
+		if actor != "" && !actorName.MatchString(actor) {
+			h.log.Infof("auditMeta: %s header is not valid", actorHeader)
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		ctx := audit.WithMeta(r.Context(), audit.Meta{
+			Actor:     actor,
+			RequestID: middleware.GetReqID(r.Context()),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
 func (*Handler) commonMiddleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
@@ -32,3 +62,11 @@ func (*Handler) commonMiddleware(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(fn)
 }
+
+func statusText(code int) string {
+	if code == statusClientClosedRequest {
+		return "Client Closed Request"
+	}
+
+	return http.StatusText(code)
+}
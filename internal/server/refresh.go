@@ -0,0 +1,27 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// maxRefreshRuns limits the refresh runs listed at once.
+const maxRefreshRuns = 100
+
+var errLimitNotValid = errors.New("limit is not valid")
+
+// refreshRunsLimit reads the number of refresh runs to list, defaultLimit when it is not given.
+func refreshRunsLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxRefreshRuns {
+		return 0, errLimitNotValid
+	}
+
+	return limit, nil
+}
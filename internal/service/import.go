@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/fullname"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// importEnrichWorkers limits the rows of an import enriched at once.
+	importEnrichWorkers = 4
+	maxImportedAge      = 150
+)
+
+var (
+	errNameMissing      = errors.New("name is missing")
+	errAgeNotValid      = fmt.Errorf("age must be between 0 and %d", maxImportedAge)
+	errGenderNotValid   = errors.New("gender must be male or female")
+	errCountryNotValid  = errors.New("country must be a two-letter code")
+	errDuplicateRow     = errors.New("same name, surname and patronymic as line")
+	errAttributeMissing = errors.New("attribute name is empty")
+	errEnrichFailed     = errors.New("missing values could not be enriched")
+	importedCountry     = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// ImportUsers validates the records and saves the valid ones at once. The values a row has are marked as
+// manually set and locked; the values missing from it keep the stored ones or, with enrich, are requested
+// from the providers for users that are not stored yet. Rows that are not valid or cannot be enriched are
+// rejected, and the report tells what became of every row.
+func (s *Service) ImportUsers(ctx context.Context, records []models.ImportRecord, enrich bool) (
+	models.ImportReport, error,
+) {
+	report := models.ImportReport{Rows: make([]models.ImportRowReport, len(records))}
+	users := make([]models.ResponseEnrich, 0, len(records))
+	// userRows holds the index of the row of every user.
+	userRows := make([]int, 0, len(records))
+	identities := make(map[string]int, len(records))
+	now := time.Now().UTC()
+
+	for i, record := range records {
+		report.Rows[i] = models.ImportRowReport{Line: record.Line, Status: models.ImportRejected, Error: record.Error}
+		if record.Error != "" {
+			continue
+		}
+
+		user, err := prepareImported(record.User, now)
+		if err != nil {
+			report.Rows[i].Error = err.Error()
+
+			continue
+		}
+
+		key := importIdentity(user.RequestEnrich)
+		if line, ok := identities[key]; ok {
+			report.Rows[i].Error = fmt.Sprintf("%s %d", errDuplicateRow, line)
+
+			continue
+		}
+
+		identities[key] = record.Line
+		users = append(users, user)
+		userRows = append(userRows, i)
+	}
+
+	if enrich {
+		enrichErrs, err := s.enrichImported(ctx, users)
+		if err != nil {
+			return models.ImportReport{}, err
+		}
+
+		enrichedUsers, enrichedRows := users[:0], userRows[:0]
+
+		for j, enrichErr := range enrichErrs {
+			if enrichErr != nil {
+				report.Rows[userRows[j]].Error = enrichErr.Error()
+
+				continue
+			}
+
+			enrichedUsers, enrichedRows = append(enrichedUsers, users[j]), append(enrichedRows, userRows[j])
+		}
+
+		users, userRows = enrichedUsers, enrichedRows
+	}
+
+	if len(users) > 0 {
+		started := time.Now()
+
+		imported, err := s.pg.ImportUsers(ctx, users)
+		if err != nil {
+			return models.ImportReport{}, fmt.Errorf("s.pg.ImportUsers(ctx, users): %w", err)
+		}
+
+		s.metrics.duration.WithLabelValues("import_users").Observe(time.Since(started).Seconds())
+
+		for j, importedUser := range imported {
+			row := &report.Rows[userRows[j]]
+			row.ID = importedUser.User.ID
+
+			row.Status = models.ImportUpdated
+			if importedUser.Operation == models.OperationCreate {
+				row.Status = models.ImportInserted
+			}
+		}
+	}
+
+	for _, row := range report.Rows {
+		switch row.Status {
+		case models.ImportInserted:
+			report.Inserted++
+		case models.ImportUpdated:
+			report.Updated++
+		default:
+			report.Rejected++
+		}
+	}
+
+	s.metrics.addedUsers.Add(float64(report.Inserted))
+
+	return report, nil
+}
+
+// prepareImported validates the imported user, gives it an ID and marks the values it has as manually set
+// and locked.
+func prepareImported(user models.ResponseEnrich, now time.Time) (models.ResponseEnrich, error) {
+	if user.FullName != "" {
+		parsed, err := fullname.Parse(user.FullName)
+		if err != nil {
+			return models.ResponseEnrich{}, models.ErrFullNameNotValid
+		}
+
+		user.RequestEnrich = mergeParsedName(user.RequestEnrich, parsed)
+		user.FullName = ""
+	}
+
+	user.Name = strings.TrimSpace(user.Name)
+	user.Surname = strings.TrimSpace(user.Surname)
+	user.Patronymic = strings.TrimSpace(user.Patronymic)
+	user.Gender = strings.ToLower(strings.TrimSpace(user.Gender))
+	user.Country = strings.ToUpper(strings.TrimSpace(user.Country))
+
+	switch {
+	case user.Name == "":
+		return models.ResponseEnrich{}, errNameMissing
+	case user.Age < 0 || user.Age > maxImportedAge:
+		return models.ResponseEnrich{}, errAgeNotValid
+	case user.Gender != "" && user.Gender != "male" && user.Gender != "female":
+		return models.ResponseEnrich{}, errGenderNotValid
+	case user.Country != "" && !importedCountry.MatchString(user.Country):
+		return models.ResponseEnrich{}, errCountryNotValid
+	}
+
+	imported := models.Provenance{Source: models.SourceImport, FetchedAt: now, Manual: true, Locked: true}
+	user.Provenance = make(map[string]models.Provenance, len(user.Attributes)+3)
+
+	if user.Age != 0 {
+		user.Provenance[models.FieldAge] = imported
+	}
+
+	if user.Gender != "" {
+		user.Provenance[models.FieldGender] = imported
+	}
+
+	if user.Country != "" {
+		user.Provenance[models.FieldCountry] = imported
+	}
+
+	for attrName, value := range user.Attributes {
+		if strings.TrimSpace(attrName) == "" {
+			return models.ResponseEnrich{}, errAttributeMissing
+		}
+
+		if value == nil {
+			delete(user.Attributes, attrName)
+
+			continue
+		}
+
+		user.Provenance[attrName] = imported
+	}
+
+	user.ID = uuid.NewString()
+
+	return user, nil
+}
+
+// enrichImported requests the providers for the values missing from the users that are not stored yet and
+// returns the error of every user that could not be enriched. It fails only when ctx is done.
+func (s *Service) enrichImported(ctx context.Context, users []models.ResponseEnrich) ([]error, error) {
+	errs := make([]error, len(users))
+
+	var eg errgroup.Group
+
+	eg.SetLimit(importEnrichWorkers)
+
+	for i := range users {
+		i := i
+
+		if !s.missesValues(users[i]) {
+			continue
+		}
+
+		eg.Go(func() error {
+			if _, err := s.pg.GetUserByIdentity(ctx, users[i].RequestEnrich); err == nil {
+				return nil
+			}
+
+			enrichCtx, cancel := s.enrichDeadline(ctx)
+			defer cancel()
+
+			enriched, err := s.resolve(enrichCtx, users[i].RequestEnrich)
+			if errors.Is(err, models.ErrNameNotValid) {
+				errs[i] = models.ErrNameNotValid
+
+				return nil
+			}
+
+			if err != nil {
+				s.log.Warningf("s.resolve(ctx, %q): %s", users[i].Name, err)
+				errs[i] = errEnrichFailed
+
+				return nil
+			}
+
+			fillMissing(&users[i], enriched)
+
+			return nil
+		})
+	}
+
+	_ = eg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("import interrupted: %w", err)
+	}
+
+	return errs, nil
+}
+
+func (s *Service) missesValues(user models.ResponseEnrich) bool {
+	if user.Age == 0 || user.Gender == "" || user.Country == "" {
+		return true
+	}
+
+	for attrName := range s.resolvers.Attributes {
+		if _, ok := user.Attributes[attrName]; !ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fillMissing takes the values missing from the imported user and their provenance from the enriched one.
+func fillMissing(user *models.ResponseEnrich, enriched models.ResponseEnrich) {
+	if user.Age == 0 {
+		user.Age = enriched.Age
+		user.Provenance[models.FieldAge] = enriched.Provenance[models.FieldAge]
+	}
+
+	if user.Gender == "" {
+		user.Gender = enriched.Gender
+		user.Provenance[models.FieldGender] = enriched.Provenance[models.FieldGender]
+	}
+
+	if user.Country == "" {
+		user.Country, user.Countries = enriched.Country, enriched.Countries
+		user.Provenance[models.FieldCountry] = enriched.Provenance[models.FieldCountry]
+	}
+
+	for attrName, value := range enriched.Attributes {
+		if _, ok := user.Attributes[attrName]; ok {
+			continue
+		}
+
+		if user.Attributes == nil {
+			user.Attributes = make(map[string]interface{}, len(enriched.Attributes))
+		}
+
+		user.Attributes[attrName] = value
+		user.Provenance[attrName] = enriched.Provenance[attrName]
+	}
+}
+
+// importIdentity is the identity of a user the way the store compares it.
+func importIdentity(userName models.RequestEnrich) string {
+	return strings.ToLower(userName.Name) + "\x1f" + strings.ToLower(userName.Surname) + "\x1f" +
+		strings.ToLower(userName.Patronymic)
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	maxJobItems = 10000
+	// maxJobAttempts is how many times an item is enriched before it fails.
+	maxJobAttempts = 3
+	// jobFinishTimeout limits saving the result of an item, which is done even when the workers stop.
+	jobFinishTimeout = 5 * time.Second
+)
+
+var errJobItemTimedOut = errors.New("enrichment timed out")
+
+// SubmitJob saves the names as a queued job enriched by the workers of RunJobs and returns the job
+// without its items.
+func (s *Service) SubmitJob(ctx context.Context, requests []models.RequestEnrich) (models.Job, error) {
+	items := make([]models.JobItem, len(requests))
+
+	for i, request := range requests {
+		if !hasJobName(request) {
+			return models.Job{}, fmt.Errorf("%w: item %d has no name", models.ErrJobNotValid, i)
+		}
+
+		items[i] = models.JobItem{Request: request}
+	}
+
+	return s.submitJob(ctx, models.Job{Items: items})
+}
+
+// SubmitJobFile saves the rows of an uploaded CSV as a job like SubmitJob. The job keeps the file name, the
+// header and the cells of the rows to write them back with the results. A row without a name fails at once.
+func (s *Service) SubmitJobFile(ctx context.Context, fileName string, header []string, items []models.JobItem) (
+	models.Job, error,
+) {
+	for i := range items {
+		if !hasJobName(items[i].Request) {
+			items[i].Status, items[i].Error = models.JobItemFailed, errNameMissing.Error()
+		}
+	}
+
+	return s.submitJob(ctx, models.Job{FileName: fileName, Header: header, Items: items})
+}
+
+func (s *Service) submitJob(ctx context.Context, job models.Job) (models.Job, error) {
+	switch {
+	case len(job.Items) == 0:
+		return models.Job{}, fmt.Errorf("%w: items are missing", models.ErrJobNotValid)
+	case len(job.Items) > maxJobItems:
+		return models.Job{}, fmt.Errorf("%w: more than %d items", models.ErrJobNotValid, maxJobItems)
+	}
+
+	meta := audit.FromContext(ctx)
+	job.ID, job.Actor, job.RequestID = uuid.NewString(), meta.Actor, meta.RequestID
+
+	for i := range job.Items {
+		job.Items[i].Position = i
+	}
+
+	started := time.Now()
+
+	job, err := s.pg.CreateJob(ctx, job)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("s.pg.CreateJob(ctx, job): %w", err)
+	}
+
+	s.metrics.duration.WithLabelValues("create_job").Observe(time.Since(started).Seconds())
+
+	select {
+	case s.jobWake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+func hasJobName(request models.RequestEnrich) bool {
+	return strings.TrimSpace(request.Name) != "" || strings.TrimSpace(request.FullName) != ""
+}
+
+// GetJob returns the progress of the job with the result of every item.
+func (s *Service) GetJob(ctx context.Context, jobID string) (models.Job, error) {
+	job, err := s.pg.GetJob(ctx, jobID)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("s.pg.GetJob(ctx, jobID): %w", err)
+	}
+
+	return job, nil
+}
+
+// RunJobs enriches the items of the submitted jobs with a pool of JobWorkers workers until the context
+// is done. The items are claimed in the store, so jobs left by a stopped service are continued on start
+// and several instances can share the work.
+func (s *Service) RunJobs(ctx context.Context) {
+	if s.cfg.JobWorkers <= 0 || s.cfg.JobPollInterval <= 0 {
+		s.log.Info("Enrichment jobs are disabled")
+
+		return
+	}
+
+	items := make(chan models.JobItem)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.cfg.JobWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for item := range items {
+				s.processJobItem(ctx, item)
+			}
+		}()
+	}
+
+	defer func() {
+		close(items)
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(s.cfg.JobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		claimed, err := s.pg.ClaimJobItems(ctx, s.cfg.JobWorkers, s.jobStaleBefore())
+		if err != nil && ctx.Err() == nil {
+			s.log.Warningf("s.pg.ClaimJobItems: %s", err)
+		}
+
+		for i, item := range claimed {
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				s.releaseJobItems(ctx, claimed[i:])
+
+				return
+			}
+		}
+
+		// A full batch means more items may be waiting.
+		if len(claimed) == s.cfg.JobWorkers && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.jobWake:
+		}
+	}
+}
+
+// jobStaleBefore is the claim time before which an item is taken over.
+func (s *Service) jobStaleBefore() time.Time {
+	if s.cfg.JobLease <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(-s.cfg.JobLease)
+}
+
+// jobRetryTime is when an item that failed its attempts so far is enriched again, see JobRetryBackoff.
+func (s *Service) jobRetryTime(attempts int) *time.Time {
+	if s.cfg.JobRetryBackoff <= 0 {
+		return nil
+	}
+
+	retryAt := time.Now().Add(s.cfg.JobRetryBackoff << (attempts - 1))
+
+	return &retryAt
+}
+
+func (s *Service) processJobItem(ctx context.Context, item models.JobItem) {
+	enrichCtx := audit.WithMeta(ctx, audit.Meta{Actor: item.Actor, RequestID: item.RequestID})
+
+	user, err := s.EnrichUser(enrichCtx, item.Request)
+
+	switch {
+	case err == nil:
+		item.Status, item.User, item.Error = models.JobItemDone, &user, ""
+	case ctx.Err() != nil:
+		// The workers are stopping, so the attempt does not count.
+		item.Status, item.Attempts = models.JobItemPending, item.Attempts-1
+	case errors.Is(err, models.ErrNameNotValid), errors.Is(err, models.ErrFullNameNotValid):
+		item.Status, item.Error = models.JobItemFailed, jobItemError(err)
+	default:
+		s.log.Warningf("s.EnrichUser(ctx, item %d of job %s): %s", item.Position, item.JobID, err)
+
+		item.Status, item.Error = models.JobItemPending, jobItemError(err)
+		if item.Attempts >= maxJobAttempts {
+			item.Status = models.JobItemFailed
+		} else {
+			item.NotBefore = s.jobRetryTime(item.Attempts)
+		}
+	}
+
+	s.finishJobItem(ctx, item)
+
+	if item.Status != models.JobItemPending {
+		s.metrics.jobItems.WithLabelValues(item.Status).Inc()
+	}
+}
+
+// releaseJobItems gives the claimed items no worker took back to the workers.
+func (s *Service) releaseJobItems(ctx context.Context, items []models.JobItem) {
+	for _, item := range items {
+		item.Status, item.Attempts = models.JobItemPending, item.Attempts-1
+		s.finishJobItem(ctx, item)
+	}
+}
+
+// finishJobItem saves the item even when ctx is done, so a stopping worker does not leave it running.
+func (s *Service) finishJobItem(ctx context.Context, item models.JobItem) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobFinishTimeout)
+	defer cancel()
+
+	if err := s.pg.FinishJobItem(ctx, item); err != nil {
+		s.log.Warningf("s.pg.FinishJobItem(ctx, item %d of job %s): %s", item.Position, item.JobID, err)
+	}
+}
+
+// jobItemError tells why an item could not be enriched without the details of the failure.
+func jobItemError(err error) string {
+	switch {
+	case errors.Is(err, models.ErrNameNotValid):
+		return models.ErrNameNotValid.Error()
+	case errors.Is(err, models.ErrFullNameNotValid):
+		return models.ErrFullNameNotValid.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return errJobItemTimedOut.Error()
+	case errors.Is(err, models.ErrQuotaExceeded):
+		return models.ErrQuotaExceeded.Error()
+	}
+
+	return errEnrichFailed.Error()
+}
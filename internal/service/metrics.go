@@ -1,39 +1,109 @@
 package service
 
 import (
+	"errors"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// cacheHitIdentity is a stored user with the same name, surname and patronymic.
+	cacheHitIdentity = "identity"
+	// cacheHitName is a stored user with the same first name whose provider results were reused.
+	cacheHitName = "name"
+	// cacheHitPhonetic is a stored user with a similarly sounding name whose provider results were
+	// reused instead of low-count predictions.
+	cacheHitPhonetic = "phonetic"
 )
 
 type metrics struct {
-	duration     *prometheus.HistogramVec
-	addedUsers   prometheus.Counter
-	deletedUsers prometheus.Counter
+	duration               *prometheus.HistogramVec
+	addedUsers             prometheus.Counter
+	deletedUsers           prometheus.Counter
+	purgedUsers            prometheus.Counter
+	interruptedEnrichments *prometheus.CounterVec
+	cacheHits              *prometheus.CounterVec
+	jobItems               *prometheus.CounterVec
+	refreshedUsers         *prometheus.CounterVec
 }
 
 func newMetrics() *metrics {
 	return &metrics{
-		duration: promauto.NewHistogramVec(
+		duration: register(prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: "name_enricher_service",
 				Subsystem: "",
 				Name:      "db_resp_duration",
 				Help:      "database response duration",
 				Buckets:   []float64{0.0001, 0.0005, 0.001, 0.003, 0.005, 0.01, 0.05, 0.1, 1},
-			}, []string{"operation_type"}),
-		addedUsers: promauto.NewCounter(
+			}, []string{"operation_type"})),
+		addedUsers: register(prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: "name_enricher_service",
 				Subsystem: "",
 				Name:      "users_added_total",
 				Help:      "total quantity of users that were added",
-			}),
-		deletedUsers: promauto.NewCounter(
+			})),
+		deletedUsers: register(prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Namespace: "name_enricher_service",
 				Subsystem: "",
 				Name:      "users_deleted_total",
 				Help:      "total quantity of users that were deleted",
-			}),
+			})),
+		purgedUsers: register(prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "name_enricher_service",
+				Subsystem: "",
+				Name:      "users_purged_total",
+				Help:      "total quantity of deleted users that were removed permanently",
+			})),
+		interruptedEnrichments: register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "name_enricher_service",
+				Subsystem: "",
+				Name:      "enrichments_interrupted_total",
+				Help:      "total quantity of enrichments interrupted by cancellation or deadline",
+			}, []string{"reason"})),
+		cacheHits: register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "name_enricher_service",
+				Subsystem: "",
+				Name:      "enrich_cache_hits_total",
+				Help:      "total quantity of enrichments served from stored users",
+			}, []string{"kind"})),
+		jobItems: register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "name_enricher_service",
+				Subsystem: "",
+				Name:      "job_items_finished_total",
+				Help:      "total quantity of job items that were enriched or failed",
+			}, []string{"status"})),
+		refreshedUsers: register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "name_enricher_service",
+				Subsystem: "",
+				Name:      "users_refreshed_total",
+				Help:      "total quantity of stale users requested from the providers again",
+			}, []string{"result"})),
+	}
+}
+
+// register registers the collector or returns the one registered before under the same name, so several
+// instances in one process, e.g. in tests, share their metrics.
+func register[T prometheus.Collector](collector T) T {
+	err := prometheus.Register(collector)
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing
+		}
 	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	return collector
 }
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+// combineCountries merges first name and surname nationality distributions into one list
+// ranked by the weighted probability. Every entry keeps the share each part contributed.
+func combineCountries(nameCountries, surnameCountries []models.CountryEnriched,
+	surnameWeight float64,
+) []models.CountryScore {
+	if len(surnameCountries) == 0 {
+		surnameWeight = 0
+	}
+
+	nameWeight := 1 - surnameWeight
+	scores := make(map[string]*models.CountryScore)
+
+	scoreFor := func(countryID string) *models.CountryScore {
+		score, ok := scores[countryID]
+		if !ok {
+			score = &models.CountryScore{CountryID: countryID}
+			scores[countryID] = score
+		}
+
+		return score
+	}
+
+	for _, c := range nameCountries {
+		scoreFor(c.CountryID).NameContribution = nameWeight * float64(c.Probability)
+	}
+
+	for _, c := range surnameCountries {
+		scoreFor(c.CountryID).SurnameContribution = surnameWeight * float64(c.Probability)
+	}
+
+	combined := make([]models.CountryScore, 0, len(scores))
+
+	for _, score := range scores {
+		score.Probability = score.NameContribution + score.SurnameContribution
+		combined = append(combined, *score)
+	}
+
+	sort.Slice(combined, func(i, j int) bool {
+		if combined[i].Probability == combined[j].Probability {
+			return combined[i].CountryID < combined[j].CountryID
+		}
+
+		return combined[i].Probability > combined[j].Probability
+	})
+
+	return combined
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+)
+
+// purgeActor is recorded in the history of the users removed by the purge.
+const purgeActor = "purge"
+
+// RunPurge permanently removes the users deleted longer than the retention period ago, once per
+// purge interval, until the context is done.
+func (s *Service) RunPurge(ctx context.Context) {
+	if s.cfg.PurgeRetention <= 0 || s.cfg.PurgeInterval <= 0 {
+		s.log.Info("Purge of deleted users is disabled")
+
+		return
+	}
+
+	ticker := time.NewTicker(s.cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.PurgeDeletedUsers(ctx); err != nil {
+			s.log.Warningf("s.PurgeDeletedUsers(ctx): %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) PurgeDeletedUsers(ctx context.Context) error {
+	ctx = audit.WithMeta(ctx, audit.Meta{Actor: purgeActor})
+
+	started := time.Now()
+	defer func() {
+		s.metrics.duration.WithLabelValues("purge_users").Observe(time.Since(started).Seconds())
+	}()
+
+	purged, err := s.pg.PurgeDeletedUsers(ctx, time.Now().Add(-s.cfg.PurgeRetention))
+	if err != nil {
+		return fmt.Errorf("s.pg.PurgeDeletedUsers: %w", err)
+	}
+
+	if purged > 0 {
+		s.log.Infof("%d deleted users were purged", purged)
+		s.metrics.purgedUsers.Add(float64(purged))
+	}
+
+	return nil
+}
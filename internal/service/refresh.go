@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// refreshActor is recorded in the history of the users changed by a refresh run.
+	refreshActor = "refresh"
+	// refreshSaveTimeout limits saving a run, which is done even when the service stops.
+	refreshSaveTimeout = 5 * time.Second
+
+	refreshStoppedQuota    = "quota_exceeded"
+	refreshStoppedCanceled = "canceled"
+
+	refreshResultUpdated   = "updated"
+	refreshResultUnchanged = "unchanged"
+	refreshResultFailed    = "failed"
+)
+
+// RunRefresh requests the providers again for the users whose values are older than RefreshMaxAge, once
+// per refresh interval, until the context is done.
+func (s *Service) RunRefresh(ctx context.Context) {
+	if s.cfg.RefreshMaxAge <= 0 || s.cfg.RefreshInterval <= 0 || s.cfg.RefreshBatchSize <= 0 {
+		s.log.Info("Refresh of stale users is disabled")
+
+		return
+	}
+
+	ticker := time.NewTicker(s.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RefreshStaleUsers(ctx); err != nil && ctx.Err() == nil {
+			s.log.Warningf("s.RefreshStaleUsers(ctx): %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshStaleUsers re-enriches up to RefreshBatchSize of the stalest users one by one and records the run
+// with the values it changed. Locked values are kept, and a user the providers fail for keeps its values until
+// the next refresh is due. The run stops early when a provider quota is exceeded, leaving the rest of the users
+// to the next run.
+func (s *Service) RefreshStaleUsers(ctx context.Context) error {
+	ctx = audit.WithMeta(ctx, audit.Meta{Actor: refreshActor})
+
+	run := models.RefreshRun{ID: uuid.NewString(), StartedAt: time.Now(), Changes: make([]models.RefreshChange, 0)}
+
+	users, err := s.pg.GetStaleUsers(ctx, run.StartedAt.Add(-s.cfg.RefreshMaxAge), s.cfg.RefreshBatchSize)
+	if err != nil {
+		return fmt.Errorf("s.pg.GetStaleUsers: %w", err)
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+
+	for i, user := range users {
+		if i > 0 && !s.pauseRefresh(ctx) {
+			run.Stopped = refreshStoppedCanceled
+
+			break
+		}
+
+		refreshed, err := s.refreshValues(ctx, user.RequestEnrich)
+
+		switch {
+		case errors.Is(err, models.ErrQuotaExceeded):
+			run.Stopped = refreshStoppedQuota
+		case ctx.Err() != nil:
+			run.Stopped = refreshStoppedCanceled
+		case errors.Is(err, models.ErrNameNotValid):
+			// The providers know nothing of the name, so only the time of the refresh is saved.
+			refreshed, err = models.ResponseEnrich{}, nil
+		}
+
+		if run.Stopped != "" {
+			break
+		}
+
+		run.Checked++
+
+		if err != nil {
+			s.refreshFailed(&run, user.ID, err)
+			s.markRefreshed(ctx, user.ID)
+
+			continue
+		}
+
+		refreshed.ID = user.ID
+
+		if err = s.refreshUser(ctx, &run, refreshed); err != nil {
+			s.refreshFailed(&run, user.ID, err)
+		}
+	}
+
+	run.FinishedAt = time.Now()
+
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshSaveTimeout)
+	defer cancel()
+
+	if err = s.pg.SaveRefreshRun(saveCtx, run); err != nil {
+		return fmt.Errorf("s.pg.SaveRefreshRun: %w", err)
+	}
+
+	s.log.Infof("Refresh run %s checked %d users, updated %d, failed %d", run.ID, run.Checked, run.Updated,
+		run.Failed)
+
+	return nil
+}
+
+// refreshValues requests the providers for the user within the enrichment budget.
+func (s *Service) refreshValues(ctx context.Context, userName models.RequestEnrich) (models.ResponseEnrich, error) {
+	ctx, cancel := s.enrichDeadline(ctx)
+	defer cancel()
+
+	return s.resolve(ctx, userName)
+}
+
+// refreshUser saves the values resolved for the user and adds the changes to the run.
+func (s *Service) refreshUser(ctx context.Context, run *models.RefreshRun, refreshed models.ResponseEnrich) error {
+	changes, err := s.pg.RefreshUser(ctx, refreshed)
+	if err != nil {
+		return fmt.Errorf("s.pg.RefreshUser: %w", err)
+	}
+
+	if len(changes) == 0 {
+		s.metrics.refreshedUsers.WithLabelValues(refreshResultUnchanged).Inc()
+
+		return nil
+	}
+
+	s.metrics.refreshedUsers.WithLabelValues(refreshResultUpdated).Inc()
+
+	run.Updated++
+	run.Changes = append(run.Changes, changes...)
+
+	return nil
+}
+
+func (s *Service) refreshFailed(run *models.RefreshRun, userID string, err error) {
+	s.log.Warningf("refresh of user %s: %s", userID, err)
+	s.metrics.refreshedUsers.WithLabelValues(refreshResultFailed).Inc()
+
+	run.Failed++
+}
+
+// markRefreshed saves only the time of the refresh for a user the providers failed for, so that the next runs
+// go on to the other stale users instead of requesting the failing one again and again. The user is
+// refreshed again once its values are RefreshMaxAge old.
+func (s *Service) markRefreshed(ctx context.Context, userID string) {
+	if _, err := s.pg.RefreshUser(ctx, models.ResponseEnrich{ID: userID}); err != nil {
+		s.log.Warningf("s.pg.RefreshUser(ctx, user %s): %s", userID, err)
+	}
+}
+
+// pauseRefresh waits RefreshPause and reports whether the run may go on.
+func (s *Service) pauseRefresh(ctx context.Context) bool {
+	if s.cfg.RefreshPause <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(s.cfg.RefreshPause)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// GetRefreshRuns returns up to limit refresh runs without their changes, the latest first.
+func (s *Service) GetRefreshRuns(ctx context.Context, limit int) ([]models.RefreshRun, error) {
+	runs, err := s.pg.GetRefreshRuns(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("s.pg.GetRefreshRuns(ctx, limit): %w", err)
+	}
+
+	return runs, nil
+}
+
+// GetRefreshRun returns the refresh run with the values it changed.
+func (s *Service) GetRefreshRun(ctx context.Context, runID string) (models.RefreshRun, error) {
+	run, err := s.pg.GetRefreshRun(ctx, runID)
+	if err != nil {
+		return models.RefreshRun{}, fmt.Errorf("s.pg.GetRefreshRun(ctx, runID): %w", err)
+	}
+
+	return run, nil
+}
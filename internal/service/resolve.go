@@ -0,0 +1,276 @@
+//nolint:wrapcheck
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	reasonCanceled         = "canceled"
+	reasonDeadlineExceeded = "deadline_exceeded"
+
+	// providerBudgetShare is the share of the remaining enrichment budget the provider requests may take;
+	// the rest is left for saving the user.
+	providerBudgetShare = 0.8
+)
+
+// resolve requests every provider for the user name concurrently within the enrichment budget, the deadline
+// of ctx set by the caller with enrichDeadline.
+// When the caller goes away or the budget runs out, the context error is returned instead of
+// the error of the provider that happened to notice it first. With PhoneticReuse the results of
+// a stored user whose name sounds the same are taken when the providers base a prediction on few
+// samples; that user is looked up first, so that a low age count spares the other providers.
+func (s *Service) resolve(ctx context.Context, userName models.RequestEnrich) (models.ResponseEnrich, error) {
+	userNameEnriched := models.ResponseEnrich{
+		RequestEnrich: userName,
+	}
+
+	providersCtx, cancelProviders := providerDeadline(ctx)
+	defer cancelProviders()
+
+	ageCount, genderCount, countryCount := -1, -1, -1
+
+	namesake, hasNamesake := models.ResponseEnrich{}, false
+	if s.cfg.PhoneticReuse {
+		namesake, hasNamesake = s.phoneticNamesake(ctx, userName.Name)
+	}
+
+	// With a namesake at hand the age is requested first: when its count is low the other providers are spared.
+	if hasNamesake {
+		err := s.resolveOne(providersCtx, s.resolvers.Age, userName.Name, &userNameEnriched.Age, &ageCount)
+		if err != nil {
+			if ctxErr := providersCtx.Err(); ctxErr != nil {
+				return models.ResponseEnrich{}, s.interrupted(ctxErr)
+			}
+
+			return models.ResponseEnrich{}, fmt.Errorf("s.resolveOne(age): %w", err)
+		}
+
+		if isLowCount(s.cfg.PhoneticMinCount, ageCount) {
+			s.metrics.cacheHits.WithLabelValues(cacheHitPhonetic).Inc()
+
+			return reuse(namesake, userName), nil
+		}
+	}
+
+	eg, egCtx := errgroup.WithContext(providersCtx)
+
+	if !hasNamesake {
+		eg.Go(func() error {
+			return s.resolveOne(egCtx, s.resolvers.Age, userName.Name, &userNameEnriched.Age, &ageCount)
+		})
+	}
+
+	eg.Go(func() error {
+		return s.resolveOne(egCtx, s.resolvers.Gender, userName.Name, &userNameEnriched.Gender, &genderCount)
+	})
+
+	var nameCountries, surnameCountries []models.CountryEnriched
+
+	eg.Go(func() error {
+		return s.resolveOne(egCtx, s.resolvers.Country, userName.Name, &nameCountries, &countryCount)
+	})
+
+	if s.cfg.SurnameNationality && userName.Surname != "" {
+		eg.Go(func() error {
+			err := s.resolveOne(egCtx, s.resolvers.Country, userName.Surname, &surnameCountries, nil)
+			if err != nil && !errors.Is(err, models.ErrNameNotValid) {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	attributeNames := make([]string, 0, len(s.resolvers.Attributes))
+	for attrName := range s.resolvers.Attributes {
+		attributeNames = append(attributeNames, attrName)
+	}
+
+	attributes := make([]interface{}, len(attributeNames))
+
+	for i, attrName := range attributeNames {
+		i, resolver := i, s.resolvers.Attributes[attrName]
+
+		eg.Go(func() error {
+			err := s.resolveOne(egCtx, resolver, userName.Name, &attributes[i], nil)
+			if err != nil && !errors.Is(err, models.ErrNameNotValid) {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		if ctxErr := providersCtx.Err(); ctxErr != nil {
+			return models.ResponseEnrich{}, s.interrupted(ctxErr)
+		}
+
+		return models.ResponseEnrich{}, fmt.Errorf("eg.Wait(): %w", err)
+	}
+
+	fetchedAt := time.Now().UTC()
+
+	userNameEnriched.Provenance = map[string]models.Provenance{
+		models.FieldAge:     {Source: s.resolvers.Age.Source(), FetchedAt: fetchedAt},
+		models.FieldGender:  {Source: s.resolvers.Gender.Source(), FetchedAt: fetchedAt},
+		models.FieldCountry: {Source: s.resolvers.Country.Source(), FetchedAt: fetchedAt},
+	}
+	userNameEnriched.Attributes = make(map[string]interface{}, len(attributeNames))
+
+	for i, attrName := range attributeNames {
+		if attributes[i] != nil {
+			userNameEnriched.Attributes[attrName] = attributes[i]
+			userNameEnriched.Provenance[attrName] = models.Provenance{
+				Source:    s.resolvers.Attributes[attrName].Source(),
+				FetchedAt: fetchedAt,
+			}
+		}
+	}
+
+	userNameEnriched.Country = nameCountries[0].CountryID
+
+	if s.cfg.SurnameNationality {
+		userNameEnriched.Countries = combineCountries(nameCountries, surnameCountries, s.cfg.SurnameWeight)
+		userNameEnriched.Country = userNameEnriched.Countries[0].CountryID
+	}
+
+	if hasNamesake && isLowCount(s.cfg.PhoneticMinCount, genderCount, countryCount) {
+		s.metrics.cacheHits.WithLabelValues(cacheHitPhonetic).Inc()
+
+		return reuse(namesake, userName), nil
+	}
+
+	return userNameEnriched, nil
+}
+
+func isLowCount(minCount int, counts ...int) bool {
+	for _, count := range counts {
+		if count >= 0 && count < minCount {
+			return true
+		}
+	}
+
+	return false
+}
+
+// phoneticNamesake picks a stored user whose name sounds like the name and whose provider results
+// are valid for the user, see reusableNamesake.
+func (s *Service) phoneticNamesake(ctx context.Context, userName string) (models.ResponseEnrich, bool) {
+	similar, err := s.pg.FindSimilarUsers(ctx, userName)
+	if err != nil {
+		s.log.Warningf("s.pg.FindSimilarUsers(ctx, userName): %s", err)
+
+		return models.ResponseEnrich{}, false
+	}
+
+	return s.reusableNamesake(similar)
+}
+
+// reusableNamesake picks a stored user with the same first name whose provider results are valid
+// for the user. Every provider is requested by the first name only, unless the country also
+// depends on the surname, and manually set values belong to the person rather than the name.
+func (s *Service) reusableNamesake(namesakes []models.ResponseEnrich) (models.ResponseEnrich, bool) {
+	if s.cfg.SurnameNationality {
+		return models.ResponseEnrich{}, false
+	}
+
+	for _, namesake := range namesakes {
+		if !hasManualValues(namesake) {
+			return namesake, true
+		}
+	}
+
+	return models.ResponseEnrich{}, false
+}
+
+// hasManualValues reports whether a value of the user was set manually or locked, which makes it belong to the
+// person rather than the name.
+func hasManualValues(user models.ResponseEnrich) bool {
+	for _, p := range user.Provenance {
+		if p.Manual || p.Locked {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reuse copies the provider results of a stored user with the same first name. Only the source and the fetch
+// time of a value are copied: the new user has set or locked nothing.
+func reuse(namesake models.ResponseEnrich, userName models.RequestEnrich) models.ResponseEnrich {
+	attributes := make(map[string]interface{}, len(namesake.Attributes))
+	for attrName, value := range namesake.Attributes {
+		attributes[attrName] = value
+	}
+
+	provenance := make(map[string]models.Provenance, len(namesake.Provenance))
+	for field, p := range namesake.Provenance {
+		provenance[field] = models.Provenance{Source: p.Source, FetchedAt: p.FetchedAt}
+	}
+
+	return models.ResponseEnrich{
+		RequestEnrich: userName,
+		Age:           namesake.Age,
+		Gender:        namesake.Gender,
+		Country:       namesake.Country,
+		Countries:     append([]models.CountryScore(nil), namesake.Countries...),
+		Attributes:    attributes,
+		Provenance:    provenance,
+	}
+}
+
+// enrichDeadline bounds the enrichment with EnrichTimeout unless it is zero or the caller's deadline is earlier.
+func (s *Service) enrichDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.cfg.EnrichTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, s.cfg.EnrichTimeout)
+}
+
+// providerDeadline gives the provider requests their share of the budget remaining in ctx, see
+// providerBudgetShare.
+func providerDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Duration(float64(time.Until(deadline))*providerBudgetShare))
+}
+
+// resolveOne requests the resolver. The sample count of the prediction is stored in count when it is
+// not nil and the resolver reports it.
+func (*Service) resolveOne(ctx context.Context, resolver Resolver, name string, dest interface{},
+	count *int,
+) error {
+	counted, ok := resolver.(countedResolver)
+	if !ok || count == nil {
+		return resolver.Resolve(ctx, name, dest)
+	}
+
+	n, err := counted.ResolveCounted(ctx, name, dest)
+	*count = n
+
+	return err
+}
+
+func (s *Service) interrupted(ctxErr error) error {
+	reason := reasonDeadlineExceeded
+	if errors.Is(ctxErr, context.Canceled) {
+		reason = reasonCanceled
+	}
+
+	s.metrics.interruptedEnrichments.WithLabelValues(reason).Inc()
+	s.log.Infof("enrichment interrupted: %s", reason)
+
+	return fmt.Errorf("enrichment interrupted: %w", ctxErr)
+}
@@ -3,146 +3,292 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/AlexZav1327/name-enricher/internal/fullname"
 	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/errgroup"
 )
 
 type Service struct {
-	pg              store
-	ageResolver     AgeResolver
-	genderResolver  GenderResolver
-	countryResolver CountryResolver
-	log             *logrus.Entry
-	metrics         *metrics
+	pg        Store
+	resolvers Resolvers
+	cfg       Config
+	log       *logrus.Entry
+	metrics   *metrics
+	// jobWake tells the job workers a job was submitted.
+	jobWake chan struct{}
 }
 
-func New(pg store, age AgeResolver, gender GenderResolver, country CountryResolver, log *logrus.Logger) *Service {
+type Resolvers struct {
+	Age     Resolver
+	Gender  Resolver
+	Country Resolver
+	// Attributes are custom enrichment dimensions; their values are stored under the registry name.
+	Attributes map[string]Resolver
+}
+
+type Config struct {
+	// SurnameNationality enables nationality prediction by surname in addition to the first name.
+	SurnameNationality bool
+	// SurnameWeight is the share of the surname distribution in the combined country list, from 0 to 1.
+	SurnameWeight float64
+	// EnrichTimeout is the overall deadline of an enrichment: the store lookups, the provider requests
+	// and saving the user; zero means no deadline.
+	EnrichTimeout time.Duration
+	// PhoneticReuse enables reusing the results of a stored user whose name sounds the same when
+	// the providers base a prediction on fewer than PhoneticMinCount samples.
+	PhoneticReuse    bool
+	PhoneticMinCount int
+	// PurgeRetention is how long deleted users can be restored before they are removed permanently;
+	// zero disables the purge.
+	PurgeRetention time.Duration
+	// PurgeInterval is how often deleted users are checked for the purge.
+	PurgeInterval time.Duration
+	// JobWorkers is the number of job items enriched at once; zero disables the jobs.
+	JobWorkers int
+	// JobPollInterval is how often the jobs are checked for items to enrich.
+	JobPollInterval time.Duration
+	// JobLease is how long an item may stay claimed before it is taken over from a worker that went
+	// away, e.g. with a stopped instance; zero means never.
+	JobLease time.Duration
+	// JobRetryBackoff is how long a failed item waits before it is enriched again, doubled with every
+	// attempt; zero retries it at once.
+	JobRetryBackoff time.Duration
+	// RefreshMaxAge is how old the provider values of a user may get before they are requested again;
+	// zero disables the refresh.
+	RefreshMaxAge time.Duration
+	// RefreshInterval is how often a refresh run checks the users.
+	RefreshInterval time.Duration
+	// RefreshBatchSize is the most users a run refreshes.
+	RefreshBatchSize int
+	// RefreshPause is waited between the users of a run to spread the provider requests.
+	RefreshPause time.Duration
+}
+
+var ErrConfigNotValid = errors.New("service config is not valid")
+
+// Validate rejects settings the service cannot work with.
+func (c Config) Validate() error {
+	if c.SurnameWeight < 0 || c.SurnameWeight > 1 {
+		return fmt.Errorf("%w: surname weight %g is not from 0 to 1", ErrConfigNotValid, c.SurnameWeight)
+	}
+
+	return nil
+}
+
+func New(pg Store, resolvers Resolvers, cfg Config, log *logrus.Logger) *Service {
 	return &Service{
-		pg:              pg,
-		ageResolver:     age,
-		genderResolver:  gender,
-		countryResolver: country,
-		log:             log.WithField("module", "service"),
-		metrics:         newMetrics(),
+		pg:        pg,
+		resolvers: resolvers,
+		cfg:       cfg,
+		log:       log.WithField("module", "service"),
+		metrics:   newMetrics(),
+		jobWake:   make(chan struct{}, 1),
 	}
 }
 
-type store interface {
-	GetUser(ctx context.Context, userName string) (models.ResponseEnrich, error)
-	GetUsersList(ctx context.Context, params models.ListingQueryParams) ([]models.ResponseEnrich, error)
-	SaveUser(ctx context.Context, user models.ResponseEnrich) error
+// Store keeps the enriched users. storage.Postgres, storage.SQLite and storage.Memory implement it.
+type Store interface {
+	GetUser(ctx context.Context, userID string) (models.ResponseEnrich, error)
+	GetUserByIdentity(ctx context.Context, userName models.RequestEnrich) (models.ResponseEnrich, error)
+	FindUsersByName(ctx context.Context, userName string) ([]models.ResponseEnrich, error)
+	FindSimilarUsers(ctx context.Context, userName string) ([]models.ResponseEnrich, error)
+	GetUsersList(ctx context.Context, params models.ListingQueryParams) (models.UsersPage, error)
+	ExportUsers(ctx context.Context, params models.ListingQueryParams, fn func(user models.ResponseEnrich) error) error
+	SaveUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error)
+	ImportUsers(ctx context.Context, users []models.ResponseEnrich) ([]models.ImportedUser, error)
 	UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error)
-	DeleteUser(ctx context.Context, userName string) error
+	DeleteUser(ctx context.Context, userID string) error
+	RestoreUser(ctx context.Context, userID string) (models.ResponseEnrich, error)
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
+	GetUserHistory(ctx context.Context, userRef string) ([]models.HistoryRecord, error)
+	CreateJob(ctx context.Context, job models.Job) (models.Job, error)
+	GetJob(ctx context.Context, jobID string) (models.Job, error)
+	ClaimJobItems(ctx context.Context, limit int, staleBefore time.Time) ([]models.JobItem, error)
+	FinishJobItem(ctx context.Context, item models.JobItem) error
+	GetStaleUsers(ctx context.Context, refreshedBefore time.Time, limit int) ([]models.ResponseEnrich, error)
+	RefreshUser(ctx context.Context, refreshed models.ResponseEnrich) ([]models.RefreshChange, error)
+	SaveRefreshRun(ctx context.Context, run models.RefreshRun) error
+	GetRefreshRuns(ctx context.Context, limit int) ([]models.RefreshRun, error)
+	GetRefreshRun(ctx context.Context, runID string) (models.RefreshRun, error)
 }
 
-type AgeResolver interface {
-	GetAge(ctx context.Context, name string) (int, error)
+// Resolver requests an enrichment provider for the name and decodes the predicted value into dest.
+type Resolver interface {
+	Resolve(ctx context.Context, name string, dest interface{}) error
+	// Source identifies the provider in the provenance of the values it resolved.
+	Source() string
 }
 
-type GenderResolver interface {
-	GetGender(ctx context.Context, name string) (string, error)
+// countedResolver is implemented by resolvers that report how many samples a prediction is based
+// on; a negative count means unknown.
+type countedResolver interface {
+	ResolveCounted(ctx context.Context, name string, dest interface{}) (int, error)
 }
 
-type CountryResolver interface {
-	GetCountry(ctx context.Context, name string) (string, error)
+func (*Service) ParseFullName(_ context.Context, fullName string) (models.ParsedName, error) {
+	parsed, err := fullname.Parse(fullName)
+	if err != nil {
+		return models.ParsedName{}, fmt.Errorf("fullname.Parse(fullName): %w", err)
+	}
+
+	return parsed, nil
 }
 
 func (s *Service) EnrichUser(ctx context.Context, userName models.RequestEnrich) (models.ResponseEnrich, error) {
-	userNameEnriched, err := s.pg.GetUser(ctx, userName.Name)
-	if err == nil && userNameEnriched.Name == userName.Name && userNameEnriched.Surname == userName.Surname &&
-		userNameEnriched.Patronymic == userName.Patronymic {
-		return userNameEnriched, nil
-	}
-
-	userNameEnriched = models.ResponseEnrich{
-		RequestEnrich: userName,
-	}
+	ctx, cancel := s.enrichDeadline(ctx)
+	defer cancel()
 
-	eg, egCtx := errgroup.WithContext(context.Background())
+	var parseConfidence float64
 
-	eg.Go(func() error {
-		age, err := s.ageResolver.GetAge(egCtx, userName.Name)
+	if userName.FullName != "" {
+		parsed, err := fullname.Parse(userName.FullName)
 		if err != nil {
-			return err
+			return models.ResponseEnrich{}, fmt.Errorf("fullname.Parse(userName.FullName): %w", err)
 		}
-		userNameEnriched.Age = age
 
-		return nil
-	})
+		userName = mergeParsedName(userName, parsed)
+		parseConfidence = parsed.Confidence
+	}
 
-	eg.Go(func() error {
-		gender, err := s.genderResolver.GetGender(egCtx, userName.Name)
-		if err != nil {
-			return err
-		}
-		userNameEnriched.Gender = gender
+	userName.Name = strings.TrimSpace(userName.Name)
+	userName.Surname = strings.TrimSpace(userName.Surname)
+	userName.Patronymic = strings.TrimSpace(userName.Patronymic)
 
-		return nil
-	})
+	userNameEnriched, err := s.pg.GetUserByIdentity(ctx, userName)
+	if err == nil {
+		s.metrics.cacheHits.WithLabelValues(cacheHitIdentity).Inc()
 
-	eg.Go(func() error {
-		country, err := s.countryResolver.GetCountry(egCtx, userName.Name)
-		if err != nil {
-			return err
-		}
-		userNameEnriched.Country = country
+		userNameEnriched.FullName = userName.FullName
+		userNameEnriched.ParseConfidence = parseConfidence
+
+		return userNameEnriched, nil
+	}
 
-		return nil
-	})
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return models.ResponseEnrich{}, fmt.Errorf("s.pg.GetUserByIdentity(ctx, userName): %w", err)
+	}
+
+	namesakes, err := s.pg.FindUsersByName(ctx, userName.Name)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return models.ResponseEnrich{}, fmt.Errorf("s.pg.FindUsersByName(ctx, userName.Name): %w", err)
+	}
+
+	if namesake, ok := s.reusableNamesake(namesakes); ok {
+		s.metrics.cacheHits.WithLabelValues(cacheHitName).Inc()
 
-	if err = eg.Wait(); err != nil {
-		return models.ResponseEnrich{}, fmt.Errorf("eg.Wait(): %w", err)
+		userNameEnriched = reuse(namesake, userName)
+	} else {
+		userNameEnriched, err = s.resolve(ctx, userName)
+		if err != nil {
+			return models.ResponseEnrich{}, fmt.Errorf("s.resolve(ctx, userName): %w", err)
+		}
 	}
 
+	userNameEnriched.ID = uuid.NewString()
+
 	started := time.Now()
 	defer func() {
 		s.metrics.duration.WithLabelValues("save_user").Observe(time.Since(started).Seconds())
 	}()
 
-	if err = s.pg.SaveUser(ctx, userNameEnriched); err != nil {
+	savedUser, err := s.pg.SaveUser(ctx, userNameEnriched)
+	if err != nil {
 		return userNameEnriched, fmt.Errorf("s.pg.SaveUser(ctx, userNameEnriched): %w", err)
 	}
 
-	return userNameEnriched, nil
+	savedUser.FullName = userName.FullName
+	savedUser.ParseConfidence = parseConfidence
+
+	return savedUser, nil
 }
 
-func (s *Service) GetUsersList(ctx context.Context, params models.ListingQueryParams) ([]models.ResponseEnrich, error) {
-	usersList, err := s.pg.GetUsersList(ctx, params)
+func (s *Service) GetUsersList(ctx context.Context, params models.ListingQueryParams) (models.UsersPage, error) {
+	usersPage, err := s.pg.GetUsersList(ctx, params)
 	if err != nil {
-		return nil, fmt.Errorf("s.pg.GetUsersList(ctx, params): %w", err)
+		return models.UsersPage{}, fmt.Errorf("s.pg.GetUsersList(ctx, params): %w", err)
 	}
 
-	return usersList, nil
+	return usersPage, nil
 }
 
-func (s *Service) UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
-	currentUser, err := s.pg.GetUser(ctx, user.Name)
+// ExportUsers calls fn for every user matching the filters of params in their order; pagination
+// params are ignored. It stops at the first error of fn.
+func (s *Service) ExportUsers(ctx context.Context, params models.ListingQueryParams,
+	fn func(user models.ResponseEnrich) error,
+) error {
+	if err := s.pg.ExportUsers(ctx, params, fn); err != nil {
+		return fmt.Errorf("s.pg.ExportUsers(ctx, params, fn): %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) GetUser(ctx context.Context, userID string) (models.ResponseEnrich, error) {
+	user, err := s.pg.GetUser(ctx, userID)
 	if err != nil {
-		return models.ResponseEnrich{}, fmt.Errorf("s.pg.GetUser(ctx, user.Name): %w", err)
+		return models.ResponseEnrich{}, fmt.Errorf("s.pg.GetUser(ctx, userID): %w", err)
 	}
 
-	if user.Surname == "" {
-		user.Surname = currentUser.Surname
+	return user, nil
+}
+
+// FindSimilarUsers returns the stored users whose names sound like the name, e.g. Catherine and
+// Kathryn for Katherine.
+func (s *Service) FindSimilarUsers(ctx context.Context, userName string) ([]models.ResponseEnrich, error) {
+	users, err := s.pg.FindSimilarUsers(ctx, strings.TrimSpace(userName))
+	if err != nil {
+		return nil, fmt.Errorf("s.pg.FindSimilarUsers(ctx, userName): %w", err)
 	}
 
-	if user.Patronymic == "" {
-		user.Patronymic = currentUser.Patronymic
+	return users, nil
+}
+
+// FindUserID returns the ID of the user addressed by the name in the routes that address users by
+// name. The user with only that name, i.e. no surname and patronymic, is taken by its identity;
+// otherwise the name must belong to one user and models.ErrUserAmbiguous is returned when it is shared.
+func (s *Service) FindUserID(ctx context.Context, userName string) (string, error) {
+	userName = strings.TrimSpace(userName)
+
+	user, err := s.pg.GetUserByIdentity(ctx, models.RequestEnrich{Name: userName})
+	if err == nil {
+		return user.ID, nil
 	}
 
-	if user.Age == 0 {
-		user.Age = currentUser.Age
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return "", fmt.Errorf("s.pg.GetUserByIdentity(ctx, userName): %w", err)
 	}
 
-	if user.Gender == "" {
-		user.Gender = currentUser.Gender
+	users, err := s.pg.FindUsersByName(ctx, userName)
+	if err != nil {
+		return "", fmt.Errorf("s.pg.FindUsersByName(ctx, userName): %w", err)
 	}
 
-	if user.Country == "" {
-		user.Country = currentUser.Country
+	switch len(users) {
+	case 0:
+		return "", models.ErrUserNotFound
+	case 1:
+		return users[0].ID, nil
+	}
+
+	return "", models.ErrUserAmbiguous
+}
+
+func (s *Service) UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
+	currentUser, err := s.pg.GetUser(ctx, user.ID)
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("s.pg.GetUser(ctx, user.ID): %w", err)
+	}
+
+	user.Provenance = mergeManualChanges(&user, currentUser, time.Now().UTC())
+
+	if err = lockFields(&user); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("lockFields(&user): %w", err)
 	}
 
 	started := time.Now()
@@ -158,14 +304,137 @@ func (s *Service) UpdateUser(ctx context.Context, user models.ResponseEnrich) (m
 	return updatedUser, nil
 }
 
-func (s *Service) DeleteUser(ctx context.Context, userName string) error {
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
 	started := time.Now()
 	defer func() {
 		s.metrics.duration.WithLabelValues("delete_user").Observe(time.Since(started).Seconds())
 	}()
 
-	if err := s.pg.DeleteUser(ctx, userName); err != nil {
-		return fmt.Errorf("s.pg.DeleteUser(ctx, userName): %w", err)
+	if err := s.pg.DeleteUser(ctx, userID); err != nil {
+		return fmt.Errorf("s.pg.DeleteUser(ctx, userID): %w", err)
+	}
+
+	s.metrics.deletedUsers.Inc()
+
+	return nil
+}
+
+// RestoreUser brings back a deleted user that has not been purged yet.
+func (s *Service) RestoreUser(ctx context.Context, userID string) (models.ResponseEnrich, error) {
+	started := time.Now()
+	defer func() {
+		s.metrics.duration.WithLabelValues("restore_user").Observe(time.Since(started).Seconds())
+	}()
+
+	user, err := s.pg.RestoreUser(ctx, userID)
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("s.pg.RestoreUser(ctx, userID): %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *Service) GetUserHistory(ctx context.Context, userRef string) ([]models.HistoryRecord, error) {
+	history, err := s.pg.GetUserHistory(ctx, userRef)
+	if err != nil {
+		return nil, fmt.Errorf("s.pg.GetUserHistory(ctx, userRef): %w", err)
+	}
+
+	return history, nil
+}
+
+func mergeParsedName(userName models.RequestEnrich, parsed models.ParsedName) models.RequestEnrich {
+	if userName.Name == "" {
+		userName.Name = parsed.Name
+	}
+
+	if userName.Surname == "" {
+		userName.Surname = parsed.Surname
+	}
+
+	if userName.Patronymic == "" {
+		userName.Patronymic = parsed.Patronymic
+	}
+
+	return userName
+}
+
+// mergeManualChanges fills the fields missing in the update from the current user and marks the
+// fields that were changed as manually set and locked. A manual country clears the ranked countries.
+func mergeManualChanges(user *models.ResponseEnrich, currentUser models.ResponseEnrich,
+	now time.Time,
+) map[string]models.Provenance {
+	provenance := make(map[string]models.Provenance, len(currentUser.Provenance))
+	for field, p := range currentUser.Provenance {
+		provenance[field] = p
+	}
+
+	manual := models.Provenance{Source: models.SourceManual, FetchedAt: now, Manual: true, Locked: true}
+
+	if user.Surname == "" {
+		user.Surname = currentUser.Surname
+	}
+
+	if user.Patronymic == "" {
+		user.Patronymic = currentUser.Patronymic
+	}
+
+	if user.Age == 0 {
+		user.Age = currentUser.Age
+	} else if user.Age != currentUser.Age {
+		provenance[models.FieldAge] = manual
+	}
+
+	if user.Gender == "" {
+		user.Gender = currentUser.Gender
+	} else if user.Gender != currentUser.Gender {
+		provenance[models.FieldGender] = manual
+	}
+
+	// The ranked countries come from the providers and no longer match a manually set country.
+	user.Countries = currentUser.Countries
+
+	if user.Country == "" {
+		user.Country = currentUser.Country
+	} else if user.Country != currentUser.Country {
+		provenance[models.FieldCountry] = manual
+		user.Countries = nil
+	}
+
+	attributes := make(map[string]interface{}, len(currentUser.Attributes))
+	for attrName, value := range currentUser.Attributes {
+		attributes[attrName] = value
+	}
+
+	for attrName, value := range user.Attributes {
+		if value == nil {
+			delete(attributes, attrName)
+			delete(provenance, attrName)
+
+			continue
+		}
+
+		attributes[attrName] = value
+		provenance[attrName] = manual
+	}
+
+	user.Attributes = attributes
+
+	return provenance
+}
+
+// lockFields applies the locks of the update to the provenance of the user, after the changed fields were
+// locked. Age, gender, country and the attributes of the user can be locked.
+func lockFields(user *models.ResponseEnrich) error {
+	for field, locked := range user.Locks {
+		_, isAttribute := user.Attributes[field]
+		if field != models.FieldAge && field != models.FieldGender && field != models.FieldCountry && !isAttribute {
+			return fmt.Errorf("%w: the user has no %q field", models.ErrLockNotValid, field)
+		}
+
+		p := user.Provenance[field]
+		p.Locked = locked
+		user.Provenance[field] = p
 	}
 
 	return nil
@@ -4,165 +4,472 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/phonetic"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
+	userColumns = `id, name, surname, patronymic, age, gender, country, countries, attributes, provenance,
+	created_at, updated_at, deleted_at`
+	// identityConflict matches enriched_user_identity_idx: a live person is identified by the
+	// normalized name, surname and patronymic.
+	identityConflict = `(lower(btrim(name)), lower(btrim(surname)), lower(btrim(coalesce(patronymic, ''))))
+	WHERE deleted_at IS NULL`
+	// saveUserQuery inserts the user unless a live user has the same identity, which is locked and returned
+	// unchanged instead, also when it was inserted by a concurrent transaction. inserted tells the two apart.
 	saveUserQuery = `
-	INSERT INTO enriched_user (name, surname, patronymic, age, gender, country)
-	VALUES ($1, $2, $3, $4, $5, $6);
+	INSERT INTO enriched_user (id, name, surname, patronymic, age, gender, country, countries, attributes, provenance,
+		phonetic_primary, phonetic_alternate)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT ` + identityConflict + ` DO UPDATE
+	SET id = enriched_user.id
+	RETURNING ` + userColumns + `, (xmax = 0) AS inserted;
+	`
+	resaveUserQuery = `
+	UPDATE enriched_user
+	SET age = $2, gender = $3, country = $4, countries = $5, attributes = $6, provenance = $7, updated_at = now()
+	WHERE id = $1
+	RETURNING ` + userColumns + `;
+	`
+	getUserByIdentityQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE lower(btrim(name)) = lower(btrim($1))
+		AND lower(btrim(surname)) = lower(btrim($2))
+		AND lower(btrim(coalesce(patronymic, ''))) = lower(btrim($3))
+		AND deleted_at IS NULL
 	`
 	getUserQuery = `
-	SELECT name, surname, patronymic, age, gender, country
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE id = $1 AND deleted_at IS NULL
+	`
+	getDeletedUserQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+	findUsersByNameQuery = `
+	SELECT ` + userColumns + `
 	FROM enriched_user
-	WHERE name = $1
+	WHERE lower(btrim(name)) = lower(btrim($1)) AND deleted_at IS NULL
+	ORDER BY surname, patronymic, id
 	`
 	updateUserQuery = `
 	UPDATE enriched_user
-	SET surname = $2, patronymic = $3, age = $4, gender = $5, country = $6
-	WHERE name = $1
-	RETURNING name, surname, patronymic, age, gender, country;
+	SET surname = $2, patronymic = $3, age = $4, gender = $5, country = $6, attributes = $7, provenance = $8,
+		countries = $9, updated_at = now()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + userColumns + `;
 	`
 	deleteUserQuery = `
+	UPDATE enriched_user
+	SET deleted_at = now()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + userColumns + `;
+	`
+	restoreUserQuery = `
+	UPDATE enriched_user
+	SET deleted_at = NULL, updated_at = now()
+	WHERE id = $1
+	RETURNING ` + userColumns + `;
+	`
+	purgeDeletedUsersQuery = `
 	DELETE FROM enriched_user
-	WHERE name = $1;
+	WHERE deleted_at < $1
+	RETURNING ` + userColumns + `;
 	`
+	id         = "id"
 	name       = "name"
 	surname    = "surname"
 	patronymic = "patronymic"
 	age        = "age"
 	gender     = "gender"
 	country    = "country"
+	createdAt  = "created_at"
+	updatedAt  = "updated_at"
+	score      = "score"
+)
+
+const uniqueViolation = "23505"
+
+var (
+	ErrUserNotFound = models.ErrUserNotFound
+	ErrUserExists   = errors.New("user with the same name, surname and patronymic already exists")
 )
 
-var ErrUserNotFound = errors.New("no such user")
+// SaveUser inserts the user or, if a user with the same identity exists, overwrites its enrichment keeping
+// the stored ID and the locked values, see keepLocked. It returns the user as stored and records the change
+// in the history.
+func (p *Postgres) SaveUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
+	var savedUser models.ResponseEnrich
 
-func (p *Postgres) SaveUser(ctx context.Context, user models.ResponseEnrich) error {
-	_, err := p.db.Exec(ctx, saveUserQuery, user.Name, user.Surname, user.Patronymic, user.Age, user.Gender, user.Country)
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		var (
+			currentUser models.ResponseEnrich
+			inserted    bool
+		)
+
+		phoneticPrimary, phoneticAlternate := phonetic.Keys(user.Name)
+
+		err := tx.QueryRow(ctx, saveUserQuery, user.ID, user.Name, user.Surname, user.Patronymic, user.Age,
+			user.Gender, user.Country, user.Countries, attributesOrEmpty(user.Attributes),
+			provenanceOrEmpty(user.Provenance), phoneticPrimary, phoneticAlternate).
+			Scan(append(userDest(&currentUser), &inserted)...)
+		if err != nil {
+			return fmt.Errorf("tx.QueryRow(ctx, saveUserQuery).Scan: %w", err)
+		}
+
+		if inserted {
+			savedUser = currentUser
+
+			return insertHistory(ctx, tx, models.OperationCreate, nil, &savedUser)
+		}
+
+		user = keepLocked(currentUser, user)
+
+		savedUser, err = scanUser(tx.QueryRow(ctx, resaveUserQuery, currentUser.ID, user.Age, user.Gender,
+			user.Country, user.Countries, attributesOrEmpty(user.Attributes), provenanceOrEmpty(user.Provenance)))
+		if err != nil {
+			return fmt.Errorf("scanUser: %w", err)
+		}
+
+		return insertHistory(ctx, tx, models.OperationUpdate, &currentUser, &savedUser)
+	})
 	if err != nil {
-		return fmt.Errorf("p.db.Exec(ctx, saveUserQuery): %w", err)
+		return models.ResponseEnrich{}, fmt.Errorf("p.inTx: %w", err)
 	}
 
-	return nil
+	return savedUser, nil
 }
 
-func (p *Postgres) GetUser(ctx context.Context, userName string) (models.ResponseEnrich, error) {
-	row := p.db.QueryRow(ctx, getUserQuery, userName)
+func (p *Postgres) GetUser(ctx context.Context, userID string) (models.ResponseEnrich, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
 
-	var user models.ResponseEnrich
+	defer conn.Release()
 
-	err := row.Scan(&user.Name, &user.Surname, &user.Patronymic, &user.Age, &user.Gender, &user.Country)
+	user, err := scanUser(conn.QueryRow(ctx, getUserQuery, userID))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return models.ResponseEnrich{}, ErrUserNotFound
 		}
 
-		return models.ResponseEnrich{}, fmt.Errorf("row.Scan: %w", err)
+		return models.ResponseEnrich{}, fmt.Errorf("scanUser: %w", err)
 	}
 
 	return user, nil
 }
 
-func (p *Postgres) GetUsersList(ctx context.Context, params models.ListingQueryParams) (
-	[]models.ResponseEnrich, error,
+func (p *Postgres) GetUserByIdentity(ctx context.Context, userName models.RequestEnrich) (
+	models.ResponseEnrich, error,
 ) {
-	tableColumnsList := map[string]string{
-		name:       name,
-		surname:    surname,
-		patronymic: patronymic,
-		age:        age,
-		gender:     gender,
-		country:    country,
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("p.acquire(ctx): %w", err)
 	}
 
-	var args []interface{}
+	defer conn.Release()
 
-	query := `
-	SELECT name, surname, patronymic, age, gender, country
-	FROM enriched_user
-	WHERE TRUE
-	`
+	row := conn.QueryRow(ctx, getUserByIdentityQuery, userName.Name, userName.Surname, userName.Patronymic)
+
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ResponseEnrich{}, ErrUserNotFound
+		}
 
-	updatedQuery, updatedArgs := p.buildQueryAndArgs(tableColumnsList, args, query, params)
+		return models.ResponseEnrich{}, fmt.Errorf("scanUser: %w", err)
+	}
+
+	return user, nil
+}
 
-	rows, err := p.db.Query(ctx, updatedQuery, updatedArgs...)
+func (p *Postgres) FindUsersByName(ctx context.Context, userName string) ([]models.ResponseEnrich, error) {
+	conn, err := p.acquire(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("p.db.Query(ctx, updatedQuery, updatedArgs...): %w", err)
+		return nil, fmt.Errorf("p.acquire(ctx): %w", err)
 	}
 
-	defer rows.Close()
+	defer conn.Release()
 
-	usersList := make([]models.ResponseEnrich, 0)
+	rows, err := conn.Query(ctx, findUsersByNameQuery, userName)
+	if err != nil {
+		return nil, fmt.Errorf("conn.Query(ctx, findUsersByNameQuery, userName): %w", err)
+	}
 
-	for rows.Next() {
-		var user models.ResponseEnrich
+	users, err := collectUsers(rows, scanUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return users, nil
+}
+
+// GetUsersList returns a page of the filtered users. The page continues from params.Cursor when it is
+// set and from params.Offset otherwise.
+func (p *Postgres) GetUsersList(ctx context.Context, params models.ListingQueryParams) (models.UsersPage, error) {
+	if err := checkPage(params); err != nil {
+		return models.UsersPage{}, fmt.Errorf("checkPage(params): %w", err)
+	}
+
+	keys, err := sortKeys(params)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("sortKeys: %w", err)
+	}
+
+	var (
+		values   []interface{}
+		backward bool
+	)
 
-		err = rows.Scan(&user.Name, &user.Surname, &user.Patronymic, &user.Age, &user.Gender, &user.Country)
+	if params.Cursor != "" {
+		values, backward, err = decodeCursor(params.Cursor, keys)
 		if err != nil {
-			return nil, fmt.Errorf("rows.Scan: %w", err)
+			return models.UsersPage{}, fmt.Errorf("decodeCursor: %w", err)
 		}
+	}
 
-		usersList = append(usersList, user)
+	filteredQuery, filteredArgs := p.filteredUsersQuery(params)
+
+	// The score is ordered and paginated by like a column, so the page is selected from the filtered
+	// users. Postgres pulls the subquery up, which keeps the indexes usable.
+	pageQuery, pageArgs := buildPageQuery(`SELECT * FROM (`+filteredQuery+`) AS listed WHERE TRUE`,
+		filteredArgs, keys, values, backward, params)
+
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("p.acquire(ctx): %w", err)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows.Err(): %w", err)
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, pageQuery, pageArgs...)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("conn.Query(ctx, pageQuery, pageArgs...): %w", err)
 	}
 
-	return usersList, nil
+	users, err := collectUsers(rows, scanListedUser)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("collectUsers(rows, scanListedUser): %w", err)
+	}
+
+	page, err := newUsersPage(users, keys, values != nil, backward, params)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("newUsersPage: %w", err)
+	}
+
+	if params.EstimateTotal {
+		page.Total, err = estimateRows(ctx, conn, filteredQuery, filteredArgs)
+		page.TotalEstimated = true
+	} else {
+		err = conn.QueryRow(ctx, `SELECT count(*) FROM (`+filteredQuery+`) AS filtered`, filteredArgs...).
+			Scan(&page.Total)
+	}
+
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("total: %w", err)
+	}
+
+	return page, nil
 }
 
-func (p *Postgres) UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
-	row := p.db.QueryRow(
-		ctx,
-		updateUserQuery,
-		user.Name,
-		user.Surname,
-		user.Patronymic,
-		user.Age,
-		user.Gender,
-		user.Country,
-	)
+// estimateRows returns the number of rows the planner expects the query to return. It is much
+// cheaper than counting on large tables but may be far off for selective filters.
+func estimateRows(ctx context.Context, conn *pgxpool.Conn, query string, args []interface{}) (int64, error) {
+	var plan []struct {
+		Plan struct {
+			PlanRows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+
+	if err := conn.QueryRow(ctx, `EXPLAIN (FORMAT JSON) `+query, args...).Scan(&plan); err != nil {
+		return 0, fmt.Errorf("conn.QueryRow(ctx, explain).Scan(&plan): %w", err)
+	}
+
+	if len(plan) == 0 {
+		return 0, nil
+	}
+
+	return int64(plan[0].Plan.PlanRows), nil
+}
 
+func (p *Postgres) UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
 	var updatedUser models.ResponseEnrich
 
-	err := row.Scan(
-		&updatedUser.Name,
-		&updatedUser.Surname,
-		&updatedUser.Patronymic,
-		&updatedUser.Age,
-		&updatedUser.Gender,
-		&updatedUser.Country,
-	)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return models.ResponseEnrich{}, ErrUserNotFound
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		oldUser, err := scanUser(tx.QueryRow(ctx, getUserQuery+` FOR UPDATE`, user.ID))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanUser: %w", err)
 		}
 
-		return models.ResponseEnrich{}, fmt.Errorf("row.Scan: %w", err)
+		row := tx.QueryRow(
+			ctx,
+			updateUserQuery,
+			user.ID,
+			user.Surname,
+			user.Patronymic,
+			user.Age,
+			user.Gender,
+			user.Country,
+			attributesOrEmpty(user.Attributes),
+			provenanceOrEmpty(user.Provenance),
+			user.Countries,
+		)
+
+		updatedUser, err = scanUser(row)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+				return ErrUserExists
+			}
+
+			return fmt.Errorf("scanUser: %w", err)
+		}
+
+		return insertHistory(ctx, tx, models.OperationUpdate, &oldUser, &updatedUser)
+	})
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("p.inTx: %w", err)
 	}
 
 	return updatedUser, nil
 }
 
-func (p *Postgres) DeleteUser(ctx context.Context, userName string) error {
-	commandTag, err := p.db.Exec(ctx, deleteUserQuery, userName)
+func (p *Postgres) DeleteUser(ctx context.Context, userID string) error {
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		oldUser, err := scanUser(tx.QueryRow(ctx, deleteUserQuery, userID))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanUser: %w", err)
+		}
+
+		return insertHistory(ctx, tx, models.OperationDelete, &oldUser, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("p.inTx: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreUser undoes the deletion of the user.
+func (p *Postgres) RestoreUser(ctx context.Context, userID string) (models.ResponseEnrich, error) {
+	var restoredUser models.ResponseEnrich
+
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		oldUser, err := scanUser(tx.QueryRow(ctx, getDeletedUserQuery+` FOR UPDATE`, userID))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanUser: %w", err)
+		}
+
+		restoredUser, err = scanUser(tx.QueryRow(ctx, restoreUserQuery, userID))
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+				return ErrUserExists
+			}
+
+			return fmt.Errorf("scanUser: %w", err)
+		}
+
+		return insertHistory(ctx, tx, models.OperationRestore, &oldUser, &restoredUser)
+	})
 	if err != nil {
-		return fmt.Errorf("p.db.Exec(ctx, deleteUserQuery, userName): %w", err)
+		return models.ResponseEnrich{}, fmt.Errorf("p.inTx: %w", err)
 	}
 
-	if commandTag.RowsAffected() != 1 {
-		return ErrUserNotFound
+	return restoredUser, nil
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before the time and returns their number.
+// The last state of every user is recorded in the history like any other change.
+func (p *Postgres) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	var purged int64
+
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, purgeDeletedUsersQuery, deletedBefore)
+		if err != nil {
+			return fmt.Errorf("tx.Query(ctx, purgeDeletedUsersQuery, deletedBefore): %w", err)
+		}
+
+		users, err := collectUsers(rows, scanUser)
+		if err != nil {
+			return fmt.Errorf("collectUsers(rows, scanUser): %w", err)
+		}
+
+		for i := range users {
+			if err = insertHistory(ctx, tx, models.OperationPurge, &users[i], nil); err != nil {
+				return err
+			}
+		}
+
+		purged = int64(len(users))
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("p.inTx: %w", err)
 	}
 
-	return nil
+	return purged, nil
 }
 
-func (*Postgres) buildQueryAndArgs(tableColumnsList map[string]string, args []interface{}, query string,
-	params models.ListingQueryParams,
-) (string, []interface{}) {
+// filteredUsersQuery selects the users matching the filters of params along with their search score.
+func (p *Postgres) filteredUsersQuery(params models.ListingQueryParams) (string, []interface{}) {
+	var args []interface{}
+
+	score := `0::real`
+	if params.Query != "" {
+		args = append(args, params.Query)
+		score = `word_similarity($1, search_text)`
+	}
+
+	query := `
+	SELECT ` + userColumns + `, ` + score + ` AS score
+	FROM enriched_user
+	WHERE TRUE
+	`
+
+	return p.buildQueryAndArgs(args, query, params)
+}
+
+// buildQueryAndArgs adds the filters of the params to the query.
+func (*Postgres) buildQueryAndArgs(args []interface{}, query string, params models.ListingQueryParams) (
+	string, []interface{},
+) {
+	if !params.IncludeDeleted {
+		query += ` AND deleted_at IS NULL`
+	}
+
+	if params.Query != "" {
+		args = append(args, params.Query)
+		query += fmt.Sprintf(` AND $%d <%% search_text`, len(args))
+	}
+
 	if params.TextFilter != "" {
 		args = append(args, "%"+params.TextFilter+"%")
 		query += fmt.Sprintf(` AND (
@@ -170,23 +477,187 @@ func (*Postgres) buildQueryAndArgs(tableColumnsList map[string]string, args []in
 			)`, len(args), len(args), len(args), len(args), len(args))
 	}
 
-	order := ` ORDER BY name`
+	for _, attrName := range sortedKeys(params.Attributes) {
+		args = append(args, attrName, params.Attributes[attrName])
+		query += fmt.Sprintf(` AND attributes ->> $%d = $%d`, len(args)-1, len(args))
+	}
+
+	timeRanges := []struct {
+		column string
+		value  time.Time
+		op     string
+	}{
+		{createdAt, params.CreatedAfter, ">="},
+		{createdAt, params.CreatedBefore, "<"},
+		{updatedAt, params.UpdatedAfter, ">="},
+		{updatedAt, params.UpdatedBefore, "<"},
+	}
+
+	for _, r := range timeRanges {
+		if !r.value.IsZero() {
+			args = append(args, r.value)
+			query += fmt.Sprintf(` AND %s %s $%d`, r.column, r.op, len(args))
+		}
+	}
+
+	if params.AgeMin != nil {
+		args = append(args, *params.AgeMin)
+		query += fmt.Sprintf(` AND age >= $%d`, len(args))
+	}
+
+	if params.AgeMax != nil {
+		args = append(args, *params.AgeMax)
+		query += fmt.Sprintf(` AND age <= $%d`, len(args))
+	}
 
-	sorting, ok := tableColumnsList[params.Sorting]
-	if ok {
-		order = fmt.Sprintf(` ORDER BY %s`, sorting)
+	if params.Gender != "" {
+		args = append(args, params.Gender)
+		query += fmt.Sprintf(` AND gender = $%d`, len(args))
 	}
 
-	if params.Descending {
-		order += ` DESC`
+	if len(params.Countries) > 0 {
+		args = append(args, params.Countries)
+		query += fmt.Sprintf(` AND country = ANY($%d)`, len(args))
 	}
 
-	query += order
+	if params.SurnamePrefix != "" {
+		args = append(args, escapeLike(params.SurnamePrefix)+"%")
+		query += fmt.Sprintf(` AND surname ILIKE $%d`, len(args))
+	}
 
-	args = append(args, params.ItemsPerPage)
-	query += fmt.Sprintf(` LIMIT $%d`, len(args))
-	args = append(args, params.Offset)
-	query += fmt.Sprintf(` OFFSET $%d`, len(args))
+	if params.ManualOverride != "" {
+		args = append(args, params.ManualOverride)
+		query += fmt.Sprintf(` AND (provenance -> $%d ->> 'manual')::boolean`, len(args))
+	}
 
 	return query, args
 }
+
+// escapeLike makes the LIKE wildcards of the value match literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func attributesOrEmpty(attributes map[string]interface{}) map[string]interface{} {
+	if attributes == nil {
+		return map[string]interface{}{}
+	}
+
+	return attributes
+}
+
+func scanUser(row pgx.Row) (models.ResponseEnrich, error) {
+	var user models.ResponseEnrich
+
+	if err := row.Scan(userDest(&user)...); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("row.Scan: %w", err)
+	}
+
+	return user, nil
+}
+
+// scanListedUser scans a row of the users list, which has the search score after the user columns.
+func scanListedUser(row pgx.Row) (models.ResponseEnrich, error) {
+	var user models.ResponseEnrich
+
+	if err := row.Scan(append(userDest(&user), &user.Score)...); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("row.Scan: %w", err)
+	}
+
+	return user, nil
+}
+
+// userDest returns the scan destinations in the order of userColumns.
+func userDest(user *models.ResponseEnrich) []interface{} {
+	return []interface{}{
+		&user.ID,
+		&user.Name,
+		&user.Surname,
+		&user.Patronymic,
+		&user.Age,
+		&user.Gender,
+		&user.Country,
+		&user.Countries,
+		&user.Attributes,
+		&user.Provenance,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeletedAt,
+	}
+}
+
+func collectUsers(rows pgx.Rows, scan func(pgx.Row) (models.ResponseEnrich, error)) ([]models.ResponseEnrich, error) {
+	defer rows.Close()
+
+	usersList := make([]models.ResponseEnrich, 0)
+
+	for rows.Next() {
+		user, err := scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		usersList = append(usersList, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return usersList, nil
+}
+
+func provenanceOrEmpty(provenance map[string]models.Provenance) map[string]models.Provenance {
+	if provenance == nil {
+		return map[string]models.Provenance{}
+	}
+
+	return provenance
+}
+
+// replaces reports whether a value with the provenance may replace the stored one: a provider result never
+// replaces a locked value, a manually set value does.
+func replaces(stored, value models.Provenance) bool {
+	return !stored.Locked || value.Manual
+}
+
+// keepLocked gives the user the values of the stored user its own values cannot replace, see replaces, with
+// their provenance. The maps of the user are copied.
+func keepLocked(currentUser, user models.ResponseEnrich) models.ResponseEnrich {
+	attributes := make(map[string]interface{}, len(user.Attributes))
+	for attrName, value := range user.Attributes {
+		attributes[attrName] = value
+	}
+
+	provenance := make(map[string]models.Provenance, len(user.Provenance))
+	for field, p := range user.Provenance {
+		provenance[field] = p
+	}
+
+	user.Attributes, user.Provenance = attributes, provenance
+
+	for field, p := range currentUser.Provenance {
+		if replaces(p, user.Provenance[field]) {
+			continue
+		}
+
+		user.Provenance[field] = p
+
+		switch field {
+		case models.FieldAge:
+			user.Age = currentUser.Age
+		case models.FieldGender:
+			user.Gender = currentUser.Gender
+		case models.FieldCountry:
+			user.Country, user.Countries = currentUser.Country, currentUser.Countries
+		default:
+			if value, ok := currentUser.Attributes[field]; ok {
+				user.Attributes[field] = value
+			} else {
+				delete(user.Attributes, field)
+			}
+		}
+	}
+
+	return user
+}
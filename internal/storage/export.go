@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// exportBatchSize is the number of users fetched from the export cursor at once.
+const exportBatchSize = 500
+
+// ExportUsers calls fn for every user matching the filters of params in their order. The users are read
+// in batches from a cursor of a read-only snapshot, so the memory used does not depend on their number.
+func (p *Postgres) ExportUsers(ctx context.Context, params models.ListingQueryParams,
+	fn func(user models.ResponseEnrich) error,
+) error {
+	keys, err := sortKeys(params)
+	if err != nil {
+		return fmt.Errorf("sortKeys: %w", err)
+	}
+
+	filteredQuery, filteredArgs := p.filteredUsersQuery(params)
+
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("conn.BeginTx(ctx, txOptions): %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			p.log.Warningf("tx.Rollback(ctx): %s", err)
+		}
+	}()
+
+	_, err = tx.Exec(ctx, `DECLARE export_cursor NO SCROLL CURSOR FOR SELECT * FROM (`+filteredQuery+`) AS listed`+
+		orderBy(keys, false), filteredArgs...)
+	if err != nil {
+		return fmt.Errorf("tx.Exec(ctx, declareCursor): %w", err)
+	}
+
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf(`FETCH %d FROM export_cursor`, exportBatchSize))
+		if err != nil {
+			return fmt.Errorf("tx.Query(ctx, fetch): %w", err)
+		}
+
+		users, err := collectUsers(rows, scanListedUser)
+		if err != nil {
+			return fmt.Errorf("collectUsers(rows, scanListedUser): %w", err)
+		}
+
+		for _, user := range users {
+			if err = fn(user); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < exportBatchSize {
+			return nil
+		}
+	}
+}
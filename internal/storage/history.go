@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	insertHistoryQuery = `
+	INSERT INTO user_history (user_id, name, operation, old_value, new_value, actor, request_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7);
+	`
+	getUserHistoryByIDQuery = `
+	SELECT id, user_id, name, operation, old_value, new_value, actor, request_id, created_at
+	FROM user_history
+	WHERE user_id = $1
+	ORDER BY created_at, id
+	`
+	getUserHistoryByNameQuery = `
+	SELECT id, user_id, name, operation, old_value, new_value, actor, request_id, created_at
+	FROM user_history
+	WHERE lower(btrim(name)) = lower(btrim($1))
+	ORDER BY created_at, id
+	`
+)
+
+// GetUserHistory returns the changes of the user with the ID or of every user with the name,
+// including deleted ones, oldest first.
+func (p *Postgres) GetUserHistory(ctx context.Context, userRef string) ([]models.HistoryRecord, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	// A reference is either an ID or a name, so each lookup can use its index.
+	query := getUserHistoryByNameQuery
+	if userID, err := uuid.Parse(userRef); err == nil {
+		query, userRef = getUserHistoryByIDQuery, userID.String()
+	}
+
+	rows, err := conn.Query(ctx, query, userRef)
+	if err != nil {
+		return nil, fmt.Errorf("conn.Query(ctx, query, userRef): %w", err)
+	}
+
+	defer rows.Close()
+
+	history := make([]models.HistoryRecord, 0)
+
+	for rows.Next() {
+		var record models.HistoryRecord
+
+		err = rows.Scan(
+			&record.ID,
+			&record.UserID,
+			&record.Name,
+			&record.Operation,
+			&record.OldValue,
+			&record.NewValue,
+			&record.Actor,
+			&record.RequestID,
+			&record.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		history = append(history, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return history, nil
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds.
+func (p *Postgres) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("conn.Begin(ctx): %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			p.log.Warningf("tx.Rollback(ctx): %s", err)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("tx.Commit(ctx): %w", err)
+	}
+
+	return nil
+}
+
+// insertHistory appends the change to user_history, taking the actor and request ID from ctx.
+func insertHistory(ctx context.Context, tx pgx.Tx, operation string, oldUser, newUser *models.ResponseEnrich) error {
+	user := newUser
+	if user == nil {
+		user = oldUser
+	}
+
+	meta := audit.FromContext(ctx)
+
+	_, err := tx.Exec(ctx, insertHistoryQuery, user.ID, user.Name, operation, oldUser, newUser, meta.Actor,
+		meta.RequestID)
+	if err != nil {
+		return fmt.Errorf("tx.Exec(ctx, insertHistoryQuery): %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/phonetic"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	createImportTableQuery = `
+	CREATE TEMP TABLE import_user (
+		id UUID, name VARCHAR, surname VARCHAR, patronymic VARCHAR, age INT, gender VARCHAR, country VARCHAR,
+		countries JSONB, attributes JSONB, provenance JSONB, phonetic_primary VARCHAR, phonetic_alternate VARCHAR
+	) ON COMMIT DROP
+	`
+	// lockImportedUsersQuery returns the live users the imported ones update by the ID of the imported user.
+	lockImportedUsersQuery = `
+	SELECT import_id, ` + userColumns + `
+	FROM (
+		SELECT imported.id AS import_id, stored.*
+		FROM import_user AS imported
+		JOIN enriched_user AS stored
+			ON lower(btrim(stored.name)) = lower(btrim(imported.name))
+			AND lower(btrim(stored.surname)) = lower(btrim(imported.surname))
+			AND lower(btrim(coalesce(stored.patronymic, ''))) = lower(btrim(coalesce(imported.patronymic, '')))
+			AND stored.deleted_at IS NULL
+		FOR UPDATE OF stored
+	) AS matched
+	`
+	// keptFields are the locked fields of the stored user the imported one has no manually set value for,
+	// see keepLocked.
+	keptFields = `ARRAY(
+		SELECT stored.key
+		FROM jsonb_each(enriched_user.provenance) AS stored
+		WHERE coalesce((stored.value->>'locked')::boolean, false)
+			AND NOT coalesce((EXCLUDED.provenance->stored.key->>'manual')::boolean, false)
+	)`
+	// importUsersQuery saves the imported users. Unlike saveUserQuery the values missing from an imported
+	// user keep the stored ones, see mergeImported.
+	importUsersQuery = `
+	INSERT INTO enriched_user (id, name, surname, patronymic, age, gender, country, countries, attributes, provenance,
+		phonetic_primary, phonetic_alternate)
+	SELECT id, name, surname, patronymic, age, gender, country, countries, attributes, provenance,
+		phonetic_primary, phonetic_alternate
+	FROM import_user
+	ON CONFLICT ` + identityConflict + ` DO UPDATE
+	SET age = CASE WHEN EXCLUDED.age = 0 OR 'age' = ANY(` + keptFields + `) THEN enriched_user.age
+			ELSE EXCLUDED.age END,
+		gender = CASE WHEN 'gender' = ANY(` + keptFields + `) THEN enriched_user.gender
+			ELSE coalesce(nullif(EXCLUDED.gender, ''), enriched_user.gender) END,
+		country = CASE WHEN 'country' = ANY(` + keptFields + `) THEN enriched_user.country
+			ELSE coalesce(nullif(EXCLUDED.country, ''), enriched_user.country) END,
+		countries = CASE WHEN EXCLUDED.country = '' OR 'country' = ANY(` + keptFields + `) THEN enriched_user.countries
+			ELSE EXCLUDED.countries END,
+		attributes = enriched_user.attributes || (EXCLUDED.attributes - ` + keptFields + `),
+		provenance = enriched_user.provenance || (EXCLUDED.provenance - ` + keptFields + `),
+		updated_at = now()
+	RETURNING ` + userColumns + `;
+	`
+)
+
+var errUserNotImported = errors.New("saved user is not one of the imported users")
+
+var (
+	importUserColumns = []string{
+		"id", "name", "surname", "patronymic", "age", "gender", "country", "countries", "attributes", "provenance",
+		"phonetic_primary", "phonetic_alternate",
+	}
+	historyColumns = []string{"user_id", "name", "operation", "old_value", "new_value", "actor", "request_id"}
+)
+
+// ImportUsers saves the users in one transaction, loading them and their history with COPY. A user with
+// the identity of a stored one updates it, see mergeImported; the users must have distinct identities.
+// The saved users are returned in the order of users.
+func (p *Postgres) ImportUsers(ctx context.Context, users []models.ResponseEnrich) ([]models.ImportedUser, error) {
+	imported := make([]models.ImportedUser, len(users))
+
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, createImportTableQuery); err != nil {
+			return fmt.Errorf("tx.Exec(ctx, createImportTableQuery): %w", err)
+		}
+
+		_, err := tx.CopyFrom(ctx, pgx.Identifier{"import_user"}, importUserColumns,
+			pgx.CopyFromSlice(len(users), func(i int) ([]interface{}, error) {
+				user := users[i]
+				phoneticPrimary, phoneticAlternate := phonetic.Keys(user.Name)
+
+				return []interface{}{
+					user.ID, user.Name, user.Surname, user.Patronymic, user.Age, user.Gender, user.Country,
+					user.Countries, attributesOrEmpty(user.Attributes), provenanceOrEmpty(user.Provenance),
+					phoneticPrimary, phoneticAlternate,
+				}, nil
+			}))
+		if err != nil {
+			return fmt.Errorf("tx.CopyFrom(ctx, import_user): %w", err)
+		}
+
+		index := make(map[string]int, len(users))
+		for i, user := range users {
+			index[user.ID] = i
+		}
+
+		oldUsers, err := lockImportedUsers(ctx, tx, index)
+		if err != nil {
+			return fmt.Errorf("lockImportedUsers(ctx, tx, index): %w", err)
+		}
+
+		rows, err := tx.Query(ctx, importUsersQuery)
+		if err != nil {
+			return fmt.Errorf("tx.Query(ctx, importUsersQuery): %w", err)
+		}
+
+		savedUsers, err := collectUsers(rows, scanUser)
+		if err != nil {
+			return fmt.Errorf("collectUsers(rows, scanUser): %w", err)
+		}
+
+		history := make([][]interface{}, 0, len(savedUsers))
+		meta := audit.FromContext(ctx)
+
+		for _, savedUser := range savedUsers {
+			savedUser := savedUser
+			operation := models.OperationCreate
+
+			var oldUser *models.ResponseEnrich
+
+			i, ok := index[savedUser.ID]
+			if locked, updated := oldUsers[savedUser.ID]; updated {
+				operation = models.OperationUpdate
+				oldUser = &locked.user
+				i, ok = locked.index, true
+			}
+
+			if !ok {
+				return fmt.Errorf("%w: %s", errUserNotImported, savedUser.ID)
+			}
+
+			imported[i] = models.ImportedUser{User: savedUser, Operation: operation}
+			history = append(history, []interface{}{
+				savedUser.ID, savedUser.Name, operation, oldUser, &savedUser, meta.Actor, meta.RequestID,
+			})
+		}
+
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"user_history"}, historyColumns, pgx.CopyFromRows(history))
+		if err != nil {
+			return fmt.Errorf("tx.CopyFrom(ctx, user_history): %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("p.inTx: %w", err)
+	}
+
+	return imported, nil
+}
+
+// lockedUser is a stored user updated by the imported user at index.
+type lockedUser struct {
+	user  models.ResponseEnrich
+	index int
+}
+
+// lockImportedUsers locks the stored users the imported ones update and returns them by their IDs.
+func lockImportedUsers(ctx context.Context, tx pgx.Tx, index map[string]int) (map[string]lockedUser, error) {
+	rows, err := tx.Query(ctx, lockImportedUsersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("tx.Query(ctx, lockImportedUsersQuery): %w", err)
+	}
+
+	defer rows.Close()
+
+	locked := make(map[string]lockedUser)
+
+	for rows.Next() {
+		var (
+			importID string
+			user     models.ResponseEnrich
+		)
+
+		if err = rows.Scan(append([]interface{}{&importID}, userDest(&user)...)...); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		locked[user.ID] = lockedUser{user: user, index: index[importID]}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return locked, nil
+}
+
+// mergeImported updates the stored user with the values the imported user has, the way importUsersQuery
+// does: zero values and locked values keep the stored ones, see keepLocked, the countries go with the
+// country, and attributes and provenance are merged by key.
+func mergeImported(currentUser, user models.ResponseEnrich) models.ResponseEnrich {
+	user = keepLocked(currentUser, user)
+
+	if user.Age != 0 {
+		currentUser.Age = user.Age
+	}
+
+	if user.Gender != "" {
+		currentUser.Gender = user.Gender
+	}
+
+	if user.Country != "" {
+		currentUser.Country, currentUser.Countries = user.Country, user.Countries
+	}
+
+	if len(user.Attributes) > 0 && currentUser.Attributes == nil {
+		currentUser.Attributes = make(map[string]interface{}, len(user.Attributes))
+	}
+
+	for attrName, value := range user.Attributes {
+		currentUser.Attributes[attrName] = value
+	}
+
+	if len(user.Provenance) > 0 && currentUser.Provenance == nil {
+		currentUser.Provenance = make(map[string]models.Provenance, len(user.Provenance))
+	}
+
+	for field, p := range user.Provenance {
+		currentUser.Provenance[field] = p
+	}
+
+	return currentUser
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	createJobQuery = `
+	INSERT INTO enrichment_job (id, actor, request_id, file_name, header)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING created_at;
+	`
+	getJobQuery = `
+	SELECT id, status, file_name, header, actor, request_id, created_at, updated_at, finished_at
+	FROM enrichment_job
+	WHERE id = $1
+	`
+	getJobItemsQuery = `
+	SELECT position, request, cells, status, attempts, result, error
+	FROM enrichment_job_item
+	WHERE job_id = $1
+	ORDER BY position
+	`
+	// claimJobItemsQuery takes up to $1 items in the order of submission: the pending ones whose retry is due
+	// and those whose claim is older than $2, left by a worker that went away. Items claimed by another worker
+	// at the moment are skipped.
+	claimJobItemsQuery = `
+	WITH claimable AS (
+		SELECT item.job_id, item.position
+		FROM enrichment_job_item AS item
+		JOIN enrichment_job AS job ON job.id = item.job_id
+		WHERE (item.status = 'pending' AND (item.not_before IS NULL OR item.not_before <= now()))
+			OR (item.status = 'running' AND item.claimed_at < $2)
+		ORDER BY job.created_at, item.job_id, item.position
+		LIMIT $1
+		FOR UPDATE OF item SKIP LOCKED
+	), claimed AS (
+		UPDATE enrichment_job_item AS item
+		SET status = 'running', attempts = item.attempts + 1, claimed_at = now()
+		FROM claimable, enrichment_job AS job
+		WHERE item.job_id = claimable.job_id AND item.position = claimable.position AND job.id = item.job_id
+		RETURNING item.job_id, item.position, item.request, item.attempts, job.actor, job.request_id, job.created_at
+	), started AS (
+		UPDATE enrichment_job
+		SET status = 'running', updated_at = now()
+		WHERE status = 'queued' AND id IN (SELECT job_id FROM claimed)
+	)
+	SELECT job_id, position, request, attempts, actor, request_id
+	FROM claimed
+	ORDER BY created_at, job_id, position
+	`
+	finishJobItemQuery = `
+	UPDATE enrichment_job_item
+	SET status = $3, attempts = $4, result = $5, error = $6, claimed_at = NULL, not_before = $7,
+		finished_at = CASE WHEN $3 IN ('done', 'failed') THEN now() END
+	WHERE job_id = $1 AND position = $2
+	`
+	// updateJobQuery completes the job once none of its items is left to enrich.
+	updateJobQuery = `
+	UPDATE enrichment_job
+	SET status = CASE WHEN unfinished THEN status ELSE 'completed' END,
+		finished_at = CASE WHEN unfinished THEN NULL ELSE now() END,
+		updated_at = now()
+	FROM (
+		SELECT EXISTS (
+			SELECT 1 FROM enrichment_job_item WHERE job_id = $1 AND status IN ('pending', 'running')
+		) AS unfinished
+	) AS items
+	WHERE id = $1
+	RETURNING status, updated_at, finished_at
+	`
+)
+
+var ErrJobNotFound = errors.New("no such job")
+
+var jobItemColumns = []string{"job_id", "position", "request", "cells", "status", "error", "finished_at"}
+
+// CreateJob saves the queued job with its items, loading them with COPY. Items that are failed already
+// are saved as finished. The job is returned without the items.
+func (p *Postgres) CreateJob(ctx context.Context, job models.Job) (models.Job, error) {
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, createJobQuery, job.ID, job.Actor, job.RequestID, job.FileName, job.Header).
+			Scan(&job.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("tx.QueryRow(ctx, createJobQuery).Scan: %w", err)
+		}
+
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"enrichment_job_item"}, jobItemColumns,
+			pgx.CopyFromSlice(len(job.Items), func(i int) ([]interface{}, error) {
+				item := job.Items[i]
+				status := newJobItemStatus(item)
+
+				var finishedAt interface{}
+				if status == models.JobItemFailed {
+					finishedAt = job.CreatedAt
+				}
+
+				return []interface{}{
+					job.ID, item.Position, item.Request, item.Cells, status, item.Error, finishedAt,
+				}, nil
+			}))
+		if err != nil {
+			return fmt.Errorf("tx.CopyFrom(ctx, enrichment_job_item): %w", err)
+		}
+
+		err = tx.QueryRow(ctx, updateJobQuery, job.ID).Scan(&job.Status, &job.UpdatedAt, &job.FinishedAt)
+		if err != nil {
+			return fmt.Errorf("tx.QueryRow(ctx, updateJobQuery).Scan: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.Job{}, fmt.Errorf("p.inTx: %w", err)
+	}
+
+	return createdJob(job), nil
+}
+
+// GetJob returns the job with its items in the order they were submitted.
+func (p *Postgres) GetJob(ctx context.Context, jobID string) (models.Job, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	var job models.Job
+
+	err = conn.QueryRow(ctx, getJobQuery, jobID).Scan(&job.ID, &job.Status, &job.FileName, &job.Header, &job.Actor,
+		&job.RequestID, &job.CreatedAt, &job.UpdatedAt, &job.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Job{}, ErrJobNotFound
+	}
+
+	if err != nil {
+		return models.Job{}, fmt.Errorf("conn.QueryRow(ctx, getJobQuery, jobID).Scan: %w", err)
+	}
+
+	rows, err := conn.Query(ctx, getJobItemsQuery, jobID)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("conn.Query(ctx, getJobItemsQuery, jobID): %w", err)
+	}
+
+	defer rows.Close()
+
+	job.Items = make([]models.JobItem, 0)
+
+	for rows.Next() {
+		item := models.JobItem{JobID: job.ID}
+
+		err = rows.Scan(&item.Position, &item.Request, &item.Cells, &item.Status, &item.Attempts, &item.User,
+			&item.Error)
+		if err != nil {
+			return models.Job{}, fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		job.Items = append(job.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.Job{}, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	countJobItems(&job)
+
+	return job, nil
+}
+
+// ClaimJobItems marks up to limit items as running and returns them, oldest job first. Items claimed before
+// staleBefore are taken over, as their worker is considered gone.
+func (p *Postgres) ClaimJobItems(ctx context.Context, limit int, staleBefore time.Time) ([]models.JobItem, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, claimJobItemsQuery, limit, staleBefore)
+	if err != nil {
+		return nil, fmt.Errorf("conn.Query(ctx, claimJobItemsQuery, limit, staleBefore): %w", err)
+	}
+
+	defer rows.Close()
+
+	items := make([]models.JobItem, 0, limit)
+
+	for rows.Next() {
+		item := models.JobItem{Status: models.JobItemRunning}
+
+		err = rows.Scan(&item.JobID, &item.Position, &item.Request, &item.Attempts, &item.Actor, &item.RequestID)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return items, nil
+}
+
+// FinishJobItem saves the status, attempts and result of the claimed item and completes its job once no item
+// is left to enrich. A pending item is given back to the workers, not before its NotBefore if it is set.
+func (p *Postgres) FinishJobItem(ctx context.Context, item models.JobItem) error {
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		// The job is locked so that the items finishing at the same time see each other.
+		if _, err := tx.Exec(ctx, getJobQuery+` FOR UPDATE`, item.JobID); err != nil {
+			return fmt.Errorf("tx.Exec(ctx, getJobQuery): %w", err)
+		}
+
+		tag, err := tx.Exec(ctx, finishJobItemQuery, item.JobID, item.Position, item.Status, item.Attempts, item.User,
+			item.Error, item.NotBefore)
+		if err != nil {
+			return fmt.Errorf("tx.Exec(ctx, finishJobItemQuery): %w", err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			return ErrJobNotFound
+		}
+
+		if _, err = tx.Exec(ctx, updateJobQuery, item.JobID); err != nil {
+			return fmt.Errorf("tx.Exec(ctx, updateJobQuery): %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("p.inTx: %w", err)
+	}
+
+	return nil
+}
+
+// newJobItemStatus is the status an item is created with: pending unless it is failed already.
+func newJobItemStatus(item models.JobItem) string {
+	if item.Status == models.JobItemFailed {
+		return models.JobItemFailed
+	}
+
+	return models.JobItemPending
+}
+
+// createdJob is the job returned by CreateJob: its items are counted and left out.
+func createdJob(job models.Job) models.Job {
+	failed := 0
+
+	for _, item := range job.Items {
+		if newJobItemStatus(item) == models.JobItemFailed {
+			failed++
+		}
+	}
+
+	job.Total, job.Done, job.Failed, job.Items = len(job.Items), 0, failed, nil
+
+	return job
+}
+
+// countJobItems sums up the items of the job.
+func countJobItems(job *models.Job) {
+	job.Total, job.Done, job.Failed = len(job.Items), 0, 0
+
+	for _, item := range job.Items {
+		switch item.Status {
+		case models.JobItemDone:
+			job.Done++
+		case models.JobItemFailed:
+			job.Failed++
+		}
+	}
+}
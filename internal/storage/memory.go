@@ -0,0 +1,938 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/phonetic"
+)
+
+// MemoryScheme is the DSN scheme that selects Memory.
+const MemoryScheme = "memory://"
+
+// Memory keeps users in memory with the semantics of Postgres, so that the service can run
+// without a database. Strings are ordered byte-wise, i.e. as with the "C" collation.
+type Memory struct {
+	mu            sync.RWMutex
+	users         map[string]models.ResponseEnrich
+	history       []models.HistoryRecord
+	lastHistoryID int64
+	jobs          map[string]*memoryJob
+	// jobIDs are in the order of submission.
+	jobIDs []string
+	// refreshedAt is when the users were last refreshed by their IDs.
+	refreshedAt map[string]time.Time
+	refreshRuns []models.RefreshRun
+}
+
+// memoryJob is a job with the claim and retry times of its items.
+type memoryJob struct {
+	job       models.Job
+	claimedAt []time.Time
+	notBefore []time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:       make(map[string]models.ResponseEnrich),
+		jobs:        make(map[string]*memoryJob),
+		refreshedAt: make(map[string]time.Time),
+	}
+}
+
+// SaveUser inserts the user or, if a user with the same identity exists, overwrites its enrichment
+// keeping the stored ID. It returns the user as stored and records the change in the history.
+func (m *Memory) SaveUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	currentUser, ok := m.findByIdentity(user.RequestEnrich, "")
+	if !ok {
+		savedUser := cloneUser(user)
+		savedUser.CreatedAt, savedUser.UpdatedAt, savedUser.DeletedAt = now, now, nil
+		m.users[savedUser.ID] = savedUser
+		m.insertHistory(ctx, models.OperationCreate, nil, &savedUser, now)
+
+		return cloneUser(savedUser), nil
+	}
+
+	user = keepLocked(currentUser, cloneUser(user))
+	savedUser := cloneUser(currentUser)
+	savedUser.Age, savedUser.Gender, savedUser.Country = user.Age, user.Gender, user.Country
+	savedUser.Countries = cloneUser(user).Countries
+	savedUser.Attributes, savedUser.Provenance = user.Attributes, user.Provenance
+	savedUser.UpdatedAt = now
+	m.users[savedUser.ID] = savedUser
+	m.insertHistory(ctx, models.OperationUpdate, &currentUser, &savedUser, now)
+
+	return cloneUser(savedUser), nil
+}
+
+// ImportUsers saves the users like Postgres.ImportUsers, all at once.
+func (m *Memory) ImportUsers(ctx context.Context, users []models.ResponseEnrich) ([]models.ImportedUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	imported := make([]models.ImportedUser, 0, len(users))
+
+	for _, user := range users {
+		currentUser, ok := m.findByIdentity(user.RequestEnrich, "")
+		if !ok {
+			savedUser := cloneUser(user)
+			savedUser.CreatedAt, savedUser.UpdatedAt, savedUser.DeletedAt = now, now, nil
+			m.users[savedUser.ID] = savedUser
+			m.insertHistory(ctx, models.OperationCreate, nil, &savedUser, now)
+
+			imported = append(imported, models.ImportedUser{User: cloneUser(savedUser), Operation: models.OperationCreate})
+
+			continue
+		}
+
+		savedUser := mergeImported(cloneUser(currentUser), cloneUser(user))
+		savedUser.UpdatedAt = now
+		m.users[savedUser.ID] = savedUser
+		m.insertHistory(ctx, models.OperationUpdate, &currentUser, &savedUser, now)
+
+		imported = append(imported, models.ImportedUser{User: cloneUser(savedUser), Operation: models.OperationUpdate})
+	}
+
+	return imported, nil
+}
+
+func (m *Memory) GetUser(_ context.Context, userID string) (models.ResponseEnrich, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return models.ResponseEnrich{}, ErrUserNotFound
+	}
+
+	return cloneUser(user), nil
+}
+
+func (m *Memory) GetUserByIdentity(_ context.Context, userName models.RequestEnrich) (models.ResponseEnrich, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.findByIdentity(userName, "")
+	if !ok {
+		return models.ResponseEnrich{}, ErrUserNotFound
+	}
+
+	return cloneUser(user), nil
+}
+
+func (m *Memory) FindUsersByName(_ context.Context, userName string) ([]models.ResponseEnrich, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := m.liveUsers(func(user models.ResponseEnrich) bool {
+		return normalize(user.Name) == normalize(userName)
+	})
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	sortUsers(users, []sortKey{{surname, false}, {patronymic, false}, {id, false}})
+
+	return users, nil
+}
+
+// FindSimilarUsers returns the users whose names sound like the name, including the name itself.
+func (m *Memory) FindSimilarUsers(_ context.Context, userName string) ([]models.ResponseEnrich, error) {
+	primary, alternate := phonetic.Keys(userName)
+	if primary == "" {
+		return []models.ResponseEnrich{}, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := m.liveUsers(func(user models.ResponseEnrich) bool {
+		userPrimary, userAlternate := phonetic.Keys(user.Name)
+
+		return userPrimary == primary || userPrimary == alternate ||
+			userAlternate == primary || userAlternate == alternate
+	})
+
+	sortUsers(users, []sortKey{{name, false}, {surname, false}, {patronymic, false}, {id, false}})
+
+	if len(users) > similarUsersLimit {
+		users = users[:similarUsersLimit]
+	}
+
+	return users, nil
+}
+
+// GetUsersList returns a page of the filtered users. The page continues from params.Cursor when it is
+// set and from params.Offset otherwise.
+func (m *Memory) GetUsersList(_ context.Context, params models.ListingQueryParams) (models.UsersPage, error) {
+	if err := checkPage(params); err != nil {
+		return models.UsersPage{}, fmt.Errorf("checkPage(params): %w", err)
+	}
+
+	keys, err := sortKeys(params)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("sortKeys: %w", err)
+	}
+
+	var (
+		values   []interface{}
+		backward bool
+	)
+
+	if params.Cursor != "" {
+		values, backward, err = decodeCursor(params.Cursor, keys)
+		if err != nil {
+			return models.UsersPage{}, fmt.Errorf("decodeCursor: %w", err)
+		}
+	}
+
+	filtered, err := m.filterUsers(params)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("m.filterUsers(params): %w", err)
+	}
+
+	users := make([]models.ResponseEnrich, 0, len(filtered))
+
+	for _, user := range filtered {
+		if values == nil || afterCursor(user, keys, values, backward) {
+			users = append(users, user)
+		}
+	}
+
+	order := keys
+	if backward {
+		order = reversed(keys)
+	}
+
+	sortUsers(users, order)
+
+	if values == nil {
+		users = users[min(params.Offset, len(users)):]
+	}
+
+	users = users[:min(params.ItemsPerPage+1, len(users))]
+
+	page, err := newUsersPage(users, keys, values != nil, backward, params)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("newUsersPage: %w", err)
+	}
+
+	page.Total = int64(len(filtered))
+
+	return page, nil
+}
+
+// ExportUsers calls fn for every user matching the filters of params in their order.
+func (m *Memory) ExportUsers(_ context.Context, params models.ListingQueryParams,
+	fn func(user models.ResponseEnrich) error,
+) error {
+	keys, err := sortKeys(params)
+	if err != nil {
+		return fmt.Errorf("sortKeys: %w", err)
+	}
+
+	users, err := m.filterUsers(params)
+	if err != nil {
+		return fmt.Errorf("m.filterUsers(params): %w", err)
+	}
+
+	sortUsers(users, keys)
+
+	for _, user := range users {
+		if err = fn(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldUser, ok := m.users[user.ID]
+	if !ok || oldUser.DeletedAt != nil {
+		return models.ResponseEnrich{}, ErrUserNotFound
+	}
+
+	identity := models.RequestEnrich{Name: oldUser.Name, Surname: user.Surname, Patronymic: user.Patronymic}
+	if _, ok := m.findByIdentity(identity, user.ID); ok {
+		return models.ResponseEnrich{}, ErrUserExists
+	}
+
+	now := m.now()
+
+	updatedUser := cloneUser(oldUser)
+	updatedUser.Surname, updatedUser.Patronymic = user.Surname, user.Patronymic
+	updatedUser.Age, updatedUser.Gender, updatedUser.Country = user.Age, user.Gender, user.Country
+	updatedUser.Countries = cloneUser(user).Countries
+	updatedUser.Attributes, updatedUser.Provenance = cloneUser(user).Attributes, cloneUser(user).Provenance
+	updatedUser.UpdatedAt = now
+	m.users[user.ID] = updatedUser
+	m.insertHistory(ctx, models.OperationUpdate, &oldUser, &updatedUser, now)
+
+	return cloneUser(updatedUser), nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	now := m.now()
+
+	user = cloneUser(user)
+	user.DeletedAt = &now
+	m.users[userID] = user
+	m.insertHistory(ctx, models.OperationDelete, &user, nil, now)
+
+	return nil
+}
+
+// RestoreUser undoes the deletion of the user.
+func (m *Memory) RestoreUser(ctx context.Context, userID string) (models.ResponseEnrich, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldUser, ok := m.users[userID]
+	if !ok || oldUser.DeletedAt == nil {
+		return models.ResponseEnrich{}, ErrUserNotFound
+	}
+
+	if _, ok := m.findByIdentity(oldUser.RequestEnrich, userID); ok {
+		return models.ResponseEnrich{}, ErrUserExists
+	}
+
+	now := m.now()
+
+	restoredUser := cloneUser(oldUser)
+	restoredUser.DeletedAt, restoredUser.UpdatedAt = nil, now
+	m.users[userID] = restoredUser
+	m.insertHistory(ctx, models.OperationRestore, &oldUser, &restoredUser, now)
+
+	return cloneUser(restoredUser), nil
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before the time and returns their number.
+func (m *Memory) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	var purged int64
+
+	for userID, user := range m.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(deletedBefore) {
+			delete(m.users, userID)
+			delete(m.refreshedAt, userID)
+			m.insertHistory(ctx, models.OperationPurge, &user, nil, now)
+
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// GetUserHistory returns the changes of the user with the ID or of every user with the name,
+// including deleted ones, oldest first.
+func (m *Memory) GetUserHistory(_ context.Context, userRef string) ([]models.HistoryRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history := make([]models.HistoryRecord, 0)
+
+	for _, record := range m.history {
+		if record.UserID == userRef || normalize(record.Name) == normalize(userRef) {
+			history = append(history, cloneHistoryRecord(record))
+		}
+	}
+
+	return history, nil
+}
+
+// CreateJob saves the queued job with its items. The job is returned without the items.
+func (m *Memory) CreateJob(_ context.Context, job models.Job) (models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	job.Status, job.CreatedAt, job.UpdatedAt, job.FinishedAt = models.JobCompleted, now, now, &now
+	job.Header = append([]string(nil), job.Header...)
+
+	items := make([]models.JobItem, len(job.Items))
+	for i, item := range job.Items {
+		items[i] = cloneJobItem(models.JobItem{JobID: job.ID, Position: item.Position, Request: item.Request,
+			Status: newJobItemStatus(item), Error: item.Error, Cells: item.Cells})
+
+		if items[i].Status == models.JobItemPending {
+			job.Status, job.FinishedAt = models.JobQueued, nil
+		}
+	}
+
+	stored := job
+	stored.Items = items
+	m.jobs[job.ID] = &memoryJob{
+		job:       stored,
+		claimedAt: make([]time.Time, len(items)),
+		notBefore: make([]time.Time, len(items)),
+	}
+	m.jobIDs = append(m.jobIDs, job.ID)
+
+	return createdJob(job), nil
+}
+
+// GetJob returns the job with its items in the order they were submitted.
+func (m *Memory) GetJob(_ context.Context, jobID string) (models.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.jobs[jobID]
+	if !ok {
+		return models.Job{}, ErrJobNotFound
+	}
+
+	job := stored.job
+	job.Header = append([]string(nil), stored.job.Header...)
+	job.Items = make([]models.JobItem, len(stored.job.Items))
+
+	for i, item := range stored.job.Items {
+		job.Items[i] = cloneJobItem(item)
+	}
+
+	if job.FinishedAt != nil {
+		finishedAt := *job.FinishedAt
+		job.FinishedAt = &finishedAt
+	}
+
+	countJobItems(&job)
+
+	return job, nil
+}
+
+// ClaimJobItems marks up to limit items as running and returns them, oldest job first. Items claimed before
+// staleBefore are taken over, as their worker is considered gone.
+func (m *Memory) ClaimJobItems(_ context.Context, limit int, staleBefore time.Time) ([]models.JobItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	claimed := make([]models.JobItem, 0, limit)
+
+	for _, jobID := range m.jobIDs {
+		stored := m.jobs[jobID]
+
+		for i := range stored.job.Items {
+			if len(claimed) == limit {
+				return claimed, nil
+			}
+
+			item := &stored.job.Items[i]
+
+			due := item.Status == models.JobItemPending && !stored.notBefore[i].After(now)
+			stale := item.Status == models.JobItemRunning && stored.claimedAt[i].Before(staleBefore)
+
+			if !due && !stale {
+				continue
+			}
+
+			item.Status = models.JobItemRunning
+			item.Attempts++
+			stored.claimedAt[i] = now
+
+			if stored.job.Status == models.JobQueued {
+				stored.job.Status, stored.job.UpdatedAt = models.JobRunning, now
+			}
+
+			claimedItem := cloneJobItem(*item)
+			claimedItem.Actor, claimedItem.RequestID = stored.job.Actor, stored.job.RequestID
+			claimed = append(claimed, claimedItem)
+		}
+	}
+
+	return claimed, nil
+}
+
+// FinishJobItem saves the status, attempts and result of the claimed item and completes its job once no item
+// is left to enrich. A pending item is given back to the workers, not before its NotBefore if it is set.
+func (m *Memory) FinishJobItem(_ context.Context, item models.JobItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.jobs[item.JobID]
+	if !ok || item.Position < 0 || item.Position >= len(stored.job.Items) {
+		return ErrJobNotFound
+	}
+
+	now := m.now()
+
+	storedItem := &stored.job.Items[item.Position]
+	storedItem.Status, storedItem.Attempts, storedItem.Error = item.Status, item.Attempts, item.Error
+	storedItem.User = cloneJobItem(item).User
+	stored.claimedAt[item.Position] = time.Time{}
+	stored.notBefore[item.Position] = time.Time{}
+	stored.job.UpdatedAt = now
+
+	if item.NotBefore != nil {
+		stored.notBefore[item.Position] = *item.NotBefore
+	}
+
+	for _, jobItem := range stored.job.Items {
+		if jobItem.Status == models.JobItemPending || jobItem.Status == models.JobItemRunning {
+			return nil
+		}
+	}
+
+	stored.job.Status, stored.job.FinishedAt = models.JobCompleted, &now
+
+	return nil
+}
+
+// GetStaleUsers returns up to limit live users last refreshed, or created if never refreshed, before
+// refreshedBefore, the stalest first.
+func (m *Memory) GetStaleUsers(_ context.Context, refreshedBefore time.Time, limit int) (
+	[]models.ResponseEnrich, error,
+) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := m.liveUsers(func(user models.ResponseEnrich) bool {
+		return m.refreshTime(user).Before(refreshedBefore)
+	})
+
+	sort.Slice(users, func(i, j int) bool {
+		ti, tj := m.refreshTime(users[i]), m.refreshTime(users[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+
+		return users[i].ID < users[j].ID
+	})
+
+	if len(users) > limit {
+		users = users[:limit]
+	}
+
+	return users, nil
+}
+
+// RefreshUser updates the user like Postgres.RefreshUser.
+func (m *Memory) RefreshUser(ctx context.Context, refreshed models.ResponseEnrich) ([]models.RefreshChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldUser, ok := m.users[refreshed.ID]
+	if !ok || oldUser.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	now := m.now()
+	m.refreshedAt[oldUser.ID] = now
+
+	refreshedUser, changes := mergeRefreshed(cloneUser(oldUser), cloneUser(refreshed))
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	refreshedUser.UpdatedAt = now
+	m.users[refreshedUser.ID] = refreshedUser
+	m.insertHistory(ctx, models.OperationUpdate, &oldUser, &refreshedUser, now)
+
+	return changes, nil
+}
+
+func (m *Memory) SaveRefreshRun(_ context.Context, run models.RefreshRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run.Changes = append(refreshChangesOrEmpty(nil), run.Changes...)
+	m.refreshRuns = append(m.refreshRuns, run)
+
+	return nil
+}
+
+// GetRefreshRuns returns up to limit runs without their changes, the latest first.
+func (m *Memory) GetRefreshRuns(_ context.Context, limit int) ([]models.RefreshRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	runs := make([]models.RefreshRun, 0, len(m.refreshRuns))
+
+	for _, run := range m.refreshRuns {
+		run.Changes = nil
+		runs = append(runs, run)
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		if !runs[i].StartedAt.Equal(runs[j].StartedAt) {
+			return runs[i].StartedAt.After(runs[j].StartedAt)
+		}
+
+		return runs[i].ID < runs[j].ID
+	})
+
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+
+	return runs, nil
+}
+
+func (m *Memory) GetRefreshRun(_ context.Context, runID string) (models.RefreshRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, run := range m.refreshRuns {
+		if run.ID == runID {
+			run.Changes = append(refreshChangesOrEmpty(nil), run.Changes...)
+
+			return run, nil
+		}
+	}
+
+	return models.RefreshRun{}, ErrRefreshRunNotFound
+}
+
+// filterUsers returns copies of the users matching the filters of params with their search score.
+func (m *Memory) filterUsers(params models.ListingQueryParams) ([]models.ResponseEnrich, error) {
+	filter, err := newListingFilter(params)
+	if err != nil {
+		return nil, fmt.Errorf("newListingFilter(params): %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	filtered := make([]models.ResponseEnrich, 0)
+
+	for _, user := range m.users {
+		if user, ok := filter.match(cloneUser(user)); ok {
+			filtered = append(filtered, user)
+		}
+	}
+
+	return filtered, nil
+}
+
+// findByIdentity returns the live user with the normalized name, surname and patronymic other
+// than the user with exceptID.
+func (m *Memory) findByIdentity(userName models.RequestEnrich, exceptID string) (models.ResponseEnrich, bool) {
+	for _, user := range m.users {
+		if user.DeletedAt == nil && user.ID != exceptID &&
+			normalize(user.Name) == normalize(userName.Name) &&
+			normalize(user.Surname) == normalize(userName.Surname) &&
+			normalize(user.Patronymic) == normalize(userName.Patronymic) {
+			return user, true
+		}
+	}
+
+	return models.ResponseEnrich{}, false
+}
+
+func (m *Memory) liveUsers(match func(user models.ResponseEnrich) bool) []models.ResponseEnrich {
+	users := make([]models.ResponseEnrich, 0)
+
+	for _, user := range m.users {
+		if user.DeletedAt == nil && match(user) {
+			users = append(users, cloneUser(user))
+		}
+	}
+
+	return users
+}
+
+// refreshTime is the in-memory counterpart of coalesce(refreshed_at, created_at).
+func (m *Memory) refreshTime(user models.ResponseEnrich) time.Time {
+	if refreshedAt, ok := m.refreshedAt[user.ID]; ok {
+		return refreshedAt
+	}
+
+	return user.CreatedAt
+}
+
+func (m *Memory) insertHistory(ctx context.Context, operation string, oldUser, newUser *models.ResponseEnrich,
+	now time.Time,
+) {
+	user := newUser
+	if user == nil {
+		user = oldUser
+	}
+
+	meta := audit.FromContext(ctx)
+	m.lastHistoryID++
+
+	record := models.HistoryRecord{
+		ID:        m.lastHistoryID,
+		UserID:    user.ID,
+		Name:      user.Name,
+		Operation: operation,
+		OldValue:  oldUser,
+		NewValue:  newUser,
+		Actor:     meta.Actor,
+		RequestID: meta.RequestID,
+		CreatedAt: now,
+	}
+
+	m.history = append(m.history, cloneHistoryRecord(record))
+}
+
+// now is truncated to the precision of Postgres timestamps.
+func (*Memory) now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// listingFilter is the in-memory counterpart of buildQueryAndArgs.
+type listingFilter struct {
+	params     models.ListingQueryParams
+	textFilter *likePattern
+	surname    *likePattern
+}
+
+func newListingFilter(params models.ListingQueryParams) (listingFilter, error) {
+	filter := listingFilter{params: params}
+
+	var err error
+
+	if params.TextFilter != "" {
+		if filter.textFilter, err = newLikePattern("%" + params.TextFilter + "%"); err != nil {
+			return listingFilter{}, fmt.Errorf("newLikePattern(textFilter): %w", err)
+		}
+	}
+
+	if params.SurnamePrefix != "" {
+		if filter.surname, err = newLikePattern(escapeLike(params.SurnamePrefix) + "%"); err != nil {
+			return listingFilter{}, fmt.Errorf("newLikePattern(surnamePrefix): %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// match tells whether the user passes the filters and returns it with the search score.
+func (f listingFilter) match(user models.ResponseEnrich) (models.ResponseEnrich, bool) {
+	params := f.params
+
+	if user.DeletedAt != nil && !params.IncludeDeleted {
+		return user, false
+	}
+
+	if params.Query != "" {
+		user.Score = wordSimilarity(params.Query, user.Name+" "+user.Surname+" "+user.Patronymic)
+		if user.Score < wordSimilarityThreshold {
+			return user, false
+		}
+	}
+
+	if f.textFilter != nil && !f.textFilter.matchAny(user.Name, user.Surname, user.Patronymic, user.Gender,
+		user.Country) {
+		return user, false
+	}
+
+	for attrName, value := range params.Attributes {
+		attr, ok := user.Attributes[attrName]
+		if !ok || attributeText(attr) != value {
+			return user, false
+		}
+	}
+
+	timeRanges := []struct {
+		value, after, before time.Time
+	}{
+		{user.CreatedAt, params.CreatedAfter, params.CreatedBefore},
+		{user.UpdatedAt, params.UpdatedAfter, params.UpdatedBefore},
+	}
+
+	for _, r := range timeRanges {
+		if (!r.after.IsZero() && r.value.Before(r.after)) || (!r.before.IsZero() && !r.value.Before(r.before)) {
+			return user, false
+		}
+	}
+
+	if params.ManualOverride != "" && !user.Provenance[params.ManualOverride].Manual {
+		return user, false
+	}
+
+	if (params.AgeMin != nil && user.Age < *params.AgeMin) || (params.AgeMax != nil && user.Age > *params.AgeMax) {
+		return user, false
+	}
+
+	if params.Gender != "" && user.Gender != params.Gender {
+		return user, false
+	}
+
+	if len(params.Countries) > 0 && !contains(params.Countries, user.Country) {
+		return user, false
+	}
+
+	if f.surname != nil && !f.surname.matchAny(user.Surname) {
+		return user, false
+	}
+
+	return user, true
+}
+
+// afterCursor tells whether the user comes after the cursor values in the order of the keys, or
+// before them for a backward cursor.
+func afterCursor(user models.ResponseEnrich, keys []sortKey, values []interface{}, backward bool) bool {
+	for i, key := range keys {
+		c := compareValues(sortColumns[key.column].value(user), values[i])
+		if key.desc {
+			c = -c
+		}
+
+		if c != 0 {
+			return (c > 0) != backward
+		}
+	}
+
+	return false
+}
+
+func sortUsers(users []models.ResponseEnrich, keys []sortKey) {
+	sort.SliceStable(users, func(i, j int) bool {
+		for _, key := range keys {
+			c := compareValues(sortColumns[key.column].value(users[i]), sortColumns[key.column].value(users[j]))
+			if c != 0 {
+				return (c < 0) != key.desc
+			}
+		}
+
+		return false
+	})
+}
+
+func reversed(keys []sortKey) []sortKey {
+	order := make([]sortKey, 0, len(keys))
+
+	for _, key := range keys {
+		order = append(order, sortKey{key.column, !key.desc})
+	}
+
+	return order
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int:
+		return a - b.(int)
+	case float32:
+		switch b := b.(float32); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+
+	return 0
+}
+
+// attributeText is the value of the attribute as returned by the ->> operator of jsonb.
+func attributeText(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+
+	return string(raw)
+}
+
+// normalize is the in-memory counterpart of lower(btrim(value)).
+func normalize(value string) string {
+	return strings.ToLower(strings.Trim(value, " "))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cloneUser copies the maps and pointers of the user so that the stored user cannot be changed
+// through the returned one. Request-only fields are not stored.
+func cloneUser(user models.ResponseEnrich) models.ResponseEnrich {
+	user.FullName, user.ParseConfidence, user.Score, user.Locks = "", 0, 0, nil
+
+	if user.Countries != nil {
+		user.Countries = append([]models.CountryScore{}, user.Countries...)
+	}
+
+	attributes := make(map[string]interface{}, len(user.Attributes))
+	for attrName, value := range user.Attributes {
+		attributes[attrName] = value
+	}
+
+	provenance := make(map[string]models.Provenance, len(user.Provenance))
+	for field, p := range user.Provenance {
+		provenance[field] = p
+	}
+
+	user.Attributes, user.Provenance = attributes, provenance
+
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		user.DeletedAt = &deletedAt
+	}
+
+	return user
+}
+
+// cloneJobItem copies the cells and the result of the item, which keeps the request-only fields of the
+// enrichment response.
+func cloneJobItem(item models.JobItem) models.JobItem {
+	if item.User != nil {
+		user := cloneUser(*item.User)
+		user.FullName, user.ParseConfidence = item.User.FullName, item.User.ParseConfidence
+		item.User = &user
+	}
+
+	item.Cells = append([]string(nil), item.Cells...)
+
+	return item
+}
+
+func cloneHistoryRecord(record models.HistoryRecord) models.HistoryRecord {
+	if record.OldValue != nil {
+		oldValue := cloneUser(*record.OldValue)
+		record.OldValue = &oldValue
+	}
+
+	if record.NewValue != nil {
+		newValue := cloneUser(*record.NewValue)
+		record.NewValue = &newValue
+	}
+
+	return record
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+var ErrSchemaBehind = errors.New("schema is behind the service")
+
+// MigrationStatus tells whether and when a migration was applied.
+type MigrationStatus struct {
+	ID        string
+	AppliedAt *time.Time
+}
+
+// CheckSchema returns ErrSchemaBehind if some of the migrations were not applied.
+func CheckSchema(statuses []MigrationStatus) error {
+	pending := make([]string, 0)
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.ID)
+		}
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending migrations %s", ErrSchemaBehind, strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+// migrationSource reads the migrations embedded into fsys under dir.
+func migrationSource(fsys embed.FS, dir string) migrate.AssetMigrationSource {
+	return migrate.AssetMigrationSource{
+		Asset: fsys.ReadFile,
+		AssetDir: func(path string) ([]string, error) {
+			dirEntry, err := fsys.ReadDir(path)
+			if err != nil {
+				return nil, fmt.Errorf("fsys.ReadDir(path): %w", err)
+			}
+
+			entries := make([]string, 0)
+
+			for _, e := range dirEntry {
+				entries = append(entries, e.Name())
+			}
+
+			return entries, nil
+		},
+		Dir: dir,
+	}
+}
+
+func execMigrations(db *sql.DB, dialect string, source migrate.MigrationSource,
+	direction migrate.MigrationDirection, max int,
+) (int, error) {
+	applied, err := migrate.ExecMax(db, dialect, source, direction, max)
+	if err != nil {
+		return 0, fmt.Errorf("migrate.ExecMax(db, dialect, source, direction, max): %w", err)
+	}
+
+	return applied, nil
+}
+
+// migrationStatus lists the migrations of the source in order, followed by the applied migrations
+// the source does not have.
+func migrationStatus(db *sql.DB, dialect string, source migrate.MigrationSource) ([]MigrationStatus, error) {
+	known, err := source.FindMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("source.FindMigrations(): %w", err)
+	}
+
+	records, err := migrate.GetMigrationRecords(db, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("migrate.GetMigrationRecords(db, dialect): %w", err)
+	}
+
+	appliedAt := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		appliedAt[record.Id] = record.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(known))
+
+	for _, m := range known {
+		status := MigrationStatus{ID: m.Id}
+
+		if t, ok := appliedAt[m.Id]; ok {
+			status.AppliedAt = &t
+
+			delete(appliedAt, m.Id)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	for _, record := range records {
+		if t, ok := appliedAt[record.Id]; ok {
+			statuses = append(statuses, MigrationStatus{ID: record.Id, AppliedAt: &t})
+		}
+	}
+
+	return statuses, nil
+}
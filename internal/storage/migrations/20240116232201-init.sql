@@ -6,4 +6,7 @@ CREATE TABLE enriched_user (
     age INT NOT NULL,
     gender VARCHAR NOT NULL,
     country VARCHAR NOT NULL
-);
\ No newline at end of file
+);
+
+-- +migrate Down
+DROP TABLE enriched_user;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE enriched_user ADD COLUMN countries JSONB;
+
+-- +migrate Down
+ALTER TABLE enriched_user DROP COLUMN countries;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE enriched_user ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+
+-- +migrate Down
+ALTER TABLE enriched_user DROP COLUMN attributes;
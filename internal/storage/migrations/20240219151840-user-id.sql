@@ -0,0 +1,9 @@
+-- +migrate Up
+-- A volatile default is evaluated per row, so existing users get their own IDs.
+ALTER TABLE enriched_user ADD COLUMN id UUID NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE enriched_user ADD PRIMARY KEY (id);
+CREATE INDEX enriched_user_name_idx ON enriched_user (name);
+
+-- +migrate Down
+DROP INDEX enriched_user_name_idx;
+ALTER TABLE enriched_user DROP COLUMN id;
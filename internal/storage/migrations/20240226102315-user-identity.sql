@@ -0,0 +1,17 @@
+-- +migrate Up
+DELETE FROM enriched_user a
+USING enriched_user b
+WHERE a.id > b.id
+    AND lower(btrim(a.name)) = lower(btrim(b.name))
+    AND lower(btrim(a.surname)) = lower(btrim(b.surname))
+    AND lower(btrim(coalesce(a.patronymic, ''))) = lower(btrim(coalesce(b.patronymic, '')));
+
+CREATE UNIQUE INDEX enriched_user_identity_idx ON enriched_user (
+    lower(btrim(name)),
+    lower(btrim(surname)),
+    lower(btrim(coalesce(patronymic, '')))
+);
+
+-- +migrate Down
+-- The duplicates removed by the up migration are not brought back.
+DROP INDEX enriched_user_identity_idx;
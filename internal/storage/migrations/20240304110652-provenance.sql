@@ -0,0 +1,11 @@
+-- +migrate Up
+ALTER TABLE enriched_user
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN provenance JSONB NOT NULL DEFAULT '{}';
+
+-- +migrate Down
+ALTER TABLE enriched_user
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at,
+    DROP COLUMN provenance;
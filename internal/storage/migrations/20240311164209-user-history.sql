@@ -0,0 +1,31 @@
+-- +migrate Up
+CREATE TABLE user_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    name VARCHAR NOT NULL,
+    operation VARCHAR NOT NULL,
+    old_value JSONB,
+    new_value JSONB,
+    actor VARCHAR NOT NULL DEFAULT '',
+    request_id VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_history_user_id_idx ON user_history (user_id);
+CREATE INDEX user_history_name_idx ON user_history (lower(btrim(name)));
+
+-- +migrate StatementBegin
+CREATE FUNCTION user_history_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'user_history is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER user_history_append_only
+    BEFORE UPDATE OR DELETE ON user_history
+    FOR EACH ROW EXECUTE FUNCTION user_history_append_only();
+
+-- +migrate Down
+DROP TABLE user_history;
+DROP FUNCTION user_history_append_only();
@@ -0,0 +1,26 @@
+-- +migrate Up
+ALTER TABLE enriched_user ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- A deleted person may be enriched again, so only live users have to be unique.
+DROP INDEX enriched_user_identity_idx;
+CREATE UNIQUE INDEX enriched_user_identity_idx ON enriched_user (
+    lower(btrim(name)),
+    lower(btrim(surname)),
+    lower(btrim(coalesce(patronymic, '')))
+) WHERE deleted_at IS NULL;
+
+CREATE INDEX enriched_user_deleted_at_idx ON enriched_user (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +migrate Down
+-- Without deleted_at the deleted users would be live again and could break the identity index.
+DELETE FROM enriched_user WHERE deleted_at IS NOT NULL;
+
+DROP INDEX enriched_user_deleted_at_idx;
+DROP INDEX enriched_user_identity_idx;
+CREATE UNIQUE INDEX enriched_user_identity_idx ON enriched_user (
+    lower(btrim(name)),
+    lower(btrim(surname)),
+    lower(btrim(coalesce(patronymic, '')))
+);
+
+ALTER TABLE enriched_user DROP COLUMN deleted_at;
@@ -0,0 +1,13 @@
+-- +migrate Up
+-- Listings are paginated by the sort key followed by the ID, so the indexes end with the ID.
+CREATE INDEX enriched_user_name_id_idx ON enriched_user (name, id) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_created_at_id_idx ON enriched_user (created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_updated_at_id_idx ON enriched_user (updated_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_country_age_name_id_idx ON enriched_user (country, age DESC, name, id)
+    WHERE deleted_at IS NULL;
+
+-- +migrate Down
+DROP INDEX enriched_user_name_id_idx;
+DROP INDEX enriched_user_created_at_id_idx;
+DROP INDEX enriched_user_updated_at_id_idx;
+DROP INDEX enriched_user_country_age_name_id_idx;
@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE enriched_user ADD COLUMN search_text TEXT
+    GENERATED ALWAYS AS (name || ' ' || surname || ' ' || coalesce(patronymic, '')) STORED;
+
+CREATE INDEX enriched_user_search_text_idx ON enriched_user USING gin (search_text gin_trgm_ops)
+    WHERE deleted_at IS NULL;
+
+-- +migrate Down
+-- pg_trgm is left installed, other databases on the server may use it.
+DROP INDEX enriched_user_search_text_idx;
+ALTER TABLE enriched_user DROP COLUMN search_text;
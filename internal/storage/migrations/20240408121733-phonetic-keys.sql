@@ -0,0 +1,14 @@
+-- +migrate Up
+-- Double Metaphone codes of the name are computed by the service; NULL marks users saved before
+-- the codes existed, which are filled in at startup.
+ALTER TABLE enriched_user ADD COLUMN phonetic_primary VARCHAR;
+ALTER TABLE enriched_user ADD COLUMN phonetic_alternate VARCHAR;
+
+CREATE INDEX enriched_user_phonetic_primary_idx ON enriched_user (phonetic_primary) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_phonetic_alternate_idx ON enriched_user (phonetic_alternate) WHERE deleted_at IS NULL;
+
+-- +migrate Down
+DROP INDEX enriched_user_phonetic_primary_idx;
+DROP INDEX enriched_user_phonetic_alternate_idx;
+ALTER TABLE enriched_user DROP COLUMN phonetic_primary;
+ALTER TABLE enriched_user DROP COLUMN phonetic_alternate;
@@ -0,0 +1,31 @@
+-- +migrate Up
+CREATE TABLE enrichment_job (
+    id UUID PRIMARY KEY,
+    status VARCHAR NOT NULL DEFAULT 'queued',
+    actor VARCHAR NOT NULL DEFAULT '',
+    request_id VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE TABLE enrichment_job_item (
+    job_id UUID NOT NULL REFERENCES enrichment_job (id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    request JSONB NOT NULL,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    result JSONB,
+    error VARCHAR NOT NULL DEFAULT '',
+    claimed_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    PRIMARY KEY (job_id, position)
+);
+
+-- Workers look for the items that are not finished yet.
+CREATE INDEX enrichment_job_item_unfinished_idx ON enrichment_job_item (job_id, position)
+    WHERE status IN ('pending', 'running');
+
+-- +migrate Down
+DROP TABLE enrichment_job_item;
+DROP TABLE enrichment_job;
@@ -0,0 +1,6 @@
+-- +migrate Up
+-- not_before is when a pending item that failed may be claimed again.
+ALTER TABLE enrichment_job_item ADD COLUMN not_before TIMESTAMPTZ;
+
+-- +migrate Down
+ALTER TABLE enrichment_job_item DROP COLUMN not_before;
@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE enrichment_job
+    ADD COLUMN file_name VARCHAR NOT NULL DEFAULT '',
+    ADD COLUMN header JSONB;
+
+-- cells are the values of the CSV row of an uploaded job.
+ALTER TABLE enrichment_job_item ADD COLUMN cells JSONB;
+
+-- +migrate Down
+ALTER TABLE enrichment_job_item DROP COLUMN cells;
+
+ALTER TABLE enrichment_job
+    DROP COLUMN header,
+    DROP COLUMN file_name;
@@ -0,0 +1,26 @@
+-- +migrate Up
+-- refreshed_at is when the providers were last requested for the user by a refresh run.
+ALTER TABLE enriched_user ADD COLUMN refreshed_at TIMESTAMPTZ;
+
+CREATE INDEX enriched_user_refreshed_at_id_idx ON enriched_user (coalesce(refreshed_at, created_at), id)
+    WHERE deleted_at IS NULL;
+
+CREATE TABLE refresh_run (
+    id UUID PRIMARY KEY,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    checked INT NOT NULL DEFAULT 0,
+    updated INT NOT NULL DEFAULT 0,
+    failed INT NOT NULL DEFAULT 0,
+    stopped VARCHAR NOT NULL DEFAULT '',
+    changes JSONB NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX refresh_run_started_at_idx ON refresh_run (started_at);
+
+-- +migrate Down
+DROP TABLE refresh_run;
+
+DROP INDEX enriched_user_refreshed_at_id_idx;
+
+ALTER TABLE enriched_user DROP COLUMN refreshed_at;
@@ -0,0 +1,16 @@
+-- +migrate Up
+-- Values set manually before the locks were introduced are locked.
+UPDATE enriched_user AS u
+SET provenance = (
+    SELECT jsonb_object_agg(
+        p.key,
+        CASE WHEN (p.value->>'manual')::boolean THEN p.value || '{"locked": true}' ELSE p.value END
+    )
+    FROM jsonb_each(u.provenance) AS p
+)
+WHERE EXISTS (SELECT 1 FROM jsonb_each(u.provenance) AS p WHERE (p.value->>'manual')::boolean);
+
+-- +migrate Down
+UPDATE enriched_user AS u
+SET provenance = (SELECT jsonb_object_agg(p.key, p.value - 'locked') FROM jsonb_each(u.provenance) AS p)
+WHERE EXISTS (SELECT 1 FROM jsonb_each(u.provenance) AS p WHERE p.value ? 'locked');
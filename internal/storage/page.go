@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+)
+
+// sortColumn is a column users can be ordered and paginated by.
+type sortColumn struct {
+	value  func(user models.ResponseEnrich) interface{}
+	decode func(raw json.RawMessage) (interface{}, error)
+}
+
+var sortColumns = map[string]sortColumn{
+	id:         {func(u models.ResponseEnrich) interface{} { return u.ID }, decodeAs[string]},
+	name:       {func(u models.ResponseEnrich) interface{} { return u.Name }, decodeAs[string]},
+	surname:    {func(u models.ResponseEnrich) interface{} { return u.Surname }, decodeAs[string]},
+	patronymic: {func(u models.ResponseEnrich) interface{} { return u.Patronymic }, decodeAs[string]},
+	age:        {func(u models.ResponseEnrich) interface{} { return u.Age }, decodeAs[int]},
+	gender:     {func(u models.ResponseEnrich) interface{} { return u.Gender }, decodeAs[string]},
+	country:    {func(u models.ResponseEnrich) interface{} { return u.Country }, decodeAs[string]},
+	createdAt:  {func(u models.ResponseEnrich) interface{} { return u.CreatedAt }, decodeAs[time.Time]},
+	updatedAt:  {func(u models.ResponseEnrich) interface{} { return u.UpdatedAt }, decodeAs[time.Time]},
+	score:      {func(u models.ResponseEnrich) interface{} { return u.Score }, decodeAs[float32]},
+}
+
+func decodeAs[T any](raw json.RawMessage) (interface{}, error) {
+	var value T
+
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return value, nil
+}
+
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// checkPage rejects a page size below one and a negative offset, which every store reports the same way.
+func checkPage(params models.ListingQueryParams) error {
+	if params.ItemsPerPage < 1 {
+		return fmt.Errorf("%w: items per page %d is less than one", models.ErrPageNotValid, params.ItemsPerPage)
+	}
+
+	if params.Offset < 0 {
+		return fmt.Errorf("%w: offset %d is negative", models.ErrPageNotValid, params.Offset)
+	}
+
+	return nil
+}
+
+// sortKeys checks the requested order against the sortable columns and appends the ID in the
+// direction of the last key, which makes the order total so that a cursor points between two rows.
+// Search results are ordered by relevance by default.
+func sortKeys(params models.ListingQueryParams) ([]sortKey, error) {
+	if len(params.Sort) == 0 && params.Query != "" {
+		return []sortKey{{score, true}, {id, true}}, nil
+	}
+
+	if len(params.Sort) == 0 {
+		return []sortKey{{name, false}, {id, false}}, nil
+	}
+
+	keys := make([]sortKey, 0, len(params.Sort)+1)
+	seen := make(map[string]struct{}, len(params.Sort))
+
+	for _, s := range params.Sort {
+		if _, ok := sortColumns[s.Field]; !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", models.ErrSortNotValid, s.Field)
+		}
+
+		if _, ok := seen[s.Field]; ok {
+			return nil, fmt.Errorf("%w: field %q is repeated", models.ErrSortNotValid, s.Field)
+		}
+
+		seen[s.Field] = struct{}{}
+		keys = append(keys, sortKey{s.Field, s.Descending})
+	}
+
+	if _, ok := seen[id]; !ok {
+		keys = append(keys, sortKey{id, keys[len(keys)-1].desc})
+	}
+
+	return keys, nil
+}
+
+// sortSpec describes the order a cursor was made for, e.g. "-age,-id".
+func sortSpec(keys []sortKey) string {
+	spec := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		if key.desc {
+			spec = append(spec, "-"+key.column)
+		} else {
+			spec = append(spec, key.column)
+		}
+	}
+
+	return strings.Join(spec, ",")
+}
+
+// cursor holds the sort key values of the row the page starts after. Backward cursors read the page
+// before the row.
+type cursor struct {
+	Sort     string            `json:"s"`
+	Backward bool              `json:"b,omitempty"`
+	Values   []json.RawMessage `json:"v"`
+}
+
+func encodeCursor(user models.ResponseEnrich, keys []sortKey, backward bool) (string, error) {
+	c := cursor{Sort: sortSpec(keys), Backward: backward, Values: make([]json.RawMessage, 0, len(keys))}
+
+	for _, key := range keys {
+		raw, err := json.Marshal(sortColumns[key.column].value(user))
+		if err != nil {
+			return "", fmt.Errorf("json.Marshal: %w", err)
+		}
+
+		c.Values = append(c.Values, raw)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal(c): %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// keyValues returns the sort key values of the user, the same ones a cursor made at the user holds.
+func keyValues(user models.ResponseEnrich, keys []sortKey) []interface{} {
+	values := make([]interface{}, 0, len(keys))
+
+	for _, key := range keys {
+		values = append(values, sortColumns[key.column].value(user))
+	}
+
+	return values
+}
+
+// decodeCursor returns the sort key values of the cursor; a cursor made for another order is not valid.
+func decodeCursor(encoded string, keys []sortKey) (values []interface{}, backward bool, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", models.ErrCursorNotValid, err)
+	}
+
+	var c cursor
+
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, false, fmt.Errorf("%w: %w", models.ErrCursorNotValid, err)
+	}
+
+	if c.Sort != sortSpec(keys) || len(c.Values) != len(keys) {
+		return nil, false, fmt.Errorf("%w: made for order %q", models.ErrCursorNotValid, c.Sort)
+	}
+
+	values = make([]interface{}, 0, len(keys))
+
+	for i, key := range keys {
+		value, err := sortColumns[key.column].decode(c.Values[i])
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %s: %w", models.ErrCursorNotValid, key.column, err)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, c.Backward, nil
+}
+
+// buildPageQuery orders the filtered query and limits it to one page. It reads one row more than the
+// page size to find out whether there is a next page. Backward pages are read in the reverse order.
+func buildPageQuery(query string, args []interface{}, keys []sortKey, values []interface{}, backward bool,
+	params models.ListingQueryParams,
+) (string, []interface{}) {
+	if values != nil {
+		first := len(args) + 1
+		args = append(args, values...)
+
+		conditions := make([]string, 0, len(keys))
+
+		for i, key := range keys {
+			terms := make([]string, 0, i+1)
+			for j := 0; j < i; j++ {
+				terms = append(terms, fmt.Sprintf(`%s = $%d`, keys[j].column, first+j))
+			}
+
+			op := ">"
+			if key.desc != backward {
+				op = "<"
+			}
+
+			terms = append(terms, fmt.Sprintf(`%s %s $%d`, key.column, op, first+i))
+			conditions = append(conditions, "("+strings.Join(terms, " AND ")+")")
+		}
+
+		query += ` AND (` + strings.Join(conditions, " OR ") + `)`
+	}
+
+	query += orderBy(keys, backward)
+
+	args = append(args, params.ItemsPerPage+1)
+	query += fmt.Sprintf(` LIMIT $%d`, len(args))
+
+	if values == nil {
+		args = append(args, params.Offset)
+		query += fmt.Sprintf(` OFFSET $%d`, len(args))
+	}
+
+	return query, args
+}
+
+// orderBy orders by the keys, or in the reverse order for backward pages.
+func orderBy(keys []sortKey, backward bool) string {
+	order := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		if key.desc != backward {
+			order = append(order, key.column+` DESC`)
+		} else {
+			order = append(order, key.column)
+		}
+	}
+
+	return ` ORDER BY ` + strings.Join(order, ", ")
+}
+
+// newUsersPage trims the extra row read by buildPageQuery and makes the cursors of the neighbouring pages.
+func newUsersPage(users []models.ResponseEnrich, keys []sortKey, fromCursor, backward bool,
+	params models.ListingQueryParams,
+) (models.UsersPage, error) {
+	hasMore := len(users) > params.ItemsPerPage
+	if hasMore {
+		users = users[:params.ItemsPerPage]
+	}
+
+	if backward {
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+	}
+
+	page := models.UsersPage{Items: users}
+
+	if len(users) == 0 {
+		return page, nil
+	}
+
+	hasNext, hasPrev := hasMore, fromCursor || params.Offset > 0
+	if backward {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	var err error
+
+	if hasNext {
+		page.NextCursor, err = encodeCursor(users[len(users)-1], keys, false)
+		if err != nil {
+			return models.UsersPage{}, fmt.Errorf("encodeCursor: %w", err)
+		}
+	}
+
+	if hasPrev {
+		page.PrevCursor, err = encodeCursor(users[0], keys, true)
+		if err != nil {
+			return models.UsersPage{}, fmt.Errorf("encodeCursor: %w", err)
+		}
+	}
+
+	return page, nil
+}
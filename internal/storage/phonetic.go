@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/phonetic"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	similarUsersLimit     = 100
+	findSimilarUsersQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE deleted_at IS NULL
+		AND (phonetic_primary IN ($1, $2) OR phonetic_alternate IN ($1, $2))
+	ORDER BY name, surname, patronymic, id
+	LIMIT $3
+	`
+	usersWithoutPhoneticKeysQuery = `
+	SELECT id, name
+	FROM enriched_user
+	WHERE phonetic_primary IS NULL
+	`
+	setPhoneticKeysQuery = `
+	UPDATE enriched_user
+	SET phonetic_primary = $2, phonetic_alternate = $3
+	WHERE id = $1
+	`
+)
+
+// FindSimilarUsers returns the users whose names sound like the name, including the name itself.
+func (p *Postgres) FindSimilarUsers(ctx context.Context, userName string) ([]models.ResponseEnrich, error) {
+	primary, alternate := phonetic.Keys(userName)
+	if primary == "" {
+		return []models.ResponseEnrich{}, nil
+	}
+
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, findSimilarUsersQuery, primary, alternate, similarUsersLimit)
+	if err != nil {
+		return nil, fmt.Errorf("conn.Query(ctx, findSimilarUsersQuery): %w", err)
+	}
+
+	return collectUsers(rows, scanUser)
+}
+
+// BackfillPhoneticKeys computes the phonetic keys of the users saved before the keys were
+// introduced and returns their number.
+func (p *Postgres) BackfillPhoneticKeys(ctx context.Context) (int, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, usersWithoutPhoneticKeysQuery)
+	if err != nil {
+		return 0, fmt.Errorf("conn.Query(ctx, usersWithoutPhoneticKeysQuery): %w", err)
+	}
+
+	type user struct {
+		ID   string
+		Name string
+	}
+
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByPos[user])
+	if err != nil {
+		return 0, fmt.Errorf("pgx.CollectRows: %w", err)
+	}
+
+	if len(users) == 0 {
+		return 0, nil
+	}
+
+	batch := &pgx.Batch{}
+
+	for _, u := range users {
+		primary, alternate := phonetic.Keys(u.Name)
+		batch.Queue(setPhoneticKeysQuery, u.ID, primary, alternate)
+	}
+
+	if err = conn.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, fmt.Errorf("conn.SendBatch(ctx, batch).Close(): %w", err)
+	}
+
+	return len(users), nil
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// getStaleUsersQuery returns up to $2 live users last refreshed, or created if never refreshed, before $1,
+	// the stalest first.
+	getStaleUsersQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE coalesce(refreshed_at, created_at) < $1 AND deleted_at IS NULL
+	ORDER BY coalesce(refreshed_at, created_at), id
+	LIMIT $2
+	`
+	refreshUserQuery = `
+	UPDATE enriched_user
+	SET age = $2, gender = $3, country = $4, countries = $5, attributes = $6, provenance = $7,
+		updated_at = now(), refreshed_at = now()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + userColumns + `;
+	`
+	markRefreshedQuery = `
+	UPDATE enriched_user
+	SET refreshed_at = now()
+	WHERE id = $1 AND deleted_at IS NULL
+	`
+	saveRefreshRunQuery = `
+	INSERT INTO refresh_run (id, started_at, finished_at, checked, updated, failed, stopped, changes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+	`
+	getRefreshRunsQuery = `
+	SELECT id, started_at, finished_at, checked, updated, failed, stopped
+	FROM refresh_run
+	ORDER BY started_at DESC, id
+	LIMIT $1
+	`
+	getRefreshRunQuery = `
+	SELECT id, started_at, finished_at, checked, updated, failed, stopped, changes
+	FROM refresh_run
+	WHERE id = $1
+	`
+)
+
+var ErrRefreshRunNotFound = errors.New("no such refresh run")
+
+// GetStaleUsers returns up to limit live users whose values were last requested from the providers,
+// by a refresh or at creation, before refreshedBefore. The stalest users come first.
+func (p *Postgres) GetStaleUsers(ctx context.Context, refreshedBefore time.Time, limit int) (
+	[]models.ResponseEnrich, error,
+) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, getStaleUsersQuery, refreshedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("conn.Query(ctx, getStaleUsersQuery, refreshedBefore, limit): %w", err)
+	}
+
+	users, err := collectUsers(rows, scanUser)
+	if err != nil {
+		return nil, fmt.Errorf("collectUsers(rows, scanUser): %w", err)
+	}
+
+	return users, nil
+}
+
+// RefreshUser updates the stored user with the values the providers resolved for it again, see
+// mergeRefreshed, and marks it refreshed. It returns the values that changed; the change is recorded
+// in the history.
+func (p *Postgres) RefreshUser(ctx context.Context, refreshed models.ResponseEnrich) ([]models.RefreshChange, error) {
+	var changes []models.RefreshChange
+
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		oldUser, err := scanUser(tx.QueryRow(ctx, getUserQuery+` FOR UPDATE`, refreshed.ID))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanUser: %w", err)
+		}
+
+		var user models.ResponseEnrich
+
+		user, changes = mergeRefreshed(cloneUser(oldUser), refreshed)
+		if len(changes) == 0 {
+			if _, err = tx.Exec(ctx, markRefreshedQuery, user.ID); err != nil {
+				return fmt.Errorf("tx.Exec(ctx, markRefreshedQuery): %w", err)
+			}
+
+			return nil
+		}
+
+		row := tx.QueryRow(ctx, refreshUserQuery, user.ID, user.Age, user.Gender, user.Country, user.Countries,
+			attributesOrEmpty(user.Attributes), provenanceOrEmpty(user.Provenance))
+
+		refreshedUser, err := scanUser(row)
+		if err != nil {
+			return fmt.Errorf("scanUser: %w", err)
+		}
+
+		return insertHistory(ctx, tx, models.OperationUpdate, &oldUser, &refreshedUser)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("p.inTx: %w", err)
+	}
+
+	return changes, nil
+}
+
+// SaveRefreshRun records the run with its changes.
+func (p *Postgres) SaveRefreshRun(ctx context.Context, run models.RefreshRun) error {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, saveRefreshRunQuery, run.ID, run.StartedAt, run.FinishedAt, run.Checked, run.Updated,
+		run.Failed, run.Stopped, refreshChangesOrEmpty(run.Changes))
+	if err != nil {
+		return fmt.Errorf("conn.Exec(ctx, saveRefreshRunQuery): %w", err)
+	}
+
+	return nil
+}
+
+// GetRefreshRuns returns up to limit runs without their changes, the latest first.
+func (p *Postgres) GetRefreshRuns(ctx context.Context, limit int) ([]models.RefreshRun, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, getRefreshRunsQuery, limit)
+	if err != nil {
+		return nil, fmt.Errorf("conn.Query(ctx, getRefreshRunsQuery, limit): %w", err)
+	}
+
+	defer rows.Close()
+
+	runs := make([]models.RefreshRun, 0)
+
+	for rows.Next() {
+		var run models.RefreshRun
+
+		err = rows.Scan(&run.ID, &run.StartedAt, &run.FinishedAt, &run.Checked, &run.Updated, &run.Failed,
+			&run.Stopped)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return runs, nil
+}
+
+// GetRefreshRun returns the run with its changes.
+func (p *Postgres) GetRefreshRun(ctx context.Context, runID string) (models.RefreshRun, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return models.RefreshRun{}, fmt.Errorf("p.acquire(ctx): %w", err)
+	}
+
+	defer conn.Release()
+
+	var run models.RefreshRun
+
+	err = conn.QueryRow(ctx, getRefreshRunQuery, runID).Scan(&run.ID, &run.StartedAt, &run.FinishedAt, &run.Checked,
+		&run.Updated, &run.Failed, &run.Stopped, &run.Changes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.RefreshRun{}, ErrRefreshRunNotFound
+	}
+
+	if err != nil {
+		return models.RefreshRun{}, fmt.Errorf("conn.QueryRow(ctx, getRefreshRunQuery, runID).Scan: %w", err)
+	}
+
+	return run, nil
+}
+
+// mergeRefreshed updates the stored user with the values the providers resolved again and returns the
+// changes. Locked values are kept, see replaces, and so are the stored ones when a provider has no value
+// for the field. The countries go with the country.
+func mergeRefreshed(currentUser, refreshed models.ResponseEnrich) (models.ResponseEnrich, []models.RefreshChange) {
+	changes := make([]models.RefreshChange, 0)
+
+	if currentUser.Provenance == nil {
+		currentUser.Provenance = make(map[string]models.Provenance)
+	}
+
+	if currentUser.Attributes == nil {
+		currentUser.Attributes = make(map[string]interface{})
+	}
+
+	refreshable := func(field string) bool {
+		p, resolved := refreshed.Provenance[field]
+
+		return resolved && replaces(currentUser.Provenance[field], p)
+	}
+
+	change := func(field string, oldValue, newValue interface{}) {
+		changes = append(changes, models.RefreshChange{
+			UserID:   currentUser.ID,
+			Name:     currentUser.Name,
+			Field:    field,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+		currentUser.Provenance[field] = refreshed.Provenance[field]
+	}
+
+	if refreshable(models.FieldAge) && refreshed.Age != 0 && refreshed.Age != currentUser.Age {
+		change(models.FieldAge, currentUser.Age, refreshed.Age)
+		currentUser.Age = refreshed.Age
+	}
+
+	if refreshable(models.FieldGender) && refreshed.Gender != "" && refreshed.Gender != currentUser.Gender {
+		change(models.FieldGender, currentUser.Gender, refreshed.Gender)
+		currentUser.Gender = refreshed.Gender
+	}
+
+	if refreshable(models.FieldCountry) && refreshed.Country != "" && refreshed.Country != currentUser.Country {
+		change(models.FieldCountry, currentUser.Country, refreshed.Country)
+		currentUser.Country, currentUser.Countries = refreshed.Country, refreshed.Countries
+	}
+
+	attributeNames := make([]string, 0, len(refreshed.Attributes))
+	for attrName := range refreshed.Attributes {
+		attributeNames = append(attributeNames, attrName)
+	}
+
+	sort.Strings(attributeNames)
+
+	for _, attrName := range attributeNames {
+		value := refreshed.Attributes[attrName]
+		oldValue, ok := currentUser.Attributes[attrName]
+
+		if !refreshable(attrName) || value == nil || (ok && attributeText(oldValue) == attributeText(value)) {
+			continue
+		}
+
+		change(attrName, oldValue, value)
+		currentUser.Attributes[attrName] = value
+	}
+
+	return currentUser, changes
+}
+
+func refreshChangesOrEmpty(changes []models.RefreshChange) []models.RefreshChange {
+	if changes == nil {
+		return []models.RefreshChange{}
+	}
+
+	return changes
+}
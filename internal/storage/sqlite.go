@@ -0,0 +1,1543 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/phonetic"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteScheme is the DSN scheme that selects SQLite, followed by the path of the database file,
+// e.g. sqlite://enricher.db or sqlite:///var/lib/enricher.db.
+const SQLiteScheme = "sqlite://"
+
+//go:embed sqlite_migrations
+var sqliteMigrations embed.FS
+
+const (
+	// sqliteTimeLayout is fixed-width so that times stored in UTC compare as strings.
+	sqliteTimeLayout = "2006-01-02T15:04:05.000000Z"
+	// sqliteKeySeparator joins the normalized parts of the identity key.
+	sqliteKeySeparator = "\x1f"
+	// sqliteExportBatch is how many users an export reads at once.
+	sqliteExportBatch = 500
+
+	sqliteSaveUserQuery = `
+	INSERT INTO enriched_user (id, name, surname, patronymic, age, gender, country, countries, attributes, provenance,
+		created_at, updated_at, name_key, identity_key, phonetic_primary, phonetic_alternate)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11, $12, $13, $14, $15)
+	ON CONFLICT (identity_key) WHERE deleted_at IS NULL DO UPDATE
+	SET age = excluded.age, gender = excluded.gender, country = excluded.country, countries = excluded.countries,
+		attributes = excluded.attributes, provenance = excluded.provenance, updated_at = excluded.updated_at
+	RETURNING ` + userColumns + `;
+	`
+	sqliteGetUserByIdentityQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE identity_key = $1 AND deleted_at IS NULL
+	`
+	sqliteGetUserQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE id = $1 AND deleted_at IS NULL
+	`
+	sqliteGetDeletedUserQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+	sqliteFindUsersByNameQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE name_key = $1 AND deleted_at IS NULL
+	ORDER BY surname, patronymic, id
+	`
+	sqliteFindSimilarUsersQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE deleted_at IS NULL
+		AND (phonetic_primary IN ($1, $2) OR phonetic_alternate IN ($1, $2))
+	ORDER BY name, surname, patronymic, id
+	LIMIT $3
+	`
+	sqliteUpdateUserQuery = `
+	UPDATE enriched_user
+	SET surname = $2, patronymic = $3, age = $4, gender = $5, country = $6, attributes = $7, provenance = $8,
+		updated_at = $9, identity_key = $10, countries = $11
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + userColumns + `;
+	`
+	sqliteDeleteUserQuery = `
+	UPDATE enriched_user
+	SET deleted_at = $2
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + userColumns + `;
+	`
+	sqliteRestoreUserQuery = `
+	UPDATE enriched_user
+	SET deleted_at = NULL, updated_at = $2
+	WHERE id = $1
+	RETURNING ` + userColumns + `;
+	`
+	sqliteDeletedUsersQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE deleted_at < $1
+	`
+	sqlitePurgeDeletedUsersQuery = `
+	DELETE FROM enriched_user
+	WHERE deleted_at < $1
+	`
+	sqliteInsertHistoryQuery = `
+	INSERT INTO user_history (user_id, name, name_key, operation, old_value, new_value, actor, request_id, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+	`
+	sqliteGetUserHistoryQuery = `
+	SELECT id, user_id, name, operation, old_value, new_value, actor, request_id, created_at
+	FROM user_history
+	WHERE user_id = $1 OR name_key = $2
+	ORDER BY created_at, id
+	`
+	sqliteCreateJobQuery = `
+	INSERT INTO enrichment_job (id, actor, request_id, file_name, header, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $6)
+	`
+	sqliteCreateJobItemQuery = `
+	INSERT INTO enrichment_job_item (job_id, position, request, cells, status, error, finished_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	sqliteGetJobQuery = `
+	SELECT id, status, file_name, header, actor, request_id, created_at, updated_at, finished_at
+	FROM enrichment_job
+	WHERE id = $1
+	`
+	sqliteGetJobItemsQuery = `
+	SELECT position, request, cells, status, attempts, result, error
+	FROM enrichment_job_item
+	WHERE job_id = $1
+	ORDER BY position
+	`
+	sqliteClaimableJobItemsQuery = `
+	SELECT item.job_id, item.position, item.request, item.attempts, job.actor, job.request_id
+	FROM enrichment_job_item AS item
+	JOIN enrichment_job AS job ON job.id = item.job_id
+	WHERE (item.status = 'pending' AND (item.not_before IS NULL OR item.not_before <= $3))
+		OR (item.status = 'running' AND item.claimed_at < $2)
+	ORDER BY job.created_at, job.rowid, item.position
+	LIMIT $1
+	`
+	sqliteClaimJobItemQuery = `
+	UPDATE enrichment_job_item
+	SET status = 'running', attempts = attempts + 1, claimed_at = $3
+	WHERE job_id = $1 AND position = $2
+	`
+	sqliteStartJobQuery = `
+	UPDATE enrichment_job
+	SET status = 'running', updated_at = $2
+	WHERE id = $1 AND status = 'queued'
+	`
+	sqliteFinishJobItemQuery = `
+	UPDATE enrichment_job_item
+	SET status = $3, attempts = $4, result = $5, error = $6, claimed_at = NULL, not_before = $8,
+		finished_at = CASE WHEN $3 IN ('done', 'failed') THEN $7 END
+	WHERE job_id = $1 AND position = $2
+	`
+	sqliteUpdateJobQuery = `
+	UPDATE enrichment_job
+	SET status = CASE WHEN unfinished THEN status ELSE 'completed' END,
+		finished_at = CASE WHEN unfinished THEN NULL ELSE $2 END,
+		updated_at = $2
+	FROM (
+		SELECT EXISTS (
+			SELECT 1 FROM enrichment_job_item WHERE job_id = $1 AND status IN ('pending', 'running')
+		) AS unfinished
+	) AS items
+	WHERE id = $1
+	RETURNING status
+	`
+	sqliteGetStaleUsersQuery = `
+	SELECT ` + userColumns + `
+	FROM enriched_user
+	WHERE coalesce(refreshed_at, created_at) < $1 AND deleted_at IS NULL
+	ORDER BY coalesce(refreshed_at, created_at), id
+	LIMIT $2
+	`
+	sqliteRefreshUserQuery = `
+	UPDATE enriched_user
+	SET age = $2, gender = $3, country = $4, countries = $5, attributes = $6, provenance = $7,
+		updated_at = $8, refreshed_at = $8
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + userColumns + `;
+	`
+	sqliteMarkRefreshedQuery = `
+	UPDATE enriched_user
+	SET refreshed_at = $2
+	WHERE id = $1 AND deleted_at IS NULL
+	`
+	sqliteSaveRefreshRunQuery = `
+	INSERT INTO refresh_run (id, started_at, finished_at, checked, updated, failed, stopped, changes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+	`
+	sqliteGetRefreshRunsQuery = `
+	SELECT id, started_at, finished_at, checked, updated, failed, stopped, '[]'
+	FROM refresh_run
+	ORDER BY started_at DESC, id
+	LIMIT $1
+	`
+	sqliteGetRefreshRunQuery = `
+	SELECT id, started_at, finished_at, checked, updated, failed, stopped, changes
+	FROM refresh_run
+	WHERE id = $1
+	`
+)
+
+// registerSQLiteFunctions provides the functions of Postgres the queries rely on.
+var registerSQLiteFunctions = sync.OnceValue(func() error {
+	err := sqlite.RegisterDeterministicScalarFunction("word_similarity", 2,
+		func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			query, _ := args[0].(string)
+			text, _ := args[1].(string)
+
+			return float64(wordSimilarity(query, text)), nil
+		})
+	if err != nil {
+		return fmt.Errorf(`sqlite.RegisterDeterministicScalarFunction("word_similarity"): %w`, err)
+	}
+
+	err = sqlite.RegisterDeterministicScalarFunction("unicode_lower", 1,
+		func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			value, _ := args[0].(string)
+
+			return strings.ToLower(value), nil
+		})
+	if err != nil {
+		return fmt.Errorf(`sqlite.RegisterDeterministicScalarFunction("unicode_lower"): %w`, err)
+	}
+
+	return nil
+})
+
+// SQLite keeps users in a single database file for deployments that do not need Postgres.
+type SQLite struct {
+	db  *sql.DB
+	log *logrus.Entry
+}
+
+func ConnectSQLite(ctx context.Context, dsn string, log *logrus.Logger) (*SQLite, error) {
+	if err := registerSQLiteFunctions(); err != nil {
+		return nil, fmt.Errorf("registerSQLiteFunctions(): %w", err)
+	}
+
+	db, err := sql.Open("sqlite", strings.TrimPrefix(dsn, SQLiteScheme))
+	if err != nil {
+		return nil, fmt.Errorf(`sql.Open("sqlite", dsn): %w`, err)
+	}
+
+	// SQLite serializes writes anyway; a single connection also keeps an in-memory database shared
+	// by all queries.
+	db.SetMaxOpenConns(1)
+
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("db.PingContext(ctx): %w", err)
+	}
+
+	return &SQLite{
+		db:  db,
+		log: log.WithField("module", "sqlite"),
+	}, nil
+}
+
+func (s *SQLite) Close() {
+	if err := s.db.Close(); err != nil {
+		s.log.Warningf("s.db.Close(): %s", err)
+	}
+}
+
+func (s *SQLite) Migrate(direction migrate.MigrationDirection) error {
+	if _, err := s.MigrateMax(direction, 0); err != nil {
+		return fmt.Errorf("s.MigrateMax(direction, 0): %w", err)
+	}
+
+	return nil
+}
+
+// MigrateMax applies at most max migrations in the direction, all of them if max is zero, and returns
+// their number.
+func (s *SQLite) MigrateMax(direction migrate.MigrationDirection, max int) (int, error) {
+	return execMigrations(s.db, "sqlite3", migrationSource(sqliteMigrations, "sqlite_migrations"), direction, max)
+}
+
+func (s *SQLite) MigrationStatus() ([]MigrationStatus, error) {
+	return migrationStatus(s.db, "sqlite3", migrationSource(sqliteMigrations, "sqlite_migrations"))
+}
+
+// SaveUser inserts the user or, if a user with the same identity exists, overwrites its enrichment
+// keeping the stored ID. It returns the user as stored and records the change in the history.
+func (s *SQLite) SaveUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
+	var savedUser models.ResponseEnrich
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+
+		savedUser, _, err = sqliteSaveUser(ctx, tx, user, false)
+
+		return err
+	})
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return savedUser, nil
+}
+
+// ImportUsers saves the users like Postgres.ImportUsers, in one transaction.
+func (s *SQLite) ImportUsers(ctx context.Context, users []models.ResponseEnrich) ([]models.ImportedUser, error) {
+	imported := make([]models.ImportedUser, 0, len(users))
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, user := range users {
+			savedUser, operation, err := sqliteSaveUser(ctx, tx, user, true)
+			if err != nil {
+				return err
+			}
+
+			imported = append(imported, models.ImportedUser{User: savedUser, Operation: operation})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return imported, nil
+}
+
+// sqliteSaveUser saves the user within tx and records the change in the history. With merge the values
+// missing from the user keep the stored ones, see mergeImported. It returns the operation of the change.
+func sqliteSaveUser(ctx context.Context, tx *sql.Tx, user models.ResponseEnrich, merge bool) (
+	models.ResponseEnrich, string, error,
+) {
+	var oldUser *models.ResponseEnrich
+
+	key := identityKey(user.RequestEnrich)
+
+	currentUser, err := scanSQLiteUser(tx.QueryRowContext(ctx, sqliteGetUserByIdentityQuery, key))
+	if err == nil {
+		oldUser = &currentUser
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return models.ResponseEnrich{}, "", fmt.Errorf("scanSQLiteUser: %w", err)
+	}
+
+	switch {
+	case merge && oldUser != nil:
+		user = mergeImported(cloneUser(currentUser), user)
+	case oldUser != nil:
+		user = keepLocked(currentUser, user)
+	}
+
+	countries, attributes, provenance, err := sqliteJSON(user)
+	if err != nil {
+		return models.ResponseEnrich{}, "", fmt.Errorf("sqliteJSON(user): %w", err)
+	}
+
+	phoneticPrimary, phoneticAlternate := phonetic.Keys(user.Name)
+
+	row := tx.QueryRowContext(ctx, sqliteSaveUserQuery, user.ID, user.Name, user.Surname, user.Patronymic,
+		user.Age, user.Gender, user.Country, countries, attributes, provenance, sqliteNow(), normalize(user.Name),
+		key, phoneticPrimary, phoneticAlternate)
+
+	savedUser, err := scanSQLiteUser(row)
+	if err != nil {
+		return models.ResponseEnrich{}, "", fmt.Errorf("scanSQLiteUser: %w", err)
+	}
+
+	operation := models.OperationCreate
+	if oldUser != nil {
+		operation = models.OperationUpdate
+	}
+
+	if err = sqliteInsertHistory(ctx, tx, operation, oldUser, &savedUser); err != nil {
+		return models.ResponseEnrich{}, "", err
+	}
+
+	return savedUser, operation, nil
+}
+
+func (s *SQLite) GetUser(ctx context.Context, userID string) (models.ResponseEnrich, error) {
+	user, err := scanSQLiteUser(s.db.QueryRowContext(ctx, sqliteGetUserQuery, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ResponseEnrich{}, ErrUserNotFound
+		}
+
+		return models.ResponseEnrich{}, fmt.Errorf("scanSQLiteUser: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *SQLite) GetUserByIdentity(ctx context.Context, userName models.RequestEnrich) (models.ResponseEnrich, error) {
+	user, err := scanSQLiteUser(s.db.QueryRowContext(ctx, sqliteGetUserByIdentityQuery, identityKey(userName)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ResponseEnrich{}, ErrUserNotFound
+		}
+
+		return models.ResponseEnrich{}, fmt.Errorf("scanSQLiteUser: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *SQLite) FindUsersByName(ctx context.Context, userName string) ([]models.ResponseEnrich, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteFindUsersByNameQuery, normalize(userName))
+	if err != nil {
+		return nil, fmt.Errorf("s.db.QueryContext(ctx, sqliteFindUsersByNameQuery): %w", err)
+	}
+
+	users, err := collectSQLiteUsers(rows, scanSQLiteUser)
+	if err != nil {
+		return nil, fmt.Errorf("collectSQLiteUsers(rows, scanSQLiteUser): %w", err)
+	}
+
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return users, nil
+}
+
+// FindSimilarUsers returns the users whose names sound like the name, including the name itself.
+func (s *SQLite) FindSimilarUsers(ctx context.Context, userName string) ([]models.ResponseEnrich, error) {
+	primary, alternate := phonetic.Keys(userName)
+	if primary == "" {
+		return []models.ResponseEnrich{}, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqliteFindSimilarUsersQuery, primary, alternate, similarUsersLimit)
+	if err != nil {
+		return nil, fmt.Errorf("s.db.QueryContext(ctx, sqliteFindSimilarUsersQuery): %w", err)
+	}
+
+	return collectSQLiteUsers(rows, scanSQLiteUser)
+}
+
+// GetUsersList returns a page of the filtered users. The page continues from params.Cursor when it is
+// set and from params.Offset otherwise. The total is always counted.
+func (s *SQLite) GetUsersList(ctx context.Context, params models.ListingQueryParams) (models.UsersPage, error) {
+	if err := checkPage(params); err != nil {
+		return models.UsersPage{}, fmt.Errorf("checkPage(params): %w", err)
+	}
+
+	keys, err := sortKeys(params)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("sortKeys: %w", err)
+	}
+
+	var (
+		values   []interface{}
+		backward bool
+	)
+
+	if params.Cursor != "" {
+		values, backward, err = decodeCursor(params.Cursor, keys)
+		if err != nil {
+			return models.UsersPage{}, fmt.Errorf("decodeCursor: %w", err)
+		}
+	}
+
+	filteredQuery, filteredArgs := filteredSQLiteUsersQuery(params)
+
+	pageQuery, pageArgs := buildPageQuery(`SELECT * FROM (`+filteredQuery+`) AS listed WHERE TRUE`,
+		filteredArgs, keys, values, backward, params)
+
+	rows, err := s.db.QueryContext(ctx, pageQuery, sqliteArgs(pageArgs)...)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("s.db.QueryContext(ctx, pageQuery, pageArgs...): %w", err)
+	}
+
+	users, err := collectSQLiteUsers(rows, scanListedSQLiteUser)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("collectSQLiteUsers(rows, scanListedSQLiteUser): %w", err)
+	}
+
+	page, err := newUsersPage(users, keys, values != nil, backward, params)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("newUsersPage: %w", err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM (`+filteredQuery+`) AS filtered`,
+		sqliteArgs(filteredArgs)...).Scan(&page.Total)
+	if err != nil {
+		return models.UsersPage{}, fmt.Errorf("total: %w", err)
+	}
+
+	return page, nil
+}
+
+// ExportUsers calls fn for every user matching the filters of params in their order. The users are read
+// in batches of sqliteExportBatch continuing after the last exported user, and the single connection of
+// the store is released before fn is called, so a slow reader does not hold up other requests. Unlike
+// the Postgres export, the batches are not read from one snapshot.
+func (s *SQLite) ExportUsers(ctx context.Context, params models.ListingQueryParams,
+	fn func(user models.ResponseEnrich) error,
+) error {
+	keys, err := sortKeys(params)
+	if err != nil {
+		return fmt.Errorf("sortKeys: %w", err)
+	}
+
+	filteredQuery, filteredArgs := filteredSQLiteUsersQuery(params)
+	batch := models.ListingQueryParams{ItemsPerPage: sqliteExportBatch}
+
+	var values []interface{}
+
+	for {
+		batchQuery, batchArgs := buildPageQuery(`SELECT * FROM (`+filteredQuery+`) AS listed WHERE TRUE`,
+			filteredArgs, keys, values, false, batch)
+
+		rows, err := s.db.QueryContext(ctx, batchQuery, sqliteArgs(batchArgs)...)
+		if err != nil {
+			return fmt.Errorf("s.db.QueryContext(ctx, batchQuery, batchArgs...): %w", err)
+		}
+
+		users, err := collectSQLiteUsers(rows, scanListedSQLiteUser)
+		if err != nil {
+			return fmt.Errorf("collectSQLiteUsers(rows, scanListedSQLiteUser): %w", err)
+		}
+
+		// buildPageQuery reads one user more than the batch to tell whether another batch follows.
+		hasMore := len(users) > sqliteExportBatch
+		if hasMore {
+			users = users[:sqliteExportBatch]
+		}
+
+		for _, user := range users {
+			if err = fn(user); err != nil {
+				return err
+			}
+		}
+
+		if !hasMore {
+			return nil
+		}
+
+		values = keyValues(users[len(users)-1], keys)
+	}
+}
+
+func (s *SQLite) UpdateUser(ctx context.Context, user models.ResponseEnrich) (models.ResponseEnrich, error) {
+	var updatedUser models.ResponseEnrich
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		oldUser, err := scanSQLiteUser(tx.QueryRowContext(ctx, sqliteGetUserQuery, user.ID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanSQLiteUser: %w", err)
+		}
+
+		countries, attributes, provenance, err := sqliteJSON(user)
+		if err != nil {
+			return fmt.Errorf("sqliteJSON(user): %w", err)
+		}
+
+		key := identityKey(models.RequestEnrich{Name: oldUser.Name, Surname: user.Surname, Patronymic: user.Patronymic})
+
+		row := tx.QueryRowContext(
+			ctx,
+			sqliteUpdateUserQuery,
+			user.ID,
+			user.Surname,
+			user.Patronymic,
+			user.Age,
+			user.Gender,
+			user.Country,
+			attributes,
+			provenance,
+			sqliteNow(),
+			key,
+			countries,
+		)
+
+		updatedUser, err = scanSQLiteUser(row)
+		if err != nil {
+			if isSQLiteUniqueViolation(err) {
+				return ErrUserExists
+			}
+
+			return fmt.Errorf("scanSQLiteUser: %w", err)
+		}
+
+		return sqliteInsertHistory(ctx, tx, models.OperationUpdate, &oldUser, &updatedUser)
+	})
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return updatedUser, nil
+}
+
+func (s *SQLite) DeleteUser(ctx context.Context, userID string) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		oldUser, err := scanSQLiteUser(tx.QueryRowContext(ctx, sqliteDeleteUserQuery, userID, sqliteNow()))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanSQLiteUser: %w", err)
+		}
+
+		return sqliteInsertHistory(ctx, tx, models.OperationDelete, &oldUser, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreUser undoes the deletion of the user.
+func (s *SQLite) RestoreUser(ctx context.Context, userID string) (models.ResponseEnrich, error) {
+	var restoredUser models.ResponseEnrich
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		oldUser, err := scanSQLiteUser(tx.QueryRowContext(ctx, sqliteGetDeletedUserQuery, userID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanSQLiteUser: %w", err)
+		}
+
+		restoredUser, err = scanSQLiteUser(tx.QueryRowContext(ctx, sqliteRestoreUserQuery, userID, sqliteNow()))
+		if err != nil {
+			if isSQLiteUniqueViolation(err) {
+				return ErrUserExists
+			}
+
+			return fmt.Errorf("scanSQLiteUser: %w", err)
+		}
+
+		return sqliteInsertHistory(ctx, tx, models.OperationRestore, &oldUser, &restoredUser)
+	})
+	if err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return restoredUser, nil
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before the time and returns their number.
+func (s *SQLite) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	var purged int64
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, sqliteDeletedUsersQuery, sqliteTime(deletedBefore))
+		if err != nil {
+			return fmt.Errorf("tx.QueryContext(ctx, sqliteDeletedUsersQuery): %w", err)
+		}
+
+		users, err := collectSQLiteUsers(rows, scanSQLiteUser)
+		if err != nil {
+			return fmt.Errorf("collectSQLiteUsers(rows, scanSQLiteUser): %w", err)
+		}
+
+		for i := range users {
+			if err = sqliteInsertHistory(ctx, tx, models.OperationPurge, &users[i], nil); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, sqlitePurgeDeletedUsersQuery, sqliteTime(deletedBefore))
+		if err != nil {
+			return fmt.Errorf("tx.ExecContext(ctx, sqlitePurgeDeletedUsersQuery): %w", err)
+		}
+
+		purged, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("result.RowsAffected(): %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return purged, nil
+}
+
+// GetUserHistory returns the changes of the user with the ID or of every user with the name,
+// including deleted ones, oldest first.
+func (s *SQLite) GetUserHistory(ctx context.Context, userRef string) ([]models.HistoryRecord, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetUserHistoryQuery, userRef, normalize(userRef))
+	if err != nil {
+		return nil, fmt.Errorf("s.db.QueryContext(ctx, sqliteGetUserHistoryQuery, userRef): %w", err)
+	}
+
+	defer rows.Close()
+
+	history := make([]models.HistoryRecord, 0)
+
+	for rows.Next() {
+		var (
+			record             models.HistoryRecord
+			oldValue, newValue sql.NullString
+			createdAt          string
+		)
+
+		err = rows.Scan(
+			&record.ID,
+			&record.UserID,
+			&record.Name,
+			&record.Operation,
+			&oldValue,
+			&newValue,
+			&record.Actor,
+			&record.RequestID,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		if record.OldValue, err = parseSQLiteUserJSON(oldValue); err != nil {
+			return nil, fmt.Errorf("parseSQLiteUserJSON(oldValue): %w", err)
+		}
+
+		if record.NewValue, err = parseSQLiteUserJSON(newValue); err != nil {
+			return nil, fmt.Errorf("parseSQLiteUserJSON(newValue): %w", err)
+		}
+
+		if record.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+			return nil, fmt.Errorf("parseSQLiteTime(createdAt): %w", err)
+		}
+
+		history = append(history, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return history, nil
+}
+
+// CreateJob saves the queued job with its items. Items that are failed already are saved as finished.
+// The job is returned without the items.
+func (s *SQLite) CreateJob(ctx context.Context, job models.Job) (models.Job, error) {
+	now := sqliteNow()
+
+	header, err := sqliteStrings(job.Header)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("sqliteStrings(job.Header): %w", err)
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, sqliteCreateJobQuery, job.ID, job.Actor, job.RequestID, job.FileName, header, now)
+		if err != nil {
+			return fmt.Errorf("tx.ExecContext(ctx, sqliteCreateJobQuery): %w", err)
+		}
+
+		for _, item := range job.Items {
+			request, err := json.Marshal(item.Request)
+			if err != nil {
+				return fmt.Errorf("json.Marshal(item.Request): %w", err)
+			}
+
+			cells, err := sqliteStrings(item.Cells)
+			if err != nil {
+				return fmt.Errorf("sqliteStrings(item.Cells): %w", err)
+			}
+
+			status := newJobItemStatus(item)
+
+			var finishedAt interface{}
+			if status == models.JobItemFailed {
+				finishedAt = now
+			}
+
+			_, err = tx.ExecContext(ctx, sqliteCreateJobItemQuery, job.ID, item.Position, string(request), cells, status,
+				item.Error, finishedAt)
+			if err != nil {
+				return fmt.Errorf("tx.ExecContext(ctx, sqliteCreateJobItemQuery): %w", err)
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, sqliteUpdateJobQuery, job.ID, now).Scan(&job.Status)
+		if err != nil {
+			return fmt.Errorf("tx.QueryRowContext(ctx, sqliteUpdateJobQuery).Scan: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.Job{}, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	createdAt, err := parseSQLiteTime(now)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("parseSQLiteTime(now): %w", err)
+	}
+
+	job.CreatedAt, job.UpdatedAt, job.FinishedAt = createdAt, createdAt, nil
+	if job.Status == models.JobCompleted {
+		job.FinishedAt = &createdAt
+	}
+
+	return createdJob(job), nil
+}
+
+// GetJob returns the job with its items in the order they were submitted.
+func (s *SQLite) GetJob(ctx context.Context, jobID string) (models.Job, error) {
+	var (
+		job                  models.Job
+		createdAt, updatedAt string
+		header, finishedAt   sql.NullString
+	)
+
+	err := s.db.QueryRowContext(ctx, sqliteGetJobQuery, jobID).Scan(&job.ID, &job.Status, &job.FileName, &header,
+		&job.Actor, &job.RequestID, &createdAt, &updatedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Job{}, ErrJobNotFound
+	}
+
+	if err != nil {
+		return models.Job{}, fmt.Errorf("s.db.QueryRowContext(ctx, sqliteGetJobQuery, jobID).Scan: %w", err)
+	}
+
+	if job.Header, err = parseSQLiteStrings(header); err != nil {
+		return models.Job{}, fmt.Errorf("parseSQLiteStrings(header): %w", err)
+	}
+
+	if job.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+		return models.Job{}, fmt.Errorf("parseSQLiteTime(createdAt): %w", err)
+	}
+
+	if job.UpdatedAt, err = parseSQLiteTime(updatedAt); err != nil {
+		return models.Job{}, fmt.Errorf("parseSQLiteTime(updatedAt): %w", err)
+	}
+
+	if finishedAt.Valid {
+		t, err := parseSQLiteTime(finishedAt.String)
+		if err != nil {
+			return models.Job{}, fmt.Errorf("parseSQLiteTime(finishedAt): %w", err)
+		}
+
+		job.FinishedAt = &t
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqliteGetJobItemsQuery, jobID)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("s.db.QueryContext(ctx, sqliteGetJobItemsQuery, jobID): %w", err)
+	}
+
+	defer rows.Close()
+
+	job.Items = make([]models.JobItem, 0)
+
+	for rows.Next() {
+		var (
+			item          = models.JobItem{JobID: job.ID}
+			request       string
+			cells, result sql.NullString
+		)
+
+		err = rows.Scan(&item.Position, &request, &cells, &item.Status, &item.Attempts, &result, &item.Error)
+		if err != nil {
+			return models.Job{}, fmt.Errorf("rows.Scan: %w", err)
+		}
+
+		if item.Cells, err = parseSQLiteStrings(cells); err != nil {
+			return models.Job{}, fmt.Errorf("parseSQLiteStrings(cells): %w", err)
+		}
+
+		if err = json.Unmarshal([]byte(request), &item.Request); err != nil {
+			return models.Job{}, fmt.Errorf("json.Unmarshal(request): %w", err)
+		}
+
+		if item.User, err = parseSQLiteUserJSON(result); err != nil {
+			return models.Job{}, fmt.Errorf("parseSQLiteUserJSON(result): %w", err)
+		}
+
+		job.Items = append(job.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.Job{}, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	countJobItems(&job)
+
+	return job, nil
+}
+
+// ClaimJobItems marks up to limit items as running and returns them, oldest job first. Items claimed before
+// staleBefore are taken over, as their worker is considered gone.
+func (s *SQLite) ClaimJobItems(ctx context.Context, limit int, staleBefore time.Time) ([]models.JobItem, error) {
+	items := make([]models.JobItem, 0, limit)
+	now := sqliteNow()
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, sqliteClaimableJobItemsQuery, limit, sqliteTime(staleBefore), now)
+		if err != nil {
+			return fmt.Errorf("tx.QueryContext(ctx, sqliteClaimableJobItemsQuery): %w", err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				item    = models.JobItem{Status: models.JobItemRunning}
+				request string
+			)
+
+			err = rows.Scan(&item.JobID, &item.Position, &request, &item.Attempts, &item.Actor, &item.RequestID)
+			if err != nil {
+				return fmt.Errorf("rows.Scan: %w", err)
+			}
+
+			if err = json.Unmarshal([]byte(request), &item.Request); err != nil {
+				return fmt.Errorf("json.Unmarshal(request): %w", err)
+			}
+
+			item.Attempts++
+			items = append(items, item)
+		}
+
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows.Err(): %w", err)
+		}
+
+		for _, item := range items {
+			if _, err = tx.ExecContext(ctx, sqliteClaimJobItemQuery, item.JobID, item.Position, now); err != nil {
+				return fmt.Errorf("tx.ExecContext(ctx, sqliteClaimJobItemQuery): %w", err)
+			}
+
+			if _, err = tx.ExecContext(ctx, sqliteStartJobQuery, item.JobID, now); err != nil {
+				return fmt.Errorf("tx.ExecContext(ctx, sqliteStartJobQuery): %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return items, nil
+}
+
+// FinishJobItem saves the status, attempts and result of the claimed item and completes its job once no item
+// is left to enrich. A pending item is given back to the workers, not before its NotBefore if it is set.
+func (s *SQLite) FinishJobItem(ctx context.Context, item models.JobItem) error {
+	var result interface{}
+
+	if item.User != nil {
+		raw, err := json.Marshal(item.User)
+		if err != nil {
+			return fmt.Errorf("json.Marshal(item.User): %w", err)
+		}
+
+		result = string(raw)
+	}
+
+	var notBefore interface{}
+	if item.NotBefore != nil {
+		notBefore = sqliteTime(*item.NotBefore)
+	}
+
+	now := sqliteNow()
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, sqliteFinishJobItemQuery, item.JobID, item.Position, item.Status, item.Attempts,
+			result, item.Error, now, notBefore)
+		if err != nil {
+			return fmt.Errorf("tx.ExecContext(ctx, sqliteFinishJobItemQuery): %w", err)
+		}
+
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return ErrJobNotFound
+		}
+
+		if _, err = tx.ExecContext(ctx, sqliteUpdateJobQuery, item.JobID, now); err != nil {
+			return fmt.Errorf("tx.ExecContext(ctx, sqliteUpdateJobQuery): %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return nil
+}
+
+// GetStaleUsers returns up to limit live users last refreshed, or created if never refreshed, before
+// refreshedBefore, the stalest first.
+func (s *SQLite) GetStaleUsers(ctx context.Context, refreshedBefore time.Time, limit int) (
+	[]models.ResponseEnrich, error,
+) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetStaleUsersQuery, sqliteTime(refreshedBefore), limit)
+	if err != nil {
+		return nil, fmt.Errorf("s.db.QueryContext(ctx, sqliteGetStaleUsersQuery): %w", err)
+	}
+
+	users, err := collectSQLiteUsers(rows, scanSQLiteUser)
+	if err != nil {
+		return nil, fmt.Errorf("collectSQLiteUsers(rows, scanSQLiteUser): %w", err)
+	}
+
+	return users, nil
+}
+
+// RefreshUser updates the user like Postgres.RefreshUser.
+func (s *SQLite) RefreshUser(ctx context.Context, refreshed models.ResponseEnrich) ([]models.RefreshChange, error) {
+	var changes []models.RefreshChange
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		oldUser, err := scanSQLiteUser(tx.QueryRowContext(ctx, sqliteGetUserQuery, refreshed.ID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("scanSQLiteUser: %w", err)
+		}
+
+		var user models.ResponseEnrich
+
+		user, changes = mergeRefreshed(cloneUser(oldUser), refreshed)
+		if len(changes) == 0 {
+			if _, err = tx.ExecContext(ctx, sqliteMarkRefreshedQuery, user.ID, sqliteNow()); err != nil {
+				return fmt.Errorf("tx.ExecContext(ctx, sqliteMarkRefreshedQuery): %w", err)
+			}
+
+			return nil
+		}
+
+		countries, attributes, provenance, err := sqliteJSON(user)
+		if err != nil {
+			return fmt.Errorf("sqliteJSON(user): %w", err)
+		}
+
+		row := tx.QueryRowContext(ctx, sqliteRefreshUserQuery, user.ID, user.Age, user.Gender, user.Country, countries,
+			attributes, provenance, sqliteNow())
+
+		refreshedUser, err := scanSQLiteUser(row)
+		if err != nil {
+			return fmt.Errorf("scanSQLiteUser: %w", err)
+		}
+
+		return sqliteInsertHistory(ctx, tx, models.OperationUpdate, &oldUser, &refreshedUser)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s.inTx: %w", err)
+	}
+
+	return changes, nil
+}
+
+// SaveRefreshRun records the run with its changes.
+func (s *SQLite) SaveRefreshRun(ctx context.Context, run models.RefreshRun) error {
+	changes, err := json.Marshal(refreshChangesOrEmpty(run.Changes))
+	if err != nil {
+		return fmt.Errorf("json.Marshal(run.Changes): %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, sqliteSaveRefreshRunQuery, run.ID, sqliteTime(run.StartedAt),
+		sqliteTime(run.FinishedAt), run.Checked, run.Updated, run.Failed, run.Stopped, string(changes))
+	if err != nil {
+		return fmt.Errorf("s.db.ExecContext(ctx, sqliteSaveRefreshRunQuery): %w", err)
+	}
+
+	return nil
+}
+
+// GetRefreshRuns returns up to limit runs without their changes, the latest first.
+func (s *SQLite) GetRefreshRuns(ctx context.Context, limit int) ([]models.RefreshRun, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetRefreshRunsQuery, limit)
+	if err != nil {
+		return nil, fmt.Errorf("s.db.QueryContext(ctx, sqliteGetRefreshRunsQuery, limit): %w", err)
+	}
+
+	defer rows.Close()
+
+	runs := make([]models.RefreshRun, 0)
+
+	for rows.Next() {
+		run, err := scanSQLiteRefreshRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanSQLiteRefreshRun: %w", err)
+		}
+
+		run.Changes = nil
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return runs, nil
+}
+
+// GetRefreshRun returns the run with its changes.
+func (s *SQLite) GetRefreshRun(ctx context.Context, runID string) (models.RefreshRun, error) {
+	run, err := scanSQLiteRefreshRun(s.db.QueryRowContext(ctx, sqliteGetRefreshRunQuery, runID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.RefreshRun{}, ErrRefreshRunNotFound
+	}
+
+	if err != nil {
+		return models.RefreshRun{}, fmt.Errorf("scanSQLiteRefreshRun: %w", err)
+	}
+
+	return run, nil
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds.
+func (s *SQLite) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("s.db.BeginTx(ctx, nil): %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.log.Warningf("tx.Rollback(): %s", err)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("tx.Commit(): %w", err)
+	}
+
+	return nil
+}
+
+// sqliteInsertHistory appends the change to user_history, taking the actor and request ID from ctx.
+func sqliteInsertHistory(ctx context.Context, tx *sql.Tx, operation string, oldUser, newUser *models.ResponseEnrich,
+) error {
+	user := newUser
+	if user == nil {
+		user = oldUser
+	}
+
+	values := make([]interface{}, 0, 2)
+
+	for _, value := range []*models.ResponseEnrich{oldUser, newUser} {
+		if value == nil {
+			values = append(values, nil)
+
+			continue
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("json.Marshal(value): %w", err)
+		}
+
+		values = append(values, string(raw))
+	}
+
+	meta := audit.FromContext(ctx)
+
+	_, err := tx.ExecContext(ctx, sqliteInsertHistoryQuery, user.ID, user.Name, normalize(user.Name), operation,
+		values[0], values[1], meta.Actor, meta.RequestID, sqliteNow())
+	if err != nil {
+		return fmt.Errorf("tx.ExecContext(ctx, sqliteInsertHistoryQuery): %w", err)
+	}
+
+	return nil
+}
+
+// filteredSQLiteUsersQuery selects the users matching the filters of params along with their search score.
+func filteredSQLiteUsersQuery(params models.ListingQueryParams) (string, []interface{}) {
+	var args []interface{}
+
+	score := `0.0`
+	if params.Query != "" {
+		args = append(args, params.Query)
+		score = `word_similarity($1, name || ' ' || surname || ' ' || patronymic)`
+	}
+
+	query := `
+	SELECT ` + userColumns + `, ` + score + ` AS score
+	FROM enriched_user
+	WHERE TRUE
+	`
+
+	return buildSQLiteQueryAndArgs(args, query, params)
+}
+
+// buildSQLiteQueryAndArgs is buildQueryAndArgs for SQLite. Case-insensitive matching folds both sides
+// with unicode_lower, since LIKE of SQLite only ignores the case of ASCII letters.
+func buildSQLiteQueryAndArgs(args []interface{}, query string, params models.ListingQueryParams) (
+	string, []interface{},
+) {
+	if !params.IncludeDeleted {
+		query += ` AND deleted_at IS NULL`
+	}
+
+	if params.Query != "" {
+		args = append(args, wordSimilarityThreshold)
+		query += fmt.Sprintf(` AND word_similarity($1, name || ' ' || surname || ' ' || patronymic) >= $%d`,
+			len(args))
+	}
+
+	if params.TextFilter != "" {
+		args = append(args, "%"+strings.ToLower(params.TextFilter)+"%")
+		query += fmt.Sprintf(` AND (
+			unicode_lower(name) LIKE $%d OR unicode_lower(surname) LIKE $%d OR unicode_lower(patronymic) LIKE $%d
+			OR unicode_lower(gender) LIKE $%d OR unicode_lower(country) LIKE $%d
+			)`, len(args), len(args), len(args), len(args), len(args))
+	}
+
+	for _, attrName := range sortedKeys(params.Attributes) {
+		args = append(args, attrName, params.Attributes[attrName])
+		query += fmt.Sprintf(` AND CASE json_type(attributes -> $%d) WHEN 'text' THEN attributes ->> $%d
+			ELSE attributes -> $%d END = $%d`, len(args)-1, len(args)-1, len(args)-1, len(args))
+	}
+
+	timeRanges := []struct {
+		column string
+		value  time.Time
+		op     string
+	}{
+		{createdAt, params.CreatedAfter, ">="},
+		{createdAt, params.CreatedBefore, "<"},
+		{updatedAt, params.UpdatedAfter, ">="},
+		{updatedAt, params.UpdatedBefore, "<"},
+	}
+
+	for _, r := range timeRanges {
+		if !r.value.IsZero() {
+			args = append(args, r.value)
+			query += fmt.Sprintf(` AND %s %s $%d`, r.column, r.op, len(args))
+		}
+	}
+
+	if params.AgeMin != nil {
+		args = append(args, *params.AgeMin)
+		query += fmt.Sprintf(` AND age >= $%d`, len(args))
+	}
+
+	if params.AgeMax != nil {
+		args = append(args, *params.AgeMax)
+		query += fmt.Sprintf(` AND age <= $%d`, len(args))
+	}
+
+	if params.Gender != "" {
+		args = append(args, params.Gender)
+		query += fmt.Sprintf(` AND gender = $%d`, len(args))
+	}
+
+	if len(params.Countries) > 0 {
+		placeholders := make([]string, 0, len(params.Countries))
+
+		for _, c := range params.Countries {
+			args = append(args, c)
+			placeholders = append(placeholders, fmt.Sprintf(`$%d`, len(args)))
+		}
+
+		query += ` AND country IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+
+	if params.SurnamePrefix != "" {
+		args = append(args, escapeLike(strings.ToLower(params.SurnamePrefix))+"%")
+		query += fmt.Sprintf(` AND unicode_lower(surname) LIKE $%d ESCAPE '\'`, len(args))
+	}
+
+	if params.ManualOverride != "" {
+		args = append(args, params.ManualOverride)
+		query += fmt.Sprintf(` AND provenance -> $%d ->> 'manual' = 1`, len(args))
+	}
+
+	return query, args
+}
+
+func sortedKeys(attributes map[string]string) []string {
+	attrNames := make([]string, 0, len(attributes))
+	for attrName := range attributes {
+		attrNames = append(attrNames, attrName)
+	}
+
+	sort.Strings(attrNames)
+
+	return attrNames
+}
+
+// sqliteArgs stores times in the layout of the database.
+func sqliteArgs(args []interface{}) []interface{} {
+	converted := make([]interface{}, 0, len(args))
+
+	for _, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			arg = sqliteTime(t)
+		}
+
+		converted = append(converted, arg)
+	}
+
+	return converted
+}
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+func sqliteNow() string {
+	return sqliteTime(time.Now())
+}
+
+func parseSQLiteTime(value string) (time.Time, error) {
+	t, err := time.Parse(sqliteTimeLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time.Parse: %w", err)
+	}
+
+	return t.Local(), nil
+}
+
+// identityKey is the normalized name, surname and patronymic a live person is identified by.
+func identityKey(userName models.RequestEnrich) string {
+	return strings.Join([]string{
+		normalize(userName.Name), normalize(userName.Surname), normalize(userName.Patronymic),
+	}, sqliteKeySeparator)
+}
+
+// sqliteJSON encodes the JSON columns of the user.
+func sqliteJSON(user models.ResponseEnrich) (countries, attributes, provenance interface{}, err error) {
+	if user.Countries != nil {
+		raw, err := json.Marshal(user.Countries)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("json.Marshal(user.Countries): %w", err)
+		}
+
+		countries = string(raw)
+	}
+
+	rawAttributes, err := json.Marshal(attributesOrEmpty(user.Attributes))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("json.Marshal(user.Attributes): %w", err)
+	}
+
+	rawProvenance, err := json.Marshal(provenanceOrEmpty(user.Provenance))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("json.Marshal(user.Provenance): %w", err)
+	}
+
+	return countries, string(rawAttributes), string(rawProvenance), nil
+}
+
+func parseSQLiteUserJSON(value sql.NullString) (*models.ResponseEnrich, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+
+	var user models.ResponseEnrich
+
+	if err := json.Unmarshal([]byte(value.String), &user); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return &user, nil
+}
+
+// sqliteStrings is the JSON text of the values, NULL for nil.
+func sqliteStrings(values []string) (interface{}, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	return string(raw), nil
+}
+
+func parseSQLiteStrings(value sql.NullString) ([]string, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+
+	var values []string
+
+	if err := json.Unmarshal([]byte(value.String), &values); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return values, nil
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+type sqliteRow interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLiteUser(row sqliteRow) (models.ResponseEnrich, error) {
+	return scanSQLiteUserWith(row, nil)
+}
+
+// scanListedSQLiteUser scans a row of the users list, which has the search score after the user columns.
+func scanListedSQLiteUser(row sqliteRow) (models.ResponseEnrich, error) {
+	var userScore float64
+
+	user, err := scanSQLiteUserWith(row, &userScore)
+	user.Score = float32(userScore)
+
+	return user, err
+}
+
+func scanSQLiteUserWith(row sqliteRow, userScore *float64) (models.ResponseEnrich, error) {
+	var (
+		user                         models.ResponseEnrich
+		countries, deletedAt         sql.NullString
+		attributes, provenance       string
+		userCreatedAt, userUpdatedAt string
+	)
+
+	dest := []interface{}{
+		&user.ID,
+		&user.Name,
+		&user.Surname,
+		&user.Patronymic,
+		&user.Age,
+		&user.Gender,
+		&user.Country,
+		&countries,
+		&attributes,
+		&provenance,
+		&userCreatedAt,
+		&userUpdatedAt,
+		&deletedAt,
+	}
+
+	if userScore != nil {
+		dest = append(dest, userScore)
+	}
+
+	if err := row.Scan(dest...); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("row.Scan: %w", err)
+	}
+
+	if countries.Valid {
+		if err := json.Unmarshal([]byte(countries.String), &user.Countries); err != nil {
+			return models.ResponseEnrich{}, fmt.Errorf("json.Unmarshal(countries): %w", err)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(attributes), &user.Attributes); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("json.Unmarshal(attributes): %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(provenance), &user.Provenance); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("json.Unmarshal(provenance): %w", err)
+	}
+
+	var err error
+
+	if user.CreatedAt, err = parseSQLiteTime(userCreatedAt); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("parseSQLiteTime(created_at): %w", err)
+	}
+
+	if user.UpdatedAt, err = parseSQLiteTime(userUpdatedAt); err != nil {
+		return models.ResponseEnrich{}, fmt.Errorf("parseSQLiteTime(updated_at): %w", err)
+	}
+
+	if deletedAt.Valid {
+		userDeletedAt, err := parseSQLiteTime(deletedAt.String)
+		if err != nil {
+			return models.ResponseEnrich{}, fmt.Errorf("parseSQLiteTime(deleted_at): %w", err)
+		}
+
+		user.DeletedAt = &userDeletedAt
+	}
+
+	return user, nil
+}
+
+func collectSQLiteUsers(rows *sql.Rows, scan func(sqliteRow) (models.ResponseEnrich, error)) (
+	[]models.ResponseEnrich, error,
+) {
+	defer rows.Close()
+
+	usersList := make([]models.ResponseEnrich, 0)
+
+	for rows.Next() {
+		user, err := scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		usersList = append(usersList, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	return usersList, nil
+}
+
+func scanSQLiteRefreshRun(row sqliteRow) (models.RefreshRun, error) {
+	var (
+		run                   models.RefreshRun
+		startedAt, finishedAt string
+		changes               string
+	)
+
+	err := row.Scan(&run.ID, &startedAt, &finishedAt, &run.Checked, &run.Updated, &run.Failed, &run.Stopped, &changes)
+	if err != nil {
+		return models.RefreshRun{}, fmt.Errorf("row.Scan: %w", err)
+	}
+
+	if run.StartedAt, err = parseSQLiteTime(startedAt); err != nil {
+		return models.RefreshRun{}, fmt.Errorf("parseSQLiteTime(started_at): %w", err)
+	}
+
+	if run.FinishedAt, err = parseSQLiteTime(finishedAt); err != nil {
+		return models.RefreshRun{}, fmt.Errorf("parseSQLiteTime(finished_at): %w", err)
+	}
+
+	if err = json.Unmarshal([]byte(changes), &run.Changes); err != nil {
+		return models.RefreshRun{}, fmt.Errorf("json.Unmarshal(changes): %w", err)
+	}
+
+	return run, nil
+}
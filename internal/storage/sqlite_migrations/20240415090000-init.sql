@@ -0,0 +1,70 @@
+-- +migrate Up
+-- The schema of the Postgres migrations up to 20240408121733-phonetic-keys. Times are stored as fixed-width
+-- UTC text, so they compare as strings. The normalized name and identity keys are computed by the service
+-- because lower() of SQLite only folds ASCII letters.
+CREATE TABLE enriched_user (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    surname TEXT NOT NULL,
+    patronymic TEXT NOT NULL DEFAULT '',
+    age INTEGER NOT NULL,
+    gender TEXT NOT NULL,
+    country TEXT NOT NULL,
+    countries TEXT,
+    attributes TEXT NOT NULL DEFAULT '{}',
+    provenance TEXT NOT NULL DEFAULT '{}',
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    deleted_at TEXT,
+    name_key TEXT NOT NULL,
+    identity_key TEXT NOT NULL,
+    phonetic_primary TEXT NOT NULL,
+    phonetic_alternate TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX enriched_user_identity_idx ON enriched_user (identity_key) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_name_key_idx ON enriched_user (name_key) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_deleted_at_idx ON enriched_user (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX enriched_user_name_id_idx ON enriched_user (name, id) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_created_at_id_idx ON enriched_user (created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_updated_at_id_idx ON enriched_user (updated_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_country_age_name_id_idx ON enriched_user (country, age DESC, name, id)
+    WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_phonetic_primary_idx ON enriched_user (phonetic_primary) WHERE deleted_at IS NULL;
+CREATE INDEX enriched_user_phonetic_alternate_idx ON enriched_user (phonetic_alternate) WHERE deleted_at IS NULL;
+
+CREATE TABLE user_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    name_key TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX user_history_user_id_idx ON user_history (user_id);
+CREATE INDEX user_history_name_key_idx ON user_history (name_key);
+
+-- +migrate StatementBegin
+CREATE TRIGGER user_history_append_only
+    BEFORE UPDATE ON user_history
+BEGIN
+    SELECT RAISE(ABORT, 'user_history is append-only');
+END;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE TRIGGER user_history_no_delete
+    BEFORE DELETE ON user_history
+BEGIN
+    SELECT RAISE(ABORT, 'user_history is append-only');
+END;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP TABLE user_history;
+DROP TABLE enriched_user;
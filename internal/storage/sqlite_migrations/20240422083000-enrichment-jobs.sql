@@ -0,0 +1,30 @@
+-- +migrate Up
+CREATE TABLE enrichment_job (
+    id TEXT PRIMARY KEY,
+    status TEXT NOT NULL DEFAULT 'queued',
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    finished_at TEXT
+);
+
+CREATE TABLE enrichment_job_item (
+    job_id TEXT NOT NULL REFERENCES enrichment_job (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    request TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    result TEXT,
+    error TEXT NOT NULL DEFAULT '',
+    claimed_at TEXT,
+    finished_at TEXT,
+    PRIMARY KEY (job_id, position)
+);
+
+CREATE INDEX enrichment_job_item_unfinished_idx ON enrichment_job_item (job_id, position)
+    WHERE status IN ('pending', 'running');
+
+-- +migrate Down
+DROP TABLE enrichment_job_item;
+DROP TABLE enrichment_job;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE enrichment_job_item ADD COLUMN not_before TEXT;
+
+-- +migrate Down
+ALTER TABLE enrichment_job_item DROP COLUMN not_before;
@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE enrichment_job ADD COLUMN file_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE enrichment_job ADD COLUMN header TEXT;
+ALTER TABLE enrichment_job_item ADD COLUMN cells TEXT;
+
+-- +migrate Down
+ALTER TABLE enrichment_job_item DROP COLUMN cells;
+ALTER TABLE enrichment_job DROP COLUMN header;
+ALTER TABLE enrichment_job DROP COLUMN file_name;
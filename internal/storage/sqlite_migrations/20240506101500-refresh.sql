@@ -0,0 +1,23 @@
+-- +migrate Up
+ALTER TABLE enriched_user ADD COLUMN refreshed_at TEXT;
+
+CREATE INDEX enriched_user_refreshed_at_id_idx ON enriched_user (coalesce(refreshed_at, created_at), id)
+    WHERE deleted_at IS NULL;
+
+CREATE TABLE refresh_run (
+    id TEXT PRIMARY KEY,
+    started_at TEXT NOT NULL,
+    finished_at TEXT NOT NULL,
+    checked INTEGER NOT NULL DEFAULT 0,
+    updated INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    stopped TEXT NOT NULL DEFAULT '',
+    changes TEXT NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX refresh_run_started_at_idx ON refresh_run (started_at);
+
+-- +migrate Down
+DROP TABLE refresh_run;
+DROP INDEX enriched_user_refreshed_at_id_idx;
+ALTER TABLE enriched_user DROP COLUMN refreshed_at;
@@ -0,0 +1,19 @@
+-- +migrate Up
+-- Values set manually before the locks were introduced are locked.
+UPDATE enriched_user
+SET provenance = (
+    SELECT json_group_object(
+        p.key,
+        json(CASE WHEN json_extract(p.value, '$.manual') THEN json_set(p.value, '$.locked', json('true')) ELSE p.value END)
+    )
+    FROM json_each(enriched_user.provenance) AS p
+)
+WHERE EXISTS (SELECT 1 FROM json_each(enriched_user.provenance) AS p WHERE json_extract(p.value, '$.manual'));
+
+-- +migrate Down
+UPDATE enriched_user
+SET provenance = (
+    SELECT json_group_object(p.key, json_remove(p.value, '$.locked'))
+    FROM json_each(enriched_user.provenance) AS p
+)
+WHERE EXISTS (SELECT 1 FROM json_each(enriched_user.provenance) AS p WHERE json_type(p.value, '$.locked') IS NOT NULL);
@@ -5,8 +5,10 @@ import (
 	"database/sql"
 	"embed"
 	"fmt"
+	"time"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	migrate "github.com/rubenv/sql-migrate"
 	"github.com/sirupsen/logrus"
 )
@@ -15,29 +17,157 @@ import (
 var migrations embed.FS
 
 type Postgres struct {
-	db  *pgx.Conn
-	dsn string
-	log *logrus.Entry
+	db             *pgxpool.Pool
+	dsn            string
+	acquireTimeout time.Duration
+	log            *logrus.Entry
+	registerer     prometheus.Registerer
+	collector      prometheus.Collector
 }
 
-func ConnectDB(ctx context.Context, dsn string, log *logrus.Logger) (*Postgres, error) {
-	db, err := pgx.Connect(ctx, dsn)
+type Config struct {
+	DSN               string
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// AcquireTimeout limits waiting for a free connection; zero means waiting until the request is done.
+	AcquireTimeout time.Duration
+	// Registerer takes the pool metrics until the pool is closed; prometheus.DefaultRegisterer by default.
+	Registerer prometheus.Registerer
+}
+
+func ConnectDB(ctx context.Context, cfg Config, log *logrus.Logger) (*Postgres, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.DSN)
 	if err != nil {
-		return nil, fmt.Errorf("pgx.Connect(ctx, dsn): %w", err)
+		return nil, fmt.Errorf("pgxpool.ParseConfig(cfg.DSN): %w", err)
+	}
+
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = cfg.MaxConns
+	}
+
+	if cfg.MinConns > 0 {
+		poolCfg.MinConns = cfg.MinConns
+	}
+
+	if cfg.MaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+
+	if cfg.MaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+
+	if cfg.HealthCheckPeriod > 0 {
+		poolCfg.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	db, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("pgxpool.NewWithConfig(ctx, poolCfg): %w", err)
 	}
 
 	if err = db.Ping(ctx); err != nil {
+		db.Close()
+
 		return nil, fmt.Errorf("db.Ping(ctx): %w", err)
 	}
 
+	registerer := cfg.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	collector := newPoolCollector(db)
+
+	if err = registerer.Register(collector); err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf("registerer.Register(collector): %w", err)
+	}
+
 	return &Postgres{
-		db:  db,
-		dsn: dsn,
-		log: log.WithField("module", "postgres"),
+		db:             db,
+		dsn:            cfg.DSN,
+		acquireTimeout: cfg.AcquireTimeout,
+		log:            log.WithField("module", "postgres"),
+		registerer:     registerer,
+		collector:      collector,
 	}, nil
 }
 
+// Close unregisters the pool metrics, waits for acquired connections to be released and closes the pool.
+func (p *Postgres) Close() {
+	p.registerer.Unregister(p.collector)
+	p.db.Close()
+}
+
+func (p *Postgres) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	acquireCtx := ctx
+
+	if p.acquireTimeout > 0 {
+		var cancel context.CancelFunc
+
+		acquireCtx, cancel = context.WithTimeout(ctx, p.acquireTimeout)
+		defer cancel()
+	}
+
+	conn, err := p.db.Acquire(acquireCtx)
+	if err != nil {
+		return nil, fmt.Errorf("p.db.Acquire(acquireCtx): %w", err)
+	}
+
+	return conn, nil
+}
+
 func (p *Postgres) Migrate(direction migrate.MigrationDirection) error {
+	if _, err := p.MigrateMax(direction, 0); err != nil {
+		return fmt.Errorf("p.MigrateMax(direction, 0): %w", err)
+	}
+
+	return nil
+}
+
+// MigrateMax applies at most max migrations in the direction, all of them if max is zero, and returns
+// their number.
+func (p *Postgres) MigrateMax(direction migrate.MigrationDirection, max int) (int, error) {
+	var applied int
+
+	err := p.withSQL(func(db *sql.DB) error {
+		var err error
+
+		applied, err = execMigrations(db, "postgres", migrationSource(migrations, "migrations"), direction, max)
+
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("p.withSQL: %w", err)
+	}
+
+	return applied, nil
+}
+
+func (p *Postgres) MigrationStatus() ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := p.withSQL(func(db *sql.DB) error {
+		var err error
+
+		statuses, err = migrationStatus(db, "postgres", migrationSource(migrations, "migrations"))
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("p.withSQL: %w", err)
+	}
+
+	return statuses, nil
+}
+
+// withSQL runs fn with a database/sql connection, which sql-migrate needs.
+func (p *Postgres) withSQL(fn func(db *sql.DB) error) error {
 	conn, err := sql.Open("pgx", p.dsn)
 	if err != nil {
 		return fmt.Errorf(`sql.Open("pgx", p.dsn): %w`, err)
@@ -50,43 +180,22 @@ func (p *Postgres) Migrate(direction migrate.MigrationDirection) error {
 		}
 	}()
 
-	assetDir := func() func(string) ([]string, error) {
-		return func(path string) ([]string, error) {
-			dirEntry, err := migrations.ReadDir(path)
-			if err != nil {
-				return nil, fmt.Errorf("migrations.ReadDir(path): %w", err)
-			}
-
-			entries := make([]string, 0)
-
-			for _, e := range dirEntry {
-				entries = append(entries, e.Name())
-			}
-
-			return entries, nil
-		}
-	}()
+	return fn(conn)
+}
 
-	asset := migrate.AssetMigrationSource{
-		Asset:    migrations.ReadFile,
-		AssetDir: assetDir,
-		Dir:      "migrations",
-	}
+func (p *Postgres) TruncateTable(ctx context.Context, table string) error {
+	query := fmt.Sprintf(`TRUNCATE TABLE %s`, table)
 
-	_, err = migrate.Exec(conn, "postgres", asset, direction)
+	conn, err := p.acquire(ctx)
 	if err != nil {
-		return fmt.Errorf(`migrate.Exec(conn, "postgres", asset, direction): %w`, err)
+		return fmt.Errorf("p.acquire(ctx): %w", err)
 	}
 
-	return nil
-}
-
-func (p *Postgres) TruncateTable(ctx context.Context, table string) error {
-	query := fmt.Sprintf(`TRUNCATE TABLE %s`, table)
+	defer conn.Release()
 
-	_, err := p.db.Exec(ctx, query)
+	_, err = conn.Exec(ctx, query)
 	if err != nil {
-		return fmt.Errorf("p.db.Exec(ctx, query): %w", err)
+		return fmt.Errorf("conn.Exec(ctx, query): %w", err)
 	}
 
 	return nil
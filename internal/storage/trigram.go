@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// wordSimilarityThreshold is the default of pg_trgm.word_similarity_threshold.
+const wordSimilarityThreshold = 0.6
+
+// wordSimilarity is the in-memory counterpart of word_similarity of pg_trgm: the greatest similarity
+// between the trigrams of the query and any continuous extent of the ordered trigrams of the text.
+func wordSimilarity(query, text string) float32 {
+	queryTrigrams := make(map[string]struct{})
+	for _, trigram := range trigrams(query) {
+		queryTrigrams[trigram] = struct{}{}
+	}
+
+	if len(queryTrigrams) == 0 {
+		return 0
+	}
+
+	textTrigrams := trigrams(text)
+
+	var best float32
+
+	for start := range textTrigrams {
+		extent := make(map[string]struct{})
+		common := 0
+
+		for _, trigram := range textTrigrams[start:] {
+			if _, ok := extent[trigram]; ok {
+				continue
+			}
+
+			extent[trigram] = struct{}{}
+
+			if _, ok := queryTrigrams[trigram]; ok {
+				common++
+			}
+
+			similarity := float32(common) / float32(len(queryTrigrams)+len(extent)-common)
+			if similarity > best {
+				best = similarity
+			}
+		}
+	}
+
+	return best
+}
+
+// trigrams splits the string into lowercase alphanumeric words padded with two spaces in front and one
+// behind, as pg_trgm does, and returns their trigrams in order.
+func trigrams(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	result := make([]string, 0)
+
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+
+		for i := 0; i+3 <= len(padded); i++ {
+			result = append(result, string(padded[i:i+3]))
+		}
+	}
+
+	return result
+}
+
+// likePattern is the in-memory counterpart of ILIKE with the default backslash escape.
+type likePattern struct {
+	re *regexp.Regexp
+}
+
+func newLikePattern(pattern string) (*likePattern, error) {
+	var b strings.Builder
+
+	b.WriteString(`(?is)^`)
+
+	escaped := false
+
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(`.*`)
+		case r == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString(`$`)
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("regexp.Compile: %w", err)
+	}
+
+	return &likePattern{re: re}, nil
+}
+
+func (p *likePattern) matchAny(values ...string) bool {
+	for _, value := range values {
+		if p.re.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/server"
+	"github.com/AlexZav1327/name-enricher/internal/service"
+	"github.com/AlexZav1327/name-enricher/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+const actorPort = 5007
+
+func TestActorHeader(t *testing.T) {
+	logger := logrus.StandardLogger()
+	resolvers, _ := newTestResolvers()
+	enricher := service.New(storage.NewMemory(), resolvers, service.Config{}, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.New(host, actorPort, enricher, logger).Run(ctx)
+	}()
+
+	time.Sleep(250 * time.Millisecond)
+
+	baseURL := fmt.Sprintf("http://localhost:%d", actorPort)
+
+	enrich := func(actor string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+enrichNameEndpoint,
+			strings.NewReader(`{"name":"Liza"}`))
+		require.NoError(t, err)
+
+		req.Header.Set("X-Actor", actor)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp
+	}
+
+	for _, actor := range []string{"<script>", "hr sync", strings.Repeat("a", 65)} {
+		require.Equal(t, http.StatusBadRequest, enrich(actor).StatusCode, actor)
+	}
+
+	require.Equal(t, http.StatusOK, enrich("hr-sync@example.com").StatusCode)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/v1/users/Liza/history", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	var history []models.HistoryRecord
+
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	require.Equal(t, 1, len(history))
+	require.Equal(t, "hr-sync@example.com", history[0].Actor)
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/provider"
+	"github.com/AlexZav1327/name-enricher/internal/server"
+	"github.com/AlexZav1327/name-enricher/internal/service"
+	"github.com/AlexZav1327/name-enricher/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	deadlinePort    = 5006
+	enrichTimeout   = 200 * time.Millisecond
+	slowName        = "Slow"
+	slowProviderLag = 2 * time.Second
+
+	interruptedMetric = "name_enricher_service_enrichments_interrupted_total"
+	requestsMetric    = "name_enricher_service_http_req_total"
+)
+
+// DeadlineTestSuite enriches users with in-memory storage and providers that answer slowly for slowName.
+type DeadlineTestSuite struct {
+	suite.Suite
+	url      string
+	provider *httptest.Server
+	cancel   context.CancelFunc
+}
+
+func (s *DeadlineTestSuite) SetupSuite() {
+	logger := logrus.StandardLogger()
+
+	s.provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") == slowName {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(slowProviderLag):
+			}
+		}
+
+		_, _ = w.Write([]byte(`{"age":30,"gender":"female","country":[{"country_id":"DE","probability":0.5}]}`))
+	}))
+
+	providers := make(provider.Set)
+
+	for _, name := range []string{"age", "gender", "country"} {
+		p, err := provider.New(provider.Config{
+			Name:        name,
+			URL:         s.provider.URL,
+			QueryParams: map[string]string{"name": "{{.Name}}"},
+			ValuePath:   name,
+			Metric:      "deadline_" + name + "_enrich_duration",
+		}, logger)
+		s.Require().NoError(err)
+
+		providers[name] = p
+	}
+
+	enricher := service.New(storage.NewMemory(), service.Resolvers{
+		Age:     providers["age"],
+		Gender:  providers["gender"],
+		Country: providers["country"],
+	}, service.Config{EnrichTimeout: enrichTimeout}, logger)
+
+	var ctx context.Context
+
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.url = fmt.Sprintf("http://localhost:%d", deadlinePort)
+
+	go func() {
+		_ = server.New(host, deadlinePort, enricher, logger).Run(ctx)
+	}()
+
+	time.Sleep(250 * time.Millisecond)
+}
+
+func (s *DeadlineTestSuite) TearDownSuite() {
+	s.cancel()
+	s.provider.Close()
+}
+
+func TestDeadlineTestSuite(t *testing.T) {
+	suite.Run(t, new(DeadlineTestSuite))
+}
+
+func (s *DeadlineTestSuite) TestEnrichDeadline() {
+	s.Run("fast providers enrich the user", func() {
+		resp := s.enrich(context.Background(), "Liza")
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+	})
+	s.Run("slow providers exceed the deadline", func() {
+		interrupted := s.metric(interruptedMetric + `{reason="deadline_exceeded"}`)
+		started := time.Now()
+
+		resp := s.enrich(context.Background(), slowName)
+
+		s.Require().Equal(http.StatusGatewayTimeout, resp.StatusCode)
+		s.Require().Less(time.Since(started), enrichTimeout+time.Second)
+		s.Require().Equal(interrupted+1, s.metric(interruptedMetric+`{reason="deadline_exceeded"}`))
+	})
+	s.Run("client going away cancels the enrichment", func() {
+		interrupted := s.metric(interruptedMetric + `{reason="canceled"}`)
+		closed := s.metric(requestsMetric + `{code="Client Closed Request",method="POST",path="/api/v1/user/enrich"}`)
+
+		ctx, cancel := context.WithTimeout(context.Background(), enrichTimeout/4)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+enrichNameEndpoint,
+			strings.NewReader(fmt.Sprintf(`{"name":%q}`, slowName)))
+		s.Require().NoError(err)
+
+		_, err = http.DefaultClient.Do(req)
+		s.Require().ErrorIs(err, context.DeadlineExceeded)
+
+		s.Require().Eventually(func() bool {
+			return s.metric(interruptedMetric+`{reason="canceled"}`) == interrupted+1 &&
+				s.metric(requestsMetric+`{code="Client Closed Request",method="POST",path="/api/v1/user/enrich"}`) ==
+					closed+1
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func (s *DeadlineTestSuite) enrich(ctx context.Context, name string) *http.Response {
+	s.T().Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+enrichNameEndpoint,
+		strings.NewReader(fmt.Sprintf(`{"name":%q}`, name)))
+	s.Require().NoError(err)
+
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	s.Require().NoError(resp.Body.Close())
+
+	return resp
+}
+
+// metric returns the value of the series exposed by the service, zero if it was not exposed yet.
+func (s *DeadlineTestSuite) metric(series string) float64 {
+	s.T().Helper()
+
+	resp, err := http.Get(s.url + "/metrics") //nolint:noctx
+	s.Require().NoError(err)
+
+	defer func() {
+		s.Require().NoError(resp.Body.Close())
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), series+" ")
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(value, 64)
+		s.Require().NoError(err)
+
+		return n
+	}
+
+	s.Require().NoError(scanner.Err())
+
+	return 0
+}
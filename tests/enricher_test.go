@@ -1,11 +1,19 @@
 package tests
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/google/uuid"
 )
 
 func (s *IntegrationTestSuite) TestServiceCRUD() {
@@ -48,6 +56,69 @@ func (s *IntegrationTestSuite) TestServiceCRUD() {
 
 		s.Require().Equal(respData.Country, respCountry.Country[0].CountryID)
 	})
+	s.Run("enrich user with custom attributes", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name: "Liza",
+		}
+
+		var respData models.ResponseEnrich
+
+		resp := s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal("senior", respData.Attributes["grade"])
+
+		req.Name = "Alex"
+		resp = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().NotContains(respData.Attributes, "grade")
+	})
+	s.Run("enrich user by full name", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			FullName: "Duchess Liza Devonovna",
+		}
+
+		var respData models.ResponseEnrich
+
+		resp := s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal("Liza", respData.Name)
+		s.Require().Equal("Duchess", respData.Surname)
+		s.Require().Equal("Devonovna", respData.Patronymic)
+		s.Require().NotZero(respData.ParseConfidence)
+	})
+	s.Run("enrich user not valid full name", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			FullName: "123 xyz",
+		}
+
+		resp := s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, nil)
+
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+	s.Run("parse full name normal case", func() {
+		ctx := context.Background()
+
+		req := models.RequestParse{
+			FullName: "Ludwig van Beethoven",
+		}
+
+		var respData models.ParsedName
+
+		resp := s.sendRequest(ctx, http.MethodPost, url+parseNameEndpoint, req, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal("Ludwig", respData.Name)
+		s.Require().Equal("van Beethoven", respData.Surname)
+	})
 	s.Run("enrich user not valid name", func() {
 		ctx := context.Background()
 
@@ -82,6 +153,113 @@ func (s *IntegrationTestSuite) TestServiceCRUD() {
 		s.Require().Equal(http.StatusOK, resp.StatusCode)
 		s.Require().Equal(reqUpdate.Age, respData.Age)
 		s.Require().Equal(reqUpdate.Country, respData.Country)
+		s.Require().Empty(respData.Countries)
+	})
+	s.Run("update user keeps provenance", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Kate",
+			Surname: "Mir",
+		}
+
+		var respData models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &respData)
+
+		s.Require().Equal("api.agify.io", respData.Provenance[models.FieldAge].Source)
+		s.Require().False(respData.Provenance[models.FieldAge].Manual)
+		s.Require().False(respData.CreatedAt.IsZero())
+
+		reqUpdate := models.ResponseEnrich{Age: respData.Age + 1}
+		resp := s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+respData.ID, reqUpdate, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(models.SourceManual, respData.Provenance[models.FieldAge].Source)
+		s.Require().True(respData.Provenance[models.FieldAge].Manual)
+		s.Require().False(respData.Provenance[models.FieldGender].Manual)
+		s.Require().True(respData.UpdatedAt.After(respData.CreatedAt))
+
+		var usersPage models.UsersPage
+
+		queryParams := "?manualOverride=age"
+		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(1, len(usersPage.Items))
+
+		queryParams = "?manualOverride=gender"
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(0, len(usersPage.Items))
+	})
+	s.Run("manual update locks the field", func() {
+		ctx := context.Background()
+
+		var respData models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, models.RequestEnrich{Name: "Liza"}, &respData)
+
+		s.Require().False(respData.Provenance[models.FieldGender].Locked)
+
+		resp := s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+respData.ID,
+			models.ResponseEnrich{Gender: "male"}, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().True(respData.Provenance[models.FieldGender].Locked)
+		s.Require().False(respData.Provenance[models.FieldAge].Locked)
+
+		resp = s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+respData.ID, models.ResponseEnrich{
+			Locks: map[string]bool{models.FieldGender: false, models.FieldAge: true, "grade": true},
+		}, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal("male", respData.Gender)
+		s.Require().False(respData.Provenance[models.FieldGender].Locked)
+		s.Require().True(respData.Provenance[models.FieldAge].Locked)
+		s.Require().False(respData.Provenance[models.FieldAge].Manual)
+		s.Require().True(respData.Provenance["grade"].Locked)
+
+		resp = s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+respData.ID, models.ResponseEnrich{
+			Locks: map[string]bool{"team": true},
+		}, nil)
+
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+	s.Run("user history records every change", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Kate",
+			Surname: "Mir",
+		}
+
+		var respData models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &respData)
+
+		reqUpdate := models.ResponseEnrich{Gender: "female"}
+		_ = s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+respData.ID, reqUpdate, nil)
+		_ = s.sendRequest(ctx, http.MethodDelete, url+userEndpoint+respData.ID, nil, nil)
+
+		var history []models.HistoryRecord
+
+		resp := s.sendRequest(ctx, http.MethodGet, url+userEndpoint+req.Name+historySuffix, nil, &history)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(3, len(history))
+		s.Require().Equal(models.OperationCreate, history[0].Operation)
+		s.Require().Nil(history[0].OldValue)
+		s.Require().Equal(models.OperationUpdate, history[1].Operation)
+		s.Require().Equal("female", history[1].NewValue.Gender)
+		s.Require().NotEmpty(history[1].RequestID)
+		s.Require().Equal(models.OperationDelete, history[2].Operation)
+		s.Require().Nil(history[2].NewValue)
+
+		resp = s.sendRequest(ctx, http.MethodGet, url+userEndpoint+respData.ID+historySuffix, nil, &history)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(3, len(history))
 	})
 	s.Run("update non-existent user", func() {
 		ctx := context.Background()
@@ -110,6 +288,196 @@ func (s *IntegrationTestSuite) TestServiceCRUD() {
 
 		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
 	})
+	s.Run("restore deleted user", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Olga",
+			Surname: "Petrova",
+		}
+
+		var respData models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &respData)
+
+		resp := s.sendRequest(ctx, http.MethodDelete, url+userEndpoint+respData.ID, nil, nil)
+
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+
+		resp = s.sendRequest(ctx, http.MethodGet, url+userEndpoint+respData.ID, nil, nil)
+
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+
+		var usersPage models.UsersPage
+
+		queryParams := "?textFilter=Olga"
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(0, len(usersPage.Items))
+
+		queryParams += "&includeDeleted=true"
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(1, len(usersPage.Items))
+		s.Require().NotNil(usersPage.Items[0].DeletedAt)
+
+		var restoredUser models.ResponseEnrich
+
+		resp = s.sendRequest(ctx, http.MethodPost, url+userEndpoint+respData.ID+restoreSuffix, nil, &restoredUser)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(respData.ID, restoredUser.ID)
+		s.Require().Nil(restoredUser.DeletedAt)
+
+		resp = s.sendRequest(ctx, http.MethodPost, url+userEndpoint+respData.ID+restoreSuffix, nil, nil)
+
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+	})
+	s.Run("restore deleted user enriched again", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Ivan",
+			Surname: "Sidorov",
+		}
+
+		var deletedUser, enrichedUser models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &deletedUser)
+		_ = s.sendRequest(ctx, http.MethodDelete, url+userEndpoint+deletedUser.ID, nil, nil)
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &enrichedUser)
+
+		s.Require().NotEqual(deletedUser.ID, enrichedUser.ID)
+
+		resp := s.sendRequest(ctx, http.MethodPost, url+userEndpoint+deletedUser.ID+restoreSuffix, nil, nil)
+
+		s.Require().Equal(http.StatusConflict, resp.StatusCode)
+	})
+	s.Run("purge deleted users", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Petr",
+			Surname: "Ivanov",
+		}
+
+		var respData models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &respData)
+		_ = s.sendRequest(ctx, http.MethodDelete, url+userEndpoint+respData.ID, nil, nil)
+
+		purged, err := s.pg.PurgeDeletedUsers(ctx, time.Now().Add(time.Minute))
+		s.Require().NoError(err)
+		s.Require().Positive(purged)
+
+		resp := s.sendRequest(ctx, http.MethodPost, url+userEndpoint+respData.ID+restoreSuffix, nil, nil)
+
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+
+		var history []models.HistoryRecord
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+userEndpoint+respData.ID+historySuffix, nil, &history)
+
+		s.Require().Equal(models.OperationPurge, history[len(history)-1].Operation)
+		s.Require().Equal(req.Surname, history[len(history)-1].OldValue.Surname)
+	})
+	s.Run("users with the same name are addressed by id", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Anna",
+			Surname: "Karenina",
+		}
+
+		var first, second models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &first)
+
+		req.Surname = "Akhmatova"
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &second)
+
+		s.Require().NotEmpty(first.ID)
+		s.Require().NotEqual(first.ID, second.ID)
+
+		reqUpdate := models.ResponseEnrich{Age: 28}
+
+		var respData models.ResponseEnrich
+
+		resp := s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+first.ID, reqUpdate, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(28, respData.Age)
+
+		resp = s.sendRequest(ctx, http.MethodGet, url+userEndpoint+second.ID, nil, &respData)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(second.Age, respData.Age)
+
+		resp = s.sendRequest(ctx, http.MethodDelete, url+deleteUserEndpoint+req.Name, nil, nil)
+
+		s.Require().Equal(http.StatusConflict, resp.StatusCode)
+
+		resp = s.sendRequest(ctx, http.MethodDelete, url+userEndpoint+first.ID, nil, nil)
+
+		s.Require().Equal(http.StatusNoContent, resp.StatusCode)
+
+		resp = s.sendRequest(ctx, http.MethodGet, url+userEndpoint+first.ID, nil, nil)
+
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+	})
+	s.Run("enrich the same person twice", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Liza",
+			Surname: "Duchess",
+		}
+
+		var first, second models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &first)
+
+		req.Name = " liza "
+		req.Surname = "DUCHESS"
+		resp := s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &second)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(first.ID, second.ID)
+
+		var usersPage models.UsersPage
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint, nil, &usersPage)
+
+		s.Require().Equal(1, len(usersPage.Items))
+	})
+	s.Run("enrich namesake with another surname", func() {
+		ctx := context.Background()
+
+		req := models.RequestEnrich{
+			Name:    "Liza",
+			Surname: "Duchess",
+		}
+
+		var first, second models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &first)
+
+		req.Surname = "Minnelli"
+		resp := s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, &second)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().NotEqual(first.ID, second.ID)
+		s.Require().Equal(first.Age, second.Age)
+		s.Require().Equal(first.Gender, second.Gender)
+		s.Require().Equal(first.Country, second.Country)
+	})
+	s.Run("get user by not valid id", func() {
+		ctx := context.Background()
+
+		resp := s.sendRequest(ctx, http.MethodGet, url+userEndpoint+"not-a-uuid", nil, nil)
+
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
 	s.Run("delete non-existent user", func() {
 		ctx := context.Background()
 
@@ -128,12 +496,12 @@ func (s *IntegrationTestSuite) TestUsersList() {
 	s.Run("get empty list of users normal case", func() {
 		ctx := context.Background()
 
-		var respData []models.ResponseEnrich
+		var usersPage models.UsersPage
 
-		resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint, nil, &respData)
+		resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint, nil, &usersPage)
 
 		s.Require().Equal(http.StatusOK, resp.StatusCode)
-		s.Require().Equal([]models.ResponseEnrich{}, respData)
+		s.Require().Equal([]models.ResponseEnrich{}, usersPage.Items)
 	})
 	s.Run("get users list normal case", func() {
 		ctx := context.Background()
@@ -152,36 +520,599 @@ func (s *IntegrationTestSuite) TestUsersList() {
 		req.Surname = "Duchess"
 		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, req, nil)
 
-		var respData []models.ResponseEnrich
+		var usersPage models.UsersPage
 
-		resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint, nil, &respData)
+		resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint, nil, &usersPage)
 
 		s.Require().Equal(http.StatusOK, resp.StatusCode)
-		s.Require().Equal(3, len(respData))
+		s.Require().Equal(3, len(usersPage.Items))
 
 		queryParams := "?textFilter=Liza"
-		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &respData)
+		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
 
 		s.Require().Equal(http.StatusOK, resp.StatusCode)
-		s.Require().Equal(1, len(respData))
-		s.Require().Equal("Liza", respData[0].Name)
+		s.Require().Equal(1, len(usersPage.Items))
+		s.Require().Equal("Liza", usersPage.Items[0].Name)
+
+		queryParams = "?attr.grade=junior"
+		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(1, len(usersPage.Items))
+		s.Require().Equal("Kate", usersPage.Items[0].Name)
 
 		queryParams = "?sorting=name&descending=true"
-		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &respData)
+		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
 
 		s.Require().Equal(http.StatusOK, resp.StatusCode)
-		s.Require().Equal("Liza", respData[0].Name)
+		s.Require().Equal("Liza", usersPage.Items[0].Name)
 
 		queryParams = "?itemsPerPage=2"
-		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &respData)
+		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
 
 		s.Require().Equal(http.StatusOK, resp.StatusCode)
-		s.Require().Equal(2, len(respData))
+		s.Require().Equal(2, len(usersPage.Items))
 
 		queryParams = "?offset=1"
-		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &respData)
+		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(2, len(usersPage.Items))
+	})
+	s.Run("get users list by structured filters", func() {
+		ctx := context.Background()
+
+		users := []models.ResponseEnrich{
+			{RequestEnrich: models.RequestEnrich{Name: "Anna", Surname: "Muller"}, Age: 30, Gender: "female", Country: "DE"},
+			{RequestEnrich: models.RequestEnrich{Name: "Eva", Surname: "Mayer"}, Age: 27, Gender: "female", Country: "AT"},
+			{RequestEnrich: models.RequestEnrich{Name: "Lena", Surname: "Moser"}, Age: 40, Gender: "female", Country: "AT"},
+			{RequestEnrich: models.RequestEnrich{Name: "Hans", Surname: "Maier"}, Age: 33, Gender: "male", Country: "DE"},
+		}
+
+		for _, user := range users {
+			var respData models.ResponseEnrich
+
+			_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, user.RequestEnrich, &respData)
+			_ = s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+respData.ID, user, nil)
+		}
+
+		var usersPage models.UsersPage
+
+		queryParams := "?gender=female&ageMin=25&ageMax=35&country=de,AT&surnamePrefix=M&sorting=age"
+		resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(2, len(usersPage.Items))
+		s.Require().Equal("Eva", usersPage.Items[0].Name)
+		s.Require().Equal("Anna", usersPage.Items[1].Name)
+
+		queryParams = "?surnamePrefix=Ma"
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(2, len(usersPage.Items))
+
+		queryParams = "?surnamePrefix=M_"
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(0, len(usersPage.Items))
+
+		queryParams = "?surnamePrefix=M&sort=country,-age,name"
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+
+		s.Require().Equal(4, len(usersPage.Items))
+		s.Require().Equal("Lena", usersPage.Items[0].Name)
+		s.Require().Equal("Eva", usersPage.Items[1].Name)
+		s.Require().Equal("Hans", usersPage.Items[2].Name)
+		s.Require().Equal("Anna", usersPage.Items[3].Name)
+
+		queryParams = "?surnamePrefix=M&sort=country,-age&itemsPerPage=3"
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &usersPage)
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams+"&cursor="+usersPage.NextCursor,
+			nil, &usersPage)
+
+		s.Require().Equal(1, len(usersPage.Items))
+		s.Require().Equal("Anna", usersPage.Items[0].Name)
+
+		for _, queryParams := range []string{
+			"?ageMin=-1", "?ageMin=40&ageMax=30", "?country=DEU", "?gender=", "?color=red",
+			"?sort=color", "?sort=name,-name", "?sort=name,", "?sort=age&sorting=name", "?sorting=color",
+			"?includeDeleted=yes", "?estimateTotal=maybe", "?manualOverride=", "?manualOverride=a%20b",
+		} {
+			resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, nil)
+
+			s.Require().Equal(http.StatusBadRequest, resp.StatusCode, queryParams)
+		}
+	})
+}
+
+func (s *IntegrationTestSuite) TestUsersPagination() {
+	ctx := context.Background()
+
+	for _, userName := range []string{"Alex", "Anna", "Kate", "Liza", "Olga"} {
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, models.RequestEnrich{Name: userName}, nil)
+	}
+
+	s.Run("follow next cursors to the last page", func() {
+		var firstPage, secondPage, lastPage models.UsersPage
+
+		resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+"?itemsPerPage=2", nil, &firstPage)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(int64(5), firstPage.Total)
+		s.Require().Equal("Alex", firstPage.Items[0].Name)
+		s.Require().Empty(firstPage.PrevCursor)
+		s.Require().NotEmpty(firstPage.NextCursor)
+		s.Require().Contains(resp.Header.Get("Link"), `rel="next"`)
+
+		queryParams := "?itemsPerPage=2&cursor=" + firstPage.NextCursor
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &secondPage)
+
+		s.Require().Equal("Kate", secondPage.Items[0].Name)
+		s.Require().NotEmpty(secondPage.PrevCursor)
+
+		queryParams = "?itemsPerPage=2&cursor=" + secondPage.NextCursor
+		resp = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &lastPage)
+
+		s.Require().Equal(1, len(lastPage.Items))
+		s.Require().Equal("Olga", lastPage.Items[0].Name)
+		s.Require().Empty(lastPage.NextCursor)
+		s.Require().NotContains(resp.Header.Get("Link"), `rel="next"`)
+	})
+	s.Run("prev cursor returns the previous page", func() {
+		var firstPage, secondPage, prevPage models.UsersPage
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+"?itemsPerPage=2&sorting=name&descending=true",
+			nil, &firstPage)
+
+		queryParams := "?itemsPerPage=2&sorting=name&descending=true&cursor=" + firstPage.NextCursor
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &secondPage)
+
+		s.Require().Equal("Kate", secondPage.Items[0].Name)
+
+		queryParams = "?itemsPerPage=2&sorting=name&descending=true&cursor=" + secondPage.PrevCursor
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &prevPage)
+
+		s.Require().Equal(firstPage.Items, prevPage.Items)
+		s.Require().Empty(prevPage.PrevCursor)
+	})
+	s.Run("estimated total", func() {
+		var usersPage models.UsersPage
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+"?estimateTotal=true", nil, &usersPage)
+
+		s.Require().True(usersPage.TotalEstimated)
+	})
+	s.Run("cursor not valid", func() {
+		var firstPage models.UsersPage
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+"?itemsPerPage=2", nil, &firstPage)
+
+		for _, queryParams := range []string{
+			"?cursor=not-a-cursor",
+			"?sorting=age&cursor=" + firstPage.NextCursor,
+			"?offset=2&cursor=" + firstPage.NextCursor,
+		} {
+			resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, nil)
+
+			s.Require().Equal(http.StatusBadRequest, resp.StatusCode, queryParams)
+		}
+	})
+	s.Run("page size and offset not valid", func() {
+		for _, queryParams := range []string{
+			"?itemsPerPage=-1",
+			"?itemsPerPage=0",
+			"?itemsPerPage=abc",
+			"?itemsPerPage=101",
+			"?offset=-1",
+			"?offset=abc",
+		} {
+			resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, nil)
+
+			s.Require().Equal(http.StatusBadRequest, resp.StatusCode, queryParams)
+		}
+	})
+}
+
+func (s *IntegrationTestSuite) TestUsersSearch() {
+	ctx := context.Background()
+
+	users := []models.RequestEnrich{
+		{Name: "Kate", Surname: "Mir"},
+		{Name: "Katey", Surname: "Kit"},
+		{Name: "Liza", Surname: "Duchess"},
+	}
+
+	for _, user := range users {
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, user, nil)
+	}
+
+	s.Run("search ranks users by relevance", func() {
+		var usersPage models.UsersPage
+
+		resp := s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+"?q=kate", nil, &usersPage)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(2, len(usersPage.Items))
+		s.Require().Equal("Kate", usersPage.Items[0].Name)
+		s.Require().Equal("Katey", usersPage.Items[1].Name)
+		s.Require().Greater(usersPage.Items[0].Score, usersPage.Items[1].Score)
+	})
+	s.Run("search tolerates typos", func() {
+		var usersPage models.UsersPage
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+"?q=Duches", nil, &usersPage)
+
+		s.Require().Equal(1, len(usersPage.Items))
+		s.Require().Equal("Liza", usersPage.Items[0].Name)
+		s.Require().Positive(usersPage.Items[0].Score)
+	})
+	s.Run("search results are paginated", func() {
+		var firstPage, secondPage models.UsersPage
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+"?q=kate&itemsPerPage=1", nil, &firstPage)
+
+		s.Require().Equal(int64(2), firstPage.Total)
+
+		queryParams := "?q=kate&itemsPerPage=1&cursor=" + firstPage.NextCursor
+		_ = s.sendRequest(ctx, http.MethodGet, url+usersListEndpoint+queryParams, nil, &secondPage)
+
+		s.Require().Equal(1, len(secondPage.Items))
+		s.Require().Equal("Katey", secondPage.Items[0].Name)
+	})
+}
+
+func (s *IntegrationTestSuite) TestSimilarUsers() {
+	ctx := context.Background()
+
+	for _, userName := range []string{"Katherine", "Catherine", "Liza"} {
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, models.RequestEnrich{Name: userName}, nil)
+	}
+
+	s.Run("find users with similarly sounding names", func() {
+		var users []models.ResponseEnrich
+
+		resp := s.sendRequest(ctx, http.MethodGet, url+similarEndpoint+"?name=Kathryn", nil, &users)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(2, len(users))
+		s.Require().Equal("Catherine", users[0].Name)
+		s.Require().Equal("Katherine", users[1].Name)
+
+		resp = s.sendRequest(ctx, http.MethodGet, url+similarEndpoint+"?name=Катерина", nil, &users)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(2, len(users))
+	})
+	s.Run("find similar users without name", func() {
+		resp := s.sendRequest(ctx, http.MethodGet, url+similarEndpoint, nil, nil)
+
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func (s *IntegrationTestSuite) TestUsersExport() {
+	ctx := context.Background()
+
+	for _, userName := range []string{"Liza", "Kate", "Anna"} {
+		_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, models.RequestEnrich{Name: userName}, nil)
+	}
+
+	download := func(query string) (*http.Response, string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+exportEndpoint+query, nil)
+		s.Require().NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+
+		defer func() {
+			err = resp.Body.Close()
+			s.Require().NoError(err)
+		}()
+
+		body, err := io.ReadAll(resp.Body)
+		s.Require().NoError(err)
+
+		return resp, string(body)
+	}
+
+	s.Run("export users as csv", func() {
+		resp, body := download("?sort=-name")
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Contains(resp.Header.Get("Content-Type"), "text/csv")
+		s.Require().Contains(resp.Header.Get("Content-Disposition"), "attachment")
+
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		s.Require().NoError(err)
+		s.Require().Equal(4, len(records))
+		s.Require().Equal("id", records[0][0])
+		s.Require().Equal("Liza", records[1][1])
+		s.Require().Equal("Anna", records[3][1])
+	})
+	s.Run("export filtered users as ndjson", func() {
+		resp, body := download("?format=ndjson&textFilter=a")
 
 		s.Require().Equal(http.StatusOK, resp.StatusCode)
-		s.Require().Equal(2, len(respData))
+		s.Require().Equal("application/x-ndjson", resp.Header.Get("Content-Type"))
+
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		s.Require().Equal(3, len(lines))
+
+		var user models.ResponseEnrich
+
+		s.Require().NoError(json.Unmarshal([]byte(lines[0]), &user))
+		s.Require().Equal("Anna", user.Name)
+	})
+	s.Run("export with unknown format or pagination", func() {
+		resp, _ := download("?format=xlsx")
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = download("?offset=10")
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func (s *IntegrationTestSuite) TestUsersImport() {
+	ctx := context.Background()
+
+	_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, models.RequestEnrich{Name: "Kate"}, nil)
+
+	upload := func(query, file string) (*http.Response, models.ImportReport) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+importEndpoint+query, strings.NewReader(file))
+		s.Require().NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+
+		defer func() {
+			err = resp.Body.Close()
+			s.Require().NoError(err)
+		}()
+
+		var report models.ImportReport
+
+		if resp.StatusCode == http.StatusOK {
+			s.Require().NoError(json.NewDecoder(resp.Body).Decode(&report))
+		}
+
+		return resp, report
+	}
+
+	s.Run("import csv with a per-row report", func() {
+		resp, report := upload("", "name,surname,age,gender\n"+
+			"Liza,Duchess,25,female\n"+
+			"Kate,,40,\n"+
+			",Nameless,30,male\n"+
+			"Anna,,20,unknown\n"+
+			"liza,duchess,26,female\n")
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(1, report.Inserted)
+		s.Require().Equal(1, report.Updated)
+		s.Require().Equal(3, report.Rejected)
+		s.Require().Equal(models.ImportInserted, report.Rows[0].Status)
+		s.Require().Equal(models.ImportUpdated, report.Rows[1].Status)
+		s.Require().Equal("name is missing", report.Rows[2].Error)
+		s.Require().Equal("gender must be male or female", report.Rows[3].Error)
+		s.Require().Contains(report.Rows[4].Error, "line 2")
+
+		var user models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+userEndpoint+report.Rows[1].ID, nil, &user)
+
+		s.Require().Equal(40, user.Age)
+		s.Require().True(user.Provenance[models.FieldAge].Manual)
+		s.Require().Equal(models.SourceImport, user.Provenance[models.FieldAge].Source)
+	})
+	s.Run("import ndjson enriching the missing values", func() {
+		resp, report := upload("?format=ndjson&enrich=true", `{"name":"Liza","surname":"Kit","age":25}`+"\n")
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(1, report.Inserted)
+
+		var user models.ResponseEnrich
+
+		_ = s.sendRequest(ctx, http.MethodGet, url+userEndpoint+report.Rows[0].ID, nil, &user)
+
+		s.Require().Equal(25, user.Age)
+		s.Require().NotEmpty(user.Gender)
+		s.Require().Equal("senior", user.Attributes["grade"])
+	})
+	s.Run("import with unknown format or header", func() {
+		resp, _ := upload("?format=xlsx", "name\nLiza\n")
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = upload("", "salary\n100\n")
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func (s *IntegrationTestSuite) TestJobs() {
+	ctx := context.Background()
+
+	var job models.Job
+
+	resp := s.sendRequest(ctx, http.MethodPost, url+jobsEndpoint, models.RequestJob{
+		Items: []models.RequestEnrich{{Name: "Liza"}, {FullName: "Kate Kit"}},
+	}, &job)
+
+	s.Require().Equal(http.StatusAccepted, resp.StatusCode)
+	s.Require().Equal(jobsEndpoint+"/"+job.ID, resp.Header.Get("Location"))
+	s.Require().Equal(2, job.Total)
+
+	s.Run("job is enriched in the background", func() {
+		s.Require().Eventually(func() bool {
+			_ = s.sendRequest(ctx, http.MethodGet, url+jobsEndpoint+"/"+job.ID, nil, &job)
+
+			return job.Status == models.JobCompleted
+		}, 10*time.Second, 100*time.Millisecond)
+
+		s.Require().Equal(2, job.Done)
+		s.Require().Equal(2, len(job.Items))
+		s.Require().Equal("Liza", job.Items[0].User.Name)
+		s.Require().Equal("senior", job.Items[0].User.Attributes["grade"])
+		s.Require().Equal("Kit", job.Items[1].User.Surname)
+
+		var user models.ResponseEnrich
+
+		resp := s.sendRequest(ctx, http.MethodGet, url+userEndpoint+job.Items[0].User.ID, nil, &user)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+	})
+	s.Run("job without names is not valid", func() {
+		resp := s.sendRequest(ctx, http.MethodPost, url+jobsEndpoint, models.RequestJob{}, nil)
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+
+		resp = s.sendRequest(ctx, http.MethodPost, url+jobsEndpoint, models.RequestJob{
+			Items: []models.RequestEnrich{{Surname: "Kit"}},
+		}, nil)
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+	s.Run("too large job is rejected", func() {
+		resp := s.sendRequest(ctx, http.MethodPost, url+jobsEndpoint, models.RequestJob{
+			Items: []models.RequestEnrich{{Name: strings.Repeat("a", 5<<20)}},
+		}, nil)
+		s.Require().Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+	s.Run("unknown job is not found", func() {
+		resp := s.sendRequest(ctx, http.MethodGet, url+jobsEndpoint+"/"+uuid.NewString(), nil, nil)
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+
+		resp = s.sendRequest(ctx, http.MethodGet, url+jobsEndpoint+"/42", nil, nil)
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func (s *IntegrationTestSuite) TestJobFile() {
+	ctx := context.Background()
+
+	upload := func(file string, fields map[string]string) (*http.Response, models.Job) {
+		var body bytes.Buffer
+
+		form := multipart.NewWriter(&body)
+
+		for field, value := range fields {
+			s.Require().NoError(form.WriteField(field, value))
+		}
+
+		part, err := form.CreateFormFile("file", "names.csv")
+		s.Require().NoError(err)
+
+		_, err = io.WriteString(part, file)
+		s.Require().NoError(err)
+		s.Require().NoError(form.Close())
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+jobsEndpoint+"/upload", &body)
+		s.Require().NoError(err)
+
+		req.Header.Set("Content-Type", form.FormDataContentType())
+
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+
+		defer func() {
+			err = resp.Body.Close()
+			s.Require().NoError(err)
+		}()
+
+		var job models.Job
+
+		if resp.StatusCode == http.StatusAccepted {
+			s.Require().NoError(json.NewDecoder(resp.Body).Decode(&job))
+		}
+
+		return resp, job
+	}
+
+	resp, job := upload("First name,Team\nLiza,hr\n,it\n", map[string]string{"name_column": "first name"})
+
+	s.Require().Equal(http.StatusAccepted, resp.StatusCode)
+	s.Require().Equal("names.csv", job.FileName)
+	s.Require().Equal(2, job.Total)
+	s.Require().Equal(1, job.Failed)
+
+	s.Run("result is downloaded once the job is completed", func() {
+		s.Require().Eventually(func() bool {
+			_ = s.sendRequest(ctx, http.MethodGet, url+jobsEndpoint+"/"+job.ID, nil, &job)
+
+			return job.Status == models.JobCompleted
+		}, 10*time.Second, 100*time.Millisecond)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+jobsEndpoint+"/"+job.ID+"/result", nil)
+		s.Require().NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+
+		defer func() {
+			err = resp.Body.Close()
+			s.Require().NoError(err)
+		}()
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Contains(resp.Header.Get("Content-Disposition"), "names-enriched.csv")
+
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		s.Require().NoError(err)
+		s.Require().Equal([]string{"First name", "Team", "age", "gender", "country", "error"}, records[0])
+		s.Require().Equal("Liza", records[1][0])
+		s.Require().NotEmpty(records[1][3])
+		s.Require().Equal("name is missing", records[2][5])
+	})
+	s.Run("file without the mapped column is not valid", func() {
+		resp, _ := upload("name\nLiza\n", map[string]string{"surname_column": "last name"})
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func (s *IntegrationTestSuite) TestRefresh() {
+	ctx := context.Background()
+
+	var user models.ResponseEnrich
+
+	_ = s.sendRequest(ctx, http.MethodPost, url+enrichNameEndpoint, models.RequestEnrich{Name: "Liza"}, &user)
+	_ = s.sendRequest(ctx, http.MethodPatch, url+userEndpoint+user.ID, models.ResponseEnrich{Age: 99}, nil)
+
+	hrGrades["Liza"] = "lead"
+	defer func() { hrGrades["Liza"] = "senior" }()
+
+	s.Require().NoError(s.service.RefreshStaleUsers(ctx))
+
+	var runs []models.RefreshRun
+
+	resp := s.sendRequest(ctx, http.MethodGet, url+refreshRunsEndpoint, nil, &runs)
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Require().Equal(1, len(runs))
+	s.Require().Equal(1, runs[0].Checked)
+	s.Require().Equal(1, runs[0].Updated)
+
+	s.Run("run returns the changed values", func() {
+		var run models.RefreshRun
+
+		resp := s.sendRequest(ctx, http.MethodGet, url+refreshRunsEndpoint+"/"+runs[0].ID, nil, &run)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		fields := make(map[string]models.RefreshChange)
+		for _, change := range run.Changes {
+			fields[change.Field] = change
+		}
+
+		s.Require().NotContains(fields, models.FieldAge)
+		s.Require().Equal("senior", fields["grade"].OldValue)
+		s.Require().Equal("lead", fields["grade"].NewValue)
+	})
+	s.Run("manually set values are kept", func() {
+		resp := s.sendRequest(ctx, http.MethodGet, url+userEndpoint+user.ID, nil, &user)
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().Equal(99, user.Age)
+		s.Require().Equal("lead", user.Attributes["grade"])
+	})
+	s.Run("unknown run is not found", func() {
+		resp := s.sendRequest(ctx, http.MethodGet, url+refreshRunsEndpoint+"/"+uuid.NewString(), nil, nil)
+		s.Require().Equal(http.StatusNotFound, resp.StatusCode)
+
+		resp = s.sendRequest(ctx, http.MethodGet, url+refreshRunsEndpoint+"?limit=0", nil, nil)
+		s.Require().Equal(http.StatusBadRequest, resp.StatusCode)
 	})
 }
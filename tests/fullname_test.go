@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/AlexZav1327/name-enricher/internal/fullname"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFullName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fullName string
+		want     models.ParsedName
+		wantErr  error
+	}{
+		{
+			name:     "western first middle last",
+			fullName: "John Fitzgerald Kennedy",
+			want:     models.ParsedName{Name: "John", Surname: "Kennedy", Patronymic: "Fitzgerald", Confidence: 0.95},
+		},
+		{
+			name:     "russian surname name patronymic",
+			fullName: "Ivanov Ivan Ivanovich",
+			want:     models.ParsedName{Name: "Ivan", Surname: "Ivanov", Patronymic: "Ivanovich", Confidence: 0.95},
+		},
+		{
+			name:     "russian name patronymic surname in cyrillic",
+			fullName: "Анна Сергеевна Каренина",
+			want:     models.ParsedName{Name: "Анна", Surname: "Каренина", Patronymic: "Сергеевна", Confidence: 0.95},
+		},
+		{
+			name:     "surname particles",
+			fullName: "Johannes Diderik van der Waals",
+			want:     models.ParsedName{Name: "Johannes", Surname: "van der Waals", Patronymic: "Diderik", Confidence: 0.8},
+		},
+		{
+			name:     "comma separated surname first",
+			fullName: "Duchess, Liza",
+			want:     models.ParsedName{Name: "Liza", Surname: "Duchess", Confidence: 0.95},
+		},
+		{
+			name:     "single name",
+			fullName: "Liza",
+			want:     models.ParsedName{Name: "Liza", Confidence: 0.5},
+		},
+		{
+			name:     "empty full name",
+			fullName: "  ",
+			wantErr:  models.ErrFullNameNotValid,
+		},
+		{
+			name:     "digits in full name",
+			fullName: "Liza 123",
+			wantErr:  models.ErrFullNameNotValid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := fullname.Parse(tc.fullName)
+			require.ErrorIs(t, err, tc.wantErr)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
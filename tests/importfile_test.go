@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AlexZav1327/name-enricher/internal/importfile"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadImportFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		format  string
+		file    string
+		want    []models.ImportRecord
+		wantErr error
+	}{
+		{
+			name:   "csv with known values",
+			format: importfile.FormatCSV,
+			file: "\ufeffName,surname,age,gender,attributes\n" +
+				"Liza,Duchess,25,female,\"{\"\"grade\"\":\"\"senior\"\"}\"\n" +
+				" Kate ,,,,\n",
+			want: []models.ImportRecord{
+				{Line: 2, User: models.ResponseEnrich{
+					RequestEnrich: models.RequestEnrich{Name: "Liza", Surname: "Duchess"},
+					Age:           25,
+					Gender:        "female",
+					Attributes:    map[string]interface{}{"grade": "senior"},
+				}},
+				{Line: 3, User: models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Kate"}}},
+			},
+		},
+		{
+			name:   "csv export columns are ignored",
+			format: importfile.FormatCSV,
+			file:   "id,name,created_at\n1,Liza,2024-04-15T09:00:00Z\n",
+			want: []models.ImportRecord{
+				{Line: 2, User: models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Liza"}}},
+			},
+		},
+		{
+			name:   "csv rows that cannot be read",
+			format: importfile.FormatCSV,
+			file:   "full_name,age\nDuchess Liza,old\nKate\n",
+			want: []models.ImportRecord{
+				{Line: 2, User: models.ResponseEnrich{RequestEnrich: models.RequestEnrich{FullName: "Duchess Liza"}},
+					Error: "age must be an integer"},
+				{Line: 3, Error: "wrong number of fields"},
+			},
+		},
+		{
+			name:    "csv without a name column",
+			format:  importfile.FormatCSV,
+			file:    "surname,age\nDuchess,25\n",
+			wantErr: importfile.ErrHeader,
+		},
+		{
+			name:    "csv with an unknown column",
+			format:  importfile.FormatCSV,
+			file:    "name,salary\nLiza,100\n",
+			wantErr: importfile.ErrHeader,
+		},
+		{
+			name:   "ndjson",
+			format: importfile.FormatNDJSON,
+			file:   `{"name":"Liza","age":25,"id":"ignored"}` + "\n\n" + `{"name":"Kate","age":"old"}` + "\n{Anna}\n",
+			want: []models.ImportRecord{
+				{Line: 1, User: models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Liza"}, Age: 25}},
+				{Line: 3, Error: "age has a wrong type"},
+				{Line: 4, Error: "line is not valid JSON"},
+			},
+		},
+		{
+			name:    "unknown format",
+			format:  "xlsx",
+			file:    "name\nLiza\n",
+			wantErr: importfile.ErrFormat,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := importfile.Read(strings.NewReader(tc.file), tc.format)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.want, records)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AlexZav1327/name-enricher/internal/jobfile"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadJobFile(t *testing.T) {
+	testCases := []struct {
+		name       string
+		columns    jobfile.Columns
+		file       string
+		wantHeader []string
+		want       []models.JobItem
+		wantErr    error
+	}{
+		{
+			name:       "default columns",
+			file:       "\ufeffName,Surname,Team\n Liza ,Duchess,hr\n,,\nKate\n",
+			wantHeader: []string{"Name", "Surname", "Team"},
+			want: []models.JobItem{
+				{
+					Request: models.RequestEnrich{Name: "Liza", Surname: "Duchess"},
+					Cells:   []string{" Liza ", "Duchess", "hr"},
+				},
+				{Request: models.RequestEnrich{Name: "Kate"}, Cells: []string{"Kate", "", ""}},
+			},
+		},
+		{
+			name:       "mapped columns",
+			columns:    jobfile.Columns{Name: "First name", Surname: "last name"},
+			file:       "last name,first name,surname\nDuchess,Liza,ignored\n",
+			wantHeader: []string{"last name", "first name", "surname"},
+			want: []models.JobItem{
+				{
+					Request: models.RequestEnrich{Name: "Liza", Surname: "Duchess"},
+					Cells:   []string{"Duchess", "Liza", "ignored"},
+				},
+			},
+		},
+		{
+			name:    "mapped column is missing",
+			columns: jobfile.Columns{Patronymic: "middle name"},
+			file:    "name\nLiza\n",
+			wantErr: jobfile.ErrHeader,
+		},
+		{
+			name:    "name column is missing",
+			file:    "first name\nLiza\n",
+			wantErr: jobfile.ErrHeader,
+		},
+		{
+			name:    "row longer than the header",
+			file:    "name\nLiza,Duchess\n",
+			wantErr: jobfile.ErrRow,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header, items, err := jobfile.Read(strings.NewReader(tc.file), tc.columns)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.wantHeader, header)
+			require.Equal(t, tc.want, items)
+		})
+	}
+}
+
+func TestWriteJobFile(t *testing.T) {
+	user := &models.ResponseEnrich{Age: 31, Gender: "female", Country: "CL"}
+
+	testCases := []struct {
+		name string
+		job  models.Job
+		want string
+	}{
+		{
+			name: "uploaded file keeps its columns",
+			job: models.Job{
+				Header: []string{"name", "Country"},
+				Items: []models.JobItem{
+					{Status: models.JobItemDone, User: user, Cells: []string{"Kate", "??"}},
+					{Status: models.JobItemFailed, Error: "name is not valid", Cells: []string{"Xyz", ""}},
+				},
+			},
+			want: "name,Country,age,gender,error\n" +
+				"Kate,CL,31,female,\n" +
+				"Xyz,,,,name is not valid\n",
+		},
+		{
+			name: "job without a file",
+			job: models.Job{
+				Items: []models.JobItem{
+					{Status: models.JobItemDone, User: user, Request: models.RequestEnrich{FullName: "Kate Kit"}},
+				},
+			},
+			want: "name,surname,patronymic,full_name,age,gender,country,error\n" +
+				",,,Kate Kit,31,female,CL,\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+
+			require.NoError(t, jobfile.Write(&b, tc.job))
+			require.Equal(t, tc.want, b.String())
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AlexZav1327/name-enricher/internal/storage"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	lite, err := storage.ConnectSQLite(context.Background(), storage.SQLiteScheme+":memory:", logrus.StandardLogger())
+	require.NoError(t, err)
+
+	defer lite.Close()
+
+	statuses, err := lite.MigrationStatus()
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	require.ErrorIs(t, storage.CheckSchema(statuses), storage.ErrSchemaBehind)
+
+	applied, err := lite.MigrateMax(migrate.Up, 0)
+	require.NoError(t, err)
+	require.Equal(t, len(statuses), applied)
+
+	statuses, err = lite.MigrationStatus()
+	require.NoError(t, err)
+	require.NoError(t, storage.CheckSchema(statuses))
+
+	rolledBack, err := lite.MigrateMax(migrate.Down, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, rolledBack)
+
+	statuses, err = lite.MigrationStatus()
+	require.NoError(t, err)
+	require.Nil(t, statuses[len(statuses)-1].AppliedAt)
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/service"
+	"github.com/AlexZav1327/name-enricher/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// countriesResolver predicts the countries of the names it has and knows nothing of the others.
+type countriesResolver map[string][]models.CountryEnriched
+
+func (r countriesResolver) Resolve(_ context.Context, name string, dest interface{}) error {
+	countries, ok := r[name]
+	if !ok {
+		return models.ErrNameNotValid
+	}
+
+	*dest.(*[]models.CountryEnriched) = countries
+
+	return nil
+}
+
+func (countriesResolver) Source() string {
+	return "test"
+}
+
+func TestCombineCountries(t *testing.T) {
+	testCases := []struct {
+		name             string
+		nameCountries    []models.CountryEnriched
+		surnameCountries []models.CountryEnriched
+		surnameWeight    float64
+		want             []models.CountryScore
+	}{
+		{
+			name:          "without surname countries the name ones are kept",
+			nameCountries: []models.CountryEnriched{{CountryID: "RU", Probability: 0.75}, {CountryID: "UA", Probability: 0.25}},
+			surnameWeight: 0.5,
+			want: []models.CountryScore{
+				{CountryID: "RU", Probability: 0.75, NameContribution: 0.75},
+				{CountryID: "UA", Probability: 0.25, NameContribution: 0.25},
+			},
+		},
+		{
+			name:             "shared country sums the weighted contributions",
+			nameCountries:    []models.CountryEnriched{{CountryID: "DE", Probability: 0.5}},
+			surnameCountries: []models.CountryEnriched{{CountryID: "DE", Probability: 1}},
+			surnameWeight:    0.25,
+			want: []models.CountryScore{
+				{CountryID: "DE", Probability: 0.625, NameContribution: 0.375, SurnameContribution: 0.25},
+			},
+		},
+		{
+			name:             "surname outweighs the name",
+			nameCountries:    []models.CountryEnriched{{CountryID: "US", Probability: 0.5}},
+			surnameCountries: []models.CountryEnriched{{CountryID: "IE", Probability: 1}},
+			surnameWeight:    0.75,
+			want: []models.CountryScore{
+				{CountryID: "IE", Probability: 0.75, SurnameContribution: 0.75},
+				{CountryID: "US", Probability: 0.125, NameContribution: 0.125},
+			},
+		},
+		{
+			name:             "equal probabilities are ranked by country",
+			nameCountries:    []models.CountryEnriched{{CountryID: "FR", Probability: 0.5}},
+			surnameCountries: []models.CountryEnriched{{CountryID: "BE", Probability: 0.5}},
+			surnameWeight:    0.5,
+			want: []models.CountryScore{
+				{CountryID: "BE", Probability: 0.25, SurnameContribution: 0.25},
+				{CountryID: "FR", Probability: 0.25, NameContribution: 0.25},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolvers, _ := newTestResolvers()
+			resolvers.Country = countriesResolver{"Anna": tc.nameCountries}
+
+			if tc.surnameCountries != nil {
+				resolvers.Country = countriesResolver{"Anna": tc.nameCountries, "Smith": tc.surnameCountries}
+			}
+
+			enricher := service.New(storage.NewMemory(), resolvers, service.Config{
+				SurnameNationality: true,
+				SurnameWeight:      tc.surnameWeight,
+			}, logrus.StandardLogger())
+
+			user, err := enricher.EnrichUser(context.Background(), models.RequestEnrich{Name: "Anna", Surname: "Smith"})
+			require.NoError(t, err)
+			require.Equal(t, tc.want, user.Countries)
+			require.Equal(t, tc.want[0].CountryID, user.Country)
+		})
+	}
+}
+
+func TestConfigSurnameWeight(t *testing.T) {
+	for _, weight := range []float64{0, 0.5, 1} {
+		require.NoError(t, service.Config{SurnameWeight: weight}.Validate(), weight)
+	}
+
+	for _, weight := range []float64{-0.1, 1.5} {
+		require.ErrorIs(t, service.Config{SurnameWeight: weight}.Validate(), service.ErrConfigNotValid, weight)
+	}
+}
@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/AlexZav1327/name-enricher/internal/phonetic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhoneticKeys(t *testing.T) {
+	testCases := []struct {
+		name          string
+		userName      string
+		wantPrimary   string
+		wantAlternate string
+	}{
+		{name: "katherine", userName: "Katherine", wantPrimary: "K0RN", wantAlternate: "KTRN"},
+		{name: "catherine", userName: "Catherine", wantPrimary: "K0RN", wantAlternate: "KTRN"},
+		{name: "kathryn", userName: "Kathryn", wantPrimary: "K0RN", wantAlternate: "KTRN"},
+		{name: "cyrillic", userName: "Катерина", wantPrimary: "KTRN", wantAlternate: "KTRN"},
+		{name: "cyrillic sounds like latin", userName: "Алекс", wantPrimary: "ALKS", wantAlternate: "ALKS"},
+		{name: "no letters", userName: "123"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			primary, alternate := phonetic.Keys(tc.userName)
+			require.Equal(t, tc.wantPrimary, primary)
+			require.Equal(t, tc.wantAlternate, alternate)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/provider"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderResolve(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") != "Liza" || r.Header.Get("X-Token") != "secret" {
+			_, _ = w.Write([]byte(`{"employee":{"grades":[]}}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"employee":{"grades":[{"level":"senior"}]}}`))
+	}))
+	defer ts.Close()
+
+	p, err := provider.New(provider.Config{
+		Name:        "hr",
+		URL:         ts.URL + "/employees",
+		QueryParams: map[string]string{"name": "{{.Name}}"},
+		Headers:     map[string]string{"X-Token": "secret"},
+		ValuePath:   "employee.grades.0.level",
+		Metric:      "hr_test_enrich_duration",
+	}, logrus.StandardLogger())
+	require.NoError(t, err)
+
+	var level string
+
+	err = p.Resolve(context.Background(), "Liza", &level)
+	require.NoError(t, err)
+	require.Equal(t, "senior", level)
+
+	err = p.Resolve(context.Background(), "Noname", &level)
+	require.ErrorIs(t, err, models.ErrNameNotValid)
+}
+
+func TestProviderResolveCounted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"count":3,"name":"Kathryn","age":41}`))
+	}))
+	defer ts.Close()
+
+	p, err := provider.New(provider.Config{
+		Name:        "age",
+		URL:         ts.URL,
+		QueryParams: map[string]string{"name": "{{.Name}}"},
+		ValuePath:   "age",
+		CountPath:   "count",
+		Metric:      "age_counted_test_enrich_duration",
+	}, logrus.StandardLogger())
+	require.NoError(t, err)
+
+	var age int
+
+	count, err := p.ResolveCounted(context.Background(), "Kathryn", &age)
+	require.NoError(t, err)
+	require.Equal(t, 41, age)
+	require.Equal(t, 3, count)
+}
+
+func TestProviderQuotaExceeded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":"Request limit reached"}`))
+	}))
+	defer ts.Close()
+
+	p, err := provider.New(provider.Config{
+		Name:        "age",
+		URL:         ts.URL,
+		QueryParams: map[string]string{"name": "{{.Name}}"},
+		ValuePath:   "age",
+		Metric:      "age_quota_test_enrich_duration",
+	}, logrus.StandardLogger())
+	require.NoError(t, err)
+
+	var age int
+
+	err = p.Resolve(context.Background(), "Kathryn", &age)
+	require.ErrorIs(t, err, models.ErrQuotaExceeded)
+}
+
+func TestProviderRequestFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(`<html><body>502 Bad Gateway</body></html>`))
+	}))
+	defer ts.Close()
+
+	p, err := provider.New(provider.Config{
+		Name:        "age",
+		URL:         ts.URL,
+		QueryParams: map[string]string{"name": "{{.Name}}"},
+		ValuePath:   "age",
+		Metric:      "age_failed_test_enrich_duration",
+	}, logrus.StandardLogger())
+	require.NoError(t, err)
+
+	var age int
+
+	err = p.Resolve(context.Background(), "Kathryn", &age)
+	require.ErrorIs(t, err, models.ErrProviderFailed)
+	require.NotErrorIs(t, err, models.ErrNameNotValid)
+}
+
+func TestProviderConfigNotValid(t *testing.T) {
+	_, err := provider.NewSet([]provider.Config{
+		{Name: "hr", URL: "http://localhost", ValuePath: "level", Metric: "hr_dup_enrich_duration"},
+		{Name: "hr", URL: "http://localhost", ValuePath: "level", Metric: "hr_dup_enrich_duration"},
+	}, logrus.StandardLogger())
+	require.ErrorIs(t, err, provider.ErrConfigNotValid)
+
+	_, err = provider.New(provider.Config{Name: "hr", URL: "http://localhost", ValuePath: "level",
+		Metric: "hr_rule_enrich_duration", NotFound: "never"}, logrus.StandardLogger())
+	require.ErrorIs(t, err, provider.ErrConfigNotValid)
+}
@@ -0,0 +1,339 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/provider"
+	"github.com/AlexZav1327/name-enricher/internal/service"
+	"github.com/AlexZav1327/name-enricher/internal/storage"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errStoreDown    = errors.New("store is down")
+	errProviderDown = errors.New("provider is down")
+)
+
+// failingStore fails the method named failing with errStoreDown.
+type failingStore struct {
+	service.Store
+	failing string
+}
+
+func (s failingStore) GetUserByIdentity(ctx context.Context, userName models.RequestEnrich) (
+	models.ResponseEnrich, error,
+) {
+	if s.failing == "GetUserByIdentity" {
+		return models.ResponseEnrich{}, errStoreDown
+	}
+
+	return s.Store.GetUserByIdentity(ctx, userName)
+}
+
+func (s failingStore) FindUsersByName(ctx context.Context, userName string) ([]models.ResponseEnrich, error) {
+	if s.failing == "FindUsersByName" {
+		return nil, errStoreDown
+	}
+
+	return s.Store.FindUsersByName(ctx, userName)
+}
+
+// countingResolver resolves every name to value and counts the requests.
+type countingResolver struct {
+	value    interface{}
+	requests atomic.Int32
+}
+
+func (r *countingResolver) Resolve(_ context.Context, _ string, dest interface{}) error {
+	r.requests.Add(1)
+
+	switch dest := dest.(type) {
+	case *int:
+		*dest, _ = r.value.(int)
+	case *string:
+		*dest, _ = r.value.(string)
+	case *[]models.CountryEnriched:
+		*dest = []models.CountryEnriched{{CountryID: r.value.(string), Probability: 1}}
+	}
+
+	return nil
+}
+
+func (*countingResolver) Source() string {
+	return "test"
+}
+
+// failingResolver fails every request with errProviderDown.
+type failingResolver struct{}
+
+func (failingResolver) Resolve(context.Context, string, interface{}) error {
+	return errProviderDown
+}
+
+func (failingResolver) Source() string {
+	return "test"
+}
+
+// countedResolver is a countingResolver that reports count samples for every prediction.
+type countedResolver struct {
+	*countingResolver
+	count int
+}
+
+func (r countedResolver) ResolveCounted(ctx context.Context, name string, dest interface{}) (int, error) {
+	return r.count, r.Resolve(ctx, name, dest)
+}
+
+func newTestResolvers() (service.Resolvers, []*countingResolver) {
+	age, gender, country := &countingResolver{value: 30}, &countingResolver{value: "female"},
+		&countingResolver{value: "DE"}
+
+	return service.Resolvers{Age: age, Gender: gender, Country: country}, []*countingResolver{age, gender, country}
+}
+
+func TestEnrichUserStoreError(t *testing.T) {
+	for _, failing := range []string{"GetUserByIdentity", "FindUsersByName"} {
+		t.Run(failing, func(t *testing.T) {
+			resolvers, counters := newTestResolvers()
+			enricher := service.New(failingStore{Store: storage.NewMemory(), failing: failing}, resolvers,
+				service.Config{}, logrus.StandardLogger())
+
+			_, err := enricher.EnrichUser(context.Background(), models.RequestEnrich{Name: "Liza"})
+			require.ErrorIs(t, err, errStoreDown)
+
+			for _, counter := range counters {
+				require.Zero(t, counter.requests.Load())
+			}
+		})
+	}
+
+	t.Run("unknown user is enriched", func(t *testing.T) {
+		resolvers, counters := newTestResolvers()
+		enricher := service.New(storage.NewMemory(), resolvers, service.Config{}, logrus.StandardLogger())
+
+		user, err := enricher.EnrichUser(context.Background(), models.RequestEnrich{Name: "Liza"})
+		require.NoError(t, err)
+		require.Equal(t, 30, user.Age)
+
+		for _, counter := range counters {
+			require.Equal(t, int32(1), counter.requests.Load())
+		}
+	})
+}
+
+func TestRunJobsRetryBackoff(t *testing.T) {
+	resolvers := service.Resolvers{Age: failingResolver{}, Gender: failingResolver{}, Country: failingResolver{}}
+
+	for _, tc := range []struct {
+		name     string
+		backoff  time.Duration
+		status   string
+		attempts int
+	}{
+		{name: "failed item is retried at once without backoff", status: models.JobItemFailed, attempts: 3},
+		{name: "failed item waits for its retry", backoff: time.Hour, status: models.JobItemPending, attempts: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			enricher := service.New(storage.NewMemory(), resolvers, service.Config{
+				JobWorkers:      1,
+				JobPollInterval: 10 * time.Millisecond,
+				JobRetryBackoff: tc.backoff,
+			}, logrus.StandardLogger())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				enricher.RunJobs(ctx)
+			}()
+
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			job, err := enricher.SubmitJob(ctx, []models.RequestEnrich{{Name: "Liza"}})
+			require.NoError(t, err)
+
+			require.Eventually(t, func() bool {
+				job, err = enricher.GetJob(ctx, job.ID)
+				require.NoError(t, err)
+
+				return job.Items[0].Status == tc.status && job.Items[0].Attempts == tc.attempts
+			}, time.Second, 10*time.Millisecond)
+
+			// Polls go on while the item waits for its retry.
+			time.Sleep(100 * time.Millisecond)
+
+			job, err = enricher.GetJob(ctx, job.ID)
+			require.NoError(t, err)
+			require.Equal(t, tc.status, job.Items[0].Status)
+			require.Equal(t, tc.attempts, job.Items[0].Attempts)
+		})
+	}
+}
+
+func TestRefreshStaleUsersProviderError(t *testing.T) {
+	var failing atomic.Bool
+
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && r.URL.Query().Get("name") == "Broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"age":30,"gender":"female","country":[{"country_id":"DE","probability":0.5}]}`))
+	}))
+	defer providerServer.Close()
+
+	providers := make(provider.Set)
+
+	for _, name := range []string{"age", "gender", "country"} {
+		p, err := provider.New(provider.Config{
+			Name:        name,
+			URL:         providerServer.URL,
+			QueryParams: map[string]string{"name": "{{.Name}}"},
+			ValuePath:   name,
+			Metric:      "refresh_" + name + "_enrich_duration",
+		}, logrus.StandardLogger())
+		require.NoError(t, err)
+
+		providers[name] = p
+	}
+
+	ctx := context.Background()
+	enricher := service.New(storage.NewMemory(), service.Resolvers{
+		Age:     providers["age"],
+		Gender:  providers["gender"],
+		Country: providers["country"],
+	}, service.Config{RefreshMaxAge: time.Nanosecond, RefreshBatchSize: 1}, logrus.StandardLogger())
+
+	broken, err := enricher.EnrichUser(ctx, models.RequestEnrich{Name: "Broken"})
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond)
+
+	_, err = enricher.EnrichUser(ctx, models.RequestEnrich{Name: "Liza"})
+	require.NoError(t, err)
+
+	failing.Store(true)
+
+	// The stalest user fails first; the next run goes on to the other user instead of retrying it.
+	require.NoError(t, enricher.RefreshStaleUsers(ctx))
+	require.NoError(t, enricher.RefreshStaleUsers(ctx))
+
+	runs, err := enricher.GetRefreshRuns(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(runs))
+	require.Equal(t, 1, runs[1].Failed)
+	require.Equal(t, 1, runs[0].Checked)
+	require.Zero(t, runs[0].Failed)
+
+	user, err := enricher.GetUser(ctx, broken.ID)
+	require.NoError(t, err)
+	require.Equal(t, 30, user.Age)
+}
+
+func TestEnrichUserLockedNamesake(t *testing.T) {
+	ctx := context.Background()
+	resolvers, counters := newTestResolvers()
+	enricher := service.New(storage.NewMemory(), resolvers, service.Config{}, logrus.StandardLogger())
+
+	kate, err := enricher.EnrichUser(ctx, models.RequestEnrich{Name: "Kate", Surname: "Kit"})
+	require.NoError(t, err)
+
+	kate.Locks = map[string]bool{models.FieldGender: true}
+
+	_, err = enricher.UpdateUser(ctx, kate)
+	require.NoError(t, err)
+
+	namesake, err := enricher.EnrichUser(ctx, models.RequestEnrich{Name: "Kate", Surname: "Cat"})
+	require.NoError(t, err)
+
+	for field, p := range namesake.Provenance {
+		require.False(t, p.Locked, field)
+		require.False(t, p.Manual, field)
+	}
+
+	// The namesake with a locked value is not reused, so the providers are requested again.
+	for _, counter := range counters {
+		require.Equal(t, int32(2), counter.requests.Load())
+	}
+}
+
+func TestEnrichUserPhoneticReuse(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	age, gender, country := &countingResolver{value: 30}, &countingResolver{value: "female"},
+		&countingResolver{value: "DE"}
+
+	enricher := service.New(store, service.Resolvers{
+		Age:     countedResolver{countingResolver: age, count: 3},
+		Gender:  gender,
+		Country: country,
+	}, service.Config{PhoneticReuse: true, PhoneticMinCount: 10}, logrus.StandardLogger())
+
+	fetchedAt := time.Now().UTC()
+	countries := []models.CountryScore{{CountryID: "GB", Probability: 0.6}, {CountryID: "IE", Probability: 0.4}}
+
+	_, err := store.SaveUser(ctx, models.ResponseEnrich{
+		ID:            uuid.NewString(),
+		RequestEnrich: models.RequestEnrich{Name: "Katherine"},
+		Age:           41,
+		Gender:        "female",
+		Country:       "GB",
+		Countries:     countries,
+		Provenance: map[string]models.Provenance{
+			models.FieldAge:     {Source: "test", FetchedAt: fetchedAt},
+			models.FieldGender:  {Source: "test", FetchedAt: fetchedAt},
+			models.FieldCountry: {Source: "test", FetchedAt: fetchedAt},
+		},
+	})
+	require.NoError(t, err)
+
+	user, err := enricher.EnrichUser(ctx, models.RequestEnrich{Name: "Catherine"})
+	require.NoError(t, err)
+	require.Equal(t, "Catherine", user.Name)
+	require.Equal(t, 41, user.Age)
+	require.Equal(t, countries, user.Countries)
+
+	// Only the age is requested to learn its count.
+	require.Equal(t, int32(1), age.requests.Load())
+	require.Zero(t, gender.requests.Load())
+	require.Zero(t, country.requests.Load())
+}
+
+func TestFindUserID(t *testing.T) {
+	ctx := context.Background()
+	resolvers, _ := newTestResolvers()
+	enricher := service.New(storage.NewMemory(), resolvers, service.Config{}, logrus.StandardLogger())
+
+	for _, surname := range []string{"Ivanova", "Petrova"} {
+		_, err := enricher.EnrichUser(ctx, models.RequestEnrich{Name: "Anna", Surname: surname})
+		require.NoError(t, err)
+	}
+
+	_, err := enricher.FindUserID(ctx, "Anna")
+	require.ErrorIs(t, err, models.ErrUserAmbiguous)
+
+	anna, err := enricher.EnrichUser(ctx, models.RequestEnrich{Name: "Anna"})
+	require.NoError(t, err)
+
+	userID, err := enricher.FindUserID(ctx, " anna")
+	require.NoError(t, err)
+	require.Equal(t, anna.ID, userID)
+
+	_, err = enricher.FindUserID(ctx, "Olga")
+	require.ErrorIs(t, err, models.ErrUserNotFound)
+}
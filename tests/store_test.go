@@ -0,0 +1,967 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexZav1327/name-enricher/internal/audit"
+	"github.com/AlexZav1327/name-enricher/internal/models"
+	"github.com/AlexZav1327/name-enricher/internal/service"
+	"github.com/AlexZav1327/name-enricher/internal/storage"
+	"github.com/google/uuid"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+// StoreTestSuite checks that every implementation of service.Store behaves the same way.
+type StoreTestSuite struct {
+	suite.Suite
+	// newStore returns an empty store and the function that releases it.
+	newStore func() (service.Store, func())
+	store    service.Store
+	release  func()
+}
+
+func TestMemoryStore(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{newStore: func() (service.Store, func()) {
+		return storage.NewMemory(), func() {}
+	}})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	s := &StoreTestSuite{}
+	s.newStore = func() (service.Store, func()) {
+		lite, err := storage.ConnectSQLite(context.Background(), storage.SQLiteScheme+":memory:",
+			logrus.StandardLogger())
+		s.Require().NoError(err)
+
+		err = lite.Migrate(migrate.Up)
+		s.Require().NoError(err)
+
+		return lite, lite.Close
+	}
+
+	suite.Run(t, s)
+}
+
+func TestPostgresStore(t *testing.T) {
+	pg, err := storage.ConnectDB(context.Background(), storage.Config{DSN: dsn}, logrus.StandardLogger())
+	if err != nil {
+		t.Skipf("Postgres is not reachable: %s", err)
+	}
+
+	pg.Close()
+
+	s := &StoreTestSuite{}
+	s.newStore = func() (service.Store, func()) {
+		ctx := context.Background()
+
+		pg, err := storage.ConnectDB(ctx, storage.Config{DSN: dsn}, logrus.StandardLogger())
+		s.Require().NoError(err)
+
+		err = pg.Migrate(migrate.Up)
+		s.Require().NoError(err)
+
+		return pg, func() {
+			s.Require().NoError(pg.TruncateTable(ctx, "enriched_user"))
+			s.Require().NoError(pg.TruncateTable(ctx, "user_history"))
+			s.Require().NoError(pg.TruncateTable(ctx, "enrichment_job_item, enrichment_job"))
+			s.Require().NoError(pg.TruncateTable(ctx, "refresh_run"))
+			pg.Close()
+		}
+	}
+
+	suite.Run(t, s)
+}
+
+func (s *StoreTestSuite) SetupTest() {
+	s.store, s.release = s.newStore()
+}
+
+func (s *StoreTestSuite) TearDownTest() {
+	// release is not set when the store could not be made.
+	if s.release != nil {
+		s.release()
+	}
+
+	s.store, s.release = nil, nil
+}
+
+func (s *StoreTestSuite) saveUser(ctx context.Context, user models.ResponseEnrich) models.ResponseEnrich {
+	s.T().Helper()
+
+	if user.ID == "" {
+		user.ID = uuid.NewString()
+	}
+
+	savedUser, err := s.store.SaveUser(ctx, user)
+	s.Require().NoError(err)
+
+	return savedUser
+}
+
+func (s *StoreTestSuite) TestSaveUser() {
+	ctx := audit.WithMeta(context.Background(), audit.Meta{Actor: "hr-sync"})
+
+	user := s.saveUser(ctx, models.ResponseEnrich{
+		RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"},
+		Age:           31,
+		Gender:        "female",
+		Country:       "CL",
+		Attributes:    map[string]interface{}{"grade": "junior"},
+		Provenance:    map[string]models.Provenance{models.FieldAge: {Source: "api.agify.io"}},
+	})
+
+	s.Run("saved user is returned by id", func() {
+		storedUser, err := s.store.GetUser(ctx, user.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(user, storedUser)
+		s.Require().False(storedUser.CreatedAt.IsZero())
+		s.Require().Equal("junior", storedUser.Attributes["grade"])
+	})
+	s.Run("identity is case and space insensitive", func() {
+		storedUser, err := s.store.GetUserByIdentity(ctx, models.RequestEnrich{Name: " kate", Surname: "KIT "})
+
+		s.Require().NoError(err)
+		s.Require().Equal(user.ID, storedUser.ID)
+	})
+	s.Run("saving the same identity keeps the id", func() {
+		updatedUser := s.saveUser(ctx, models.ResponseEnrich{
+			RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"},
+			Age:           32,
+		})
+
+		s.Require().Equal(user.ID, updatedUser.ID)
+		s.Require().Equal(32, updatedUser.Age)
+		s.Require().Equal(user.CreatedAt, updatedUser.CreatedAt)
+	})
+	s.Run("history records the changes with the actor", func() {
+		history, err := s.store.GetUserHistory(ctx, user.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(2, len(history))
+		s.Require().Equal(models.OperationCreate, history[0].Operation)
+		s.Require().Equal(models.OperationUpdate, history[1].Operation)
+		s.Require().Equal(31, history[1].OldValue.Age)
+		s.Require().Equal("hr-sync", history[1].Actor)
+	})
+	s.Run("concurrent saves of a new identity create one user", func() {
+		const saves = 5
+
+		var (
+			wg  sync.WaitGroup
+			ids = make([]string, saves)
+		)
+
+		for i := 0; i < saves; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				savedUser, err := s.store.SaveUser(ctx, models.ResponseEnrich{
+					ID:            uuid.NewString(),
+					RequestEnrich: models.RequestEnrich{Name: "Liza", Surname: "Race"},
+					Age:           20 + i,
+				})
+				if err == nil {
+					ids[i] = savedUser.ID
+				}
+			}(i)
+		}
+
+		wg.Wait()
+
+		for _, id := range ids {
+			s.Require().Equal(ids[0], id)
+		}
+
+		history, err := s.store.GetUserHistory(ctx, ids[0])
+
+		s.Require().NoError(err)
+		s.Require().Equal(saves, len(history))
+
+		// A save that waited for the insert may have started before it, so the records are only counted.
+		creates := 0
+
+		for _, record := range history {
+			if record.Operation == models.OperationCreate {
+				creates++
+			}
+		}
+
+		s.Require().Equal(1, creates)
+	})
+	s.Run("unknown user is not found", func() {
+		_, err := s.store.GetUser(ctx, uuid.NewString())
+
+		s.Require().ErrorIs(err, storage.ErrUserNotFound)
+	})
+}
+
+func (s *StoreTestSuite) TestUpdateUser() {
+	ctx := context.Background()
+
+	kate := s.saveUser(ctx, models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"}})
+	_ = s.saveUser(ctx, models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Mir"}})
+
+	s.Run("update changes the user", func() {
+		kate.Age, kate.Country = 40, "FR"
+		kate.Countries = []models.CountryScore{{CountryID: "FR", Probability: 1, NameContribution: 1}}
+
+		updatedUser, err := s.store.UpdateUser(ctx, kate)
+
+		s.Require().NoError(err)
+		s.Require().Equal(40, updatedUser.Age)
+		s.Require().Equal("FR", updatedUser.Country)
+		s.Require().Equal(kate.Countries, updatedUser.Countries)
+	})
+	s.Run("update to another identity conflicts", func() {
+		kate.Surname = "Mir"
+
+		_, err := s.store.UpdateUser(ctx, kate)
+
+		s.Require().ErrorIs(err, storage.ErrUserExists)
+	})
+	s.Run("users are found by name", func() {
+		users, err := s.store.FindUsersByName(ctx, "kate")
+
+		s.Require().NoError(err)
+		s.Require().Equal(2, len(users))
+		s.Require().Equal("Kit", users[0].Surname)
+		s.Require().Equal("Mir", users[1].Surname)
+
+		_, err = s.store.FindUsersByName(ctx, "Liza")
+
+		s.Require().ErrorIs(err, storage.ErrUserNotFound)
+	})
+}
+
+func (s *StoreTestSuite) TestDeleteUser() {
+	ctx := context.Background()
+
+	user := s.saveUser(ctx, models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Liza"}})
+
+	s.Run("deleted user is not found", func() {
+		err := s.store.DeleteUser(ctx, user.ID)
+		s.Require().NoError(err)
+
+		_, err = s.store.GetUser(ctx, user.ID)
+		s.Require().ErrorIs(err, storage.ErrUserNotFound)
+
+		err = s.store.DeleteUser(ctx, user.ID)
+		s.Require().ErrorIs(err, storage.ErrUserNotFound)
+	})
+	s.Run("deleted user is listed on request", func() {
+		page, err := s.store.GetUsersList(ctx, models.ListingQueryParams{ItemsPerPage: 10, IncludeDeleted: true})
+
+		s.Require().NoError(err)
+		s.Require().Equal(1, len(page.Items))
+		s.Require().NotNil(page.Items[0].DeletedAt)
+	})
+	s.Run("restore brings the user back", func() {
+		restoredUser, err := s.store.RestoreUser(ctx, user.ID)
+
+		s.Require().NoError(err)
+		s.Require().Nil(restoredUser.DeletedAt)
+
+		_, err = s.store.RestoreUser(ctx, user.ID)
+		s.Require().ErrorIs(err, storage.ErrUserNotFound)
+	})
+	s.Run("restore conflicts with a new user of the same identity", func() {
+		s.Require().NoError(s.store.DeleteUser(ctx, user.ID))
+
+		_ = s.saveUser(ctx, models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Liza"}})
+
+		_, err := s.store.RestoreUser(ctx, user.ID)
+		s.Require().ErrorIs(err, storage.ErrUserExists)
+	})
+	s.Run("purge removes deleted users", func() {
+		purged, err := s.store.PurgeDeletedUsers(ctx, time.Now().Add(time.Minute))
+
+		s.Require().NoError(err)
+		s.Require().Equal(int64(1), purged)
+
+		history, err := s.store.GetUserHistory(ctx, user.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(models.OperationPurge, history[len(history)-1].Operation)
+
+		purgedUser := history[len(history)-1].OldValue
+		s.Require().Equal(user.ID, purgedUser.ID)
+		s.Require().Equal(user.Name, purgedUser.Name)
+		s.Require().True(user.CreatedAt.Equal(purgedUser.CreatedAt))
+		s.Require().NotNil(purgedUser.DeletedAt)
+	})
+}
+
+func (s *StoreTestSuite) TestGetUsersList() {
+	ctx := context.Background()
+
+	users := []models.ResponseEnrich{
+		{RequestEnrich: models.RequestEnrich{Name: "Anna", Surname: "Mu_ller"}, Age: 30, Gender: "female", Country: "DE"},
+		{RequestEnrich: models.RequestEnrich{Name: "Hans", Surname: "Muller"}, Age: 45, Gender: "male", Country: "DE"},
+		{RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Mir"}, Age: 25, Gender: "female", Country: "AT"},
+		{RequestEnrich: models.RequestEnrich{Name: "Katey", Surname: "Kit"}, Age: 35, Gender: "female", Country: "CL"},
+		{
+			RequestEnrich: models.RequestEnrich{Name: "Liza", Surname: "Duchess"}, Age: 47, Gender: "female", Country: "UK",
+			Attributes: map[string]interface{}{"grade": "senior"},
+			Provenance: map[string]models.Provenance{models.FieldAge: {Source: models.SourceManual, Manual: true}},
+		},
+	}
+
+	for _, user := range users {
+		_ = s.saveUser(ctx, user)
+	}
+
+	names := func(page models.UsersPage) []string {
+		result := make([]string, 0, len(page.Items))
+		for _, user := range page.Items {
+			result = append(result, user.Name)
+		}
+
+		return result
+	}
+
+	testCases := []struct {
+		name   string
+		params models.ListingQueryParams
+		want   []string
+	}{
+		{"default order is by name", models.ListingQueryParams{}, []string{"Anna", "Hans", "Kate", "Katey", "Liza"}},
+		{"text filter", models.ListingQueryParams{TextFilter: "UCH"}, []string{"Liza"}},
+		{"typed filters", models.ListingQueryParams{
+			Gender: "female", AgeMin: intPtr(25), AgeMax: intPtr(35), Countries: []string{"DE", "AT"},
+		}, []string{"Anna", "Kate"}},
+		{"surname prefix is literal", models.ListingQueryParams{SurnamePrefix: "mu_"}, []string{"Anna"}},
+		{"attribute", models.ListingQueryParams{Attributes: map[string]string{"grade": "senior"}}, []string{"Liza"}},
+		{"manual override", models.ListingQueryParams{ManualOverride: models.FieldAge}, []string{"Liza"}},
+		{"multi-key sort", models.ListingQueryParams{
+			Sort: []models.SortKey{{Field: "country"}, {Field: "age", Descending: true}},
+		}, []string{"Kate", "Katey", "Hans", "Anna", "Liza"}},
+		{"search", models.ListingQueryParams{Query: "kate"}, []string{"Kate", "Katey"}},
+		{"offset", models.ListingQueryParams{Offset: 3}, []string{"Katey", "Liza"}},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			tc.params.ItemsPerPage = 10
+
+			page, err := s.store.GetUsersList(ctx, tc.params)
+
+			s.Require().NoError(err)
+			s.Require().Equal(tc.want, names(page))
+			s.Require().Equal(int64(tc.params.Offset+len(tc.want)), page.Total)
+		})
+	}
+
+	s.Run("cursors walk the pages both ways", func() {
+		params := models.ListingQueryParams{ItemsPerPage: 2, Sort: []models.SortKey{{Field: "age", Descending: true}}}
+
+		var walked []string
+
+		page, err := s.store.GetUsersList(ctx, params)
+		s.Require().NoError(err)
+
+		walked = append(walked, names(page)...)
+
+		for page.NextCursor != "" {
+			params.Cursor = page.NextCursor
+
+			page, err = s.store.GetUsersList(ctx, params)
+			s.Require().NoError(err)
+
+			walked = append(walked, names(page)...)
+		}
+
+		s.Require().Equal([]string{"Liza", "Hans", "Katey", "Anna", "Kate"}, walked)
+
+		params.Cursor = page.PrevCursor
+
+		page, err = s.store.GetUsersList(ctx, params)
+
+		s.Require().NoError(err)
+		s.Require().Equal([]string{"Katey", "Anna"}, names(page))
+		s.Require().NotEmpty(page.PrevCursor)
+	})
+	s.Run("not valid sort and cursor are rejected", func() {
+		_, err := s.store.GetUsersList(ctx, models.ListingQueryParams{
+			ItemsPerPage: 10, Sort: []models.SortKey{{Field: "password"}},
+		})
+		s.Require().ErrorIs(err, models.ErrSortNotValid)
+
+		_, err = s.store.GetUsersList(ctx, models.ListingQueryParams{ItemsPerPage: 10, Cursor: "garbage"})
+		s.Require().ErrorIs(err, models.ErrCursorNotValid)
+	})
+	s.Run("not valid page is rejected", func() {
+		for _, params := range []models.ListingQueryParams{
+			{ItemsPerPage: -1},
+			{ItemsPerPage: -5},
+			{ItemsPerPage: 0},
+			{ItemsPerPage: 10, Offset: -1},
+		} {
+			_, err := s.store.GetUsersList(ctx, params)
+			s.Require().ErrorIs(err, models.ErrPageNotValid, params)
+		}
+	})
+}
+
+func (s *StoreTestSuite) TestExportUsers() {
+	ctx := context.Background()
+
+	for i, userName := range []string{"Liza", "Kate", "Anna", "Hans"} {
+		_ = s.saveUser(ctx, models.ResponseEnrich{
+			RequestEnrich: models.RequestEnrich{Name: userName}, Age: 20 + i, Gender: "female",
+		})
+	}
+
+	export := func(params models.ListingQueryParams) ([]string, error) {
+		var exported []string
+
+		err := s.store.ExportUsers(ctx, params, func(user models.ResponseEnrich) error {
+			exported = append(exported, user.Name)
+
+			return nil
+		})
+
+		return exported, err
+	}
+
+	s.Run("export takes the filters and sort of the list", func() {
+		exported, err := export(models.ListingQueryParams{
+			AgeMin: intPtr(21), Sort: []models.SortKey{{Field: "age", Descending: true}},
+		})
+
+		s.Require().NoError(err)
+		s.Require().Equal([]string{"Hans", "Anna", "Kate"}, exported)
+	})
+	s.Run("export stops at the first error", func() {
+		errStop := errors.New("stop")
+		calls := 0
+
+		err := s.store.ExportUsers(ctx, models.ListingQueryParams{}, func(models.ResponseEnrich) error {
+			calls++
+
+			return errStop
+		})
+
+		s.Require().ErrorIs(err, errStop)
+		s.Require().Equal(1, calls)
+	})
+	s.Run("not valid sort is rejected", func() {
+		_, err := export(models.ListingQueryParams{Sort: []models.SortKey{{Field: "password"}}})
+
+		s.Require().ErrorIs(err, models.ErrSortNotValid)
+	})
+	s.Run("store serves other requests during a large export", func() {
+		for i := 0; i < 1200; i++ {
+			_ = s.saveUser(ctx, models.ResponseEnrich{
+				RequestEnrich: models.RequestEnrich{Name: fmt.Sprintf("User%04d", i)}, Age: i % 90,
+			})
+		}
+
+		exportCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		var exported []models.ResponseEnrich
+
+		err := s.store.ExportUsers(exportCtx, models.ListingQueryParams{
+			Sort: []models.SortKey{{Field: "age"}, {Field: "name", Descending: true}},
+		}, func(user models.ResponseEnrich) error {
+			if _, err := s.store.GetUser(exportCtx, user.ID); err != nil {
+				return err
+			}
+
+			exported = append(exported, user)
+
+			return nil
+		})
+
+		s.Require().NoError(err)
+		s.Require().Equal(1204, len(exported))
+
+		for i := 1; i < len(exported); i++ {
+			prev, user := exported[i-1], exported[i]
+			s.Require().True(prev.Age < user.Age || prev.Age == user.Age && prev.Name > user.Name,
+				"%s is exported after %s", user.Name, prev.Name)
+		}
+	})
+}
+
+func (s *StoreTestSuite) TestImportUsers() {
+	ctx := audit.WithMeta(context.Background(), audit.Meta{Actor: "import"})
+
+	storedUser := s.saveUser(ctx, models.ResponseEnrich{
+		RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"},
+		Age:           31,
+		Gender:        "female",
+		Country:       "CL",
+		Attributes:    map[string]interface{}{"grade": "junior"},
+		Provenance:    map[string]models.Provenance{models.FieldAge: {Source: "api.agify.io"}},
+	})
+
+	imported := models.Provenance{Source: models.SourceImport, Manual: true}
+
+	importedUsers, err := s.store.ImportUsers(ctx, []models.ResponseEnrich{
+		{
+			ID:            uuid.NewString(),
+			RequestEnrich: models.RequestEnrich{Name: "Liza", Surname: "Duchess"},
+			Age:           25,
+		},
+		{
+			ID:            uuid.NewString(),
+			RequestEnrich: models.RequestEnrich{Name: " kate", Surname: "KIT"},
+			Gender:        "male",
+			Attributes:    map[string]interface{}{"team": "hr"},
+			Provenance:    map[string]models.Provenance{models.FieldGender: imported, "team": imported},
+		},
+	})
+
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(importedUsers))
+
+	s.Run("new user is inserted", func() {
+		s.Require().Equal(models.OperationCreate, importedUsers[0].Operation)
+		s.Require().Equal(25, importedUsers[0].User.Age)
+
+		user, err := s.store.GetUser(ctx, importedUsers[0].User.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal("Liza", user.Name)
+	})
+	s.Run("stored user is updated with the values the import has", func() {
+		updatedUser := importedUsers[1].User
+
+		s.Require().Equal(models.OperationUpdate, importedUsers[1].Operation)
+		s.Require().Equal(storedUser.ID, updatedUser.ID)
+		s.Require().Equal(31, updatedUser.Age)
+		s.Require().Equal("male", updatedUser.Gender)
+		s.Require().Equal("CL", updatedUser.Country)
+		s.Require().Equal(map[string]interface{}{"grade": "junior", "team": "hr"}, updatedUser.Attributes)
+		s.Require().Equal("api.agify.io", updatedUser.Provenance[models.FieldAge].Source)
+		s.Require().True(updatedUser.Provenance[models.FieldGender].Manual)
+	})
+	s.Run("history records the import", func() {
+		history, err := s.store.GetUserHistory(ctx, storedUser.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(2, len(history))
+		s.Require().Equal(models.OperationUpdate, history[1].Operation)
+		s.Require().Equal("female", history[1].OldValue.Gender)
+		s.Require().Equal("male", history[1].NewValue.Gender)
+		s.Require().Equal("import", history[1].Actor)
+
+		history, err = s.store.GetUserHistory(ctx, importedUsers[0].User.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(1, len(history))
+		s.Require().Equal(models.OperationCreate, history[0].Operation)
+	})
+}
+
+func (s *StoreTestSuite) TestLockedFields() {
+	ctx := context.Background()
+	locked := models.Provenance{Source: models.SourceManual, Manual: true, Locked: true}
+	provider := models.Provenance{Source: "api.genderize.io"}
+
+	storedUser := s.saveUser(ctx, models.ResponseEnrich{
+		RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"},
+		Age:           31,
+		Gender:        "female",
+		Country:       "CL",
+		Countries:     []models.CountryScore{{CountryID: "CL", Probability: 1}},
+		Attributes:    map[string]interface{}{"grade": "junior", "team": "hr"},
+		Provenance: map[string]models.Provenance{
+			models.FieldAge:     {Source: "api.agify.io"},
+			models.FieldGender:  locked,
+			models.FieldCountry: locked,
+			"grade":             locked,
+			"team":              {Source: "hr"},
+		},
+	})
+
+	s.Run("provider results keep the locked values", func() {
+		savedUser := s.saveUser(ctx, models.ResponseEnrich{
+			ID:            uuid.NewString(),
+			RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"},
+			Age:           35,
+			Gender:        "male",
+			Country:       "US",
+			Attributes:    map[string]interface{}{"team": "it"},
+			Provenance: map[string]models.Provenance{
+				models.FieldAge:     {Source: "api.agify.io"},
+				models.FieldGender:  provider,
+				models.FieldCountry: {Source: "api.nationalize.io"},
+				"team":              {Source: "hr"},
+			},
+		})
+
+		s.Require().Equal(storedUser.ID, savedUser.ID)
+		s.Require().Equal(35, savedUser.Age)
+		s.Require().Equal("female", savedUser.Gender)
+		s.Require().Equal("CL", savedUser.Country)
+		s.Require().Equal(storedUser.Countries, savedUser.Countries)
+		s.Require().Equal(map[string]interface{}{"grade": "junior", "team": "it"}, savedUser.Attributes)
+		s.Require().Equal(locked, savedUser.Provenance[models.FieldGender])
+		s.Require().Equal(locked, savedUser.Provenance["grade"])
+	})
+	s.Run("import keeps the locked values unless it sets them", func() {
+		imported := models.Provenance{Source: models.SourceImport, Manual: true, Locked: true}
+
+		importedUsers, err := s.store.ImportUsers(ctx, []models.ResponseEnrich{{
+			ID:            uuid.NewString(),
+			RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"},
+			Gender:        "male",
+			Country:       "AR",
+			Attributes:    map[string]interface{}{"grade": "senior"},
+			Provenance: map[string]models.Provenance{
+				models.FieldGender:  provider,
+				models.FieldCountry: imported,
+				"grade":             provider,
+			},
+		}})
+
+		s.Require().NoError(err)
+
+		importedUser := importedUsers[0].User
+
+		s.Require().Equal("female", importedUser.Gender)
+		s.Require().Equal("AR", importedUser.Country)
+		s.Require().Equal("junior", importedUser.Attributes["grade"])
+		s.Require().Equal(locked, importedUser.Provenance[models.FieldGender])
+		s.Require().Equal(imported, importedUser.Provenance[models.FieldCountry])
+	})
+}
+
+func (s *StoreTestSuite) TestJobs() {
+	ctx := context.Background()
+
+	newJob := func(names ...string) models.Job {
+		job := models.Job{ID: uuid.NewString(), Actor: "tester", RequestID: "req-1"}
+		for i, name := range names {
+			job.Items = append(job.Items, models.JobItem{Position: i, Request: models.RequestEnrich{Name: name}})
+		}
+
+		job, err := s.store.CreateJob(ctx, job)
+		s.Require().NoError(err)
+
+		return job
+	}
+
+	first := newJob("Kate", "Liza")
+	second := newJob("Ivan")
+
+	s.Require().Equal(models.JobQueued, first.Status)
+	s.Require().Equal(2, first.Total)
+	s.Require().Nil(first.Items)
+
+	var claimed []models.JobItem
+
+	s.Run("items are claimed in the order of submission", func() {
+		items, err := s.store.ClaimJobItems(ctx, 2, time.Time{})
+
+		s.Require().NoError(err)
+		s.Require().Equal(2, len(items))
+		s.Require().Equal(first.ID, items[0].JobID)
+		s.Require().Equal("Kate", items[0].Request.Name)
+		s.Require().Equal("Liza", items[1].Request.Name)
+		s.Require().Equal(1, items[0].Attempts)
+		s.Require().Equal("tester", items[0].Actor)
+		s.Require().Equal("req-1", items[0].RequestID)
+
+		claimed = items
+
+		job, err := s.store.GetJob(ctx, first.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(models.JobRunning, job.Status)
+		s.Require().Equal(models.JobItemRunning, job.Items[0].Status)
+	})
+	s.Run("claimed items are skipped until they are stale", func() {
+		items, err := s.store.ClaimJobItems(ctx, 5, time.Time{})
+
+		s.Require().NoError(err)
+		s.Require().Equal(1, len(items))
+		s.Require().Equal(second.ID, items[0].JobID)
+
+		items, err = s.store.ClaimJobItems(ctx, 5, time.Now().Add(time.Minute))
+
+		s.Require().NoError(err)
+		s.Require().Equal(3, len(items))
+		s.Require().Equal(2, items[0].Attempts)
+	})
+	s.Run("job is completed once every item is finished", func() {
+		user := models.ResponseEnrich{ID: uuid.NewString(), RequestEnrich: models.RequestEnrich{Name: "Kate"}, Age: 31}
+
+		done := claimed[0]
+		done.Status, done.Attempts, done.User = models.JobItemDone, 2, &user
+
+		s.Require().NoError(s.store.FinishJobItem(ctx, done))
+
+		failed := claimed[1]
+		failed.Status, failed.Attempts, failed.Error = models.JobItemFailed, 2, "name is not valid"
+
+		s.Require().NoError(s.store.FinishJobItem(ctx, failed))
+
+		job, err := s.store.GetJob(ctx, first.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(models.JobCompleted, job.Status)
+		s.Require().NotNil(job.FinishedAt)
+		s.Require().Equal(1, job.Done)
+		s.Require().Equal(1, job.Failed)
+		s.Require().Equal(31, job.Items[0].User.Age)
+		s.Require().Equal("name is not valid", job.Items[1].Error)
+		s.Require().Nil(job.Items[1].User)
+	})
+	s.Run("pending item is claimed again", func() {
+		items, err := s.store.ClaimJobItems(ctx, 5, time.Now().Add(time.Minute))
+		s.Require().NoError(err)
+		s.Require().Equal(1, len(items))
+
+		item := items[0]
+		item.Status, item.Error = models.JobItemPending, "missing values could not be enriched"
+
+		s.Require().NoError(s.store.FinishJobItem(ctx, item))
+
+		job, err := s.store.GetJob(ctx, second.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(models.JobRunning, job.Status)
+		s.Require().Nil(job.FinishedAt)
+
+		items, err = s.store.ClaimJobItems(ctx, 5, time.Time{})
+
+		s.Require().NoError(err)
+		s.Require().Equal(1, len(items))
+		s.Require().Equal(4, items[0].Attempts)
+	})
+	s.Run("pending item is not claimed before its retry time", func() {
+		items, err := s.store.ClaimJobItems(ctx, 5, time.Now().Add(time.Minute))
+		s.Require().NoError(err)
+		s.Require().Equal(1, len(items))
+
+		item, retryAt := items[0], time.Now().Add(time.Hour)
+		item.Status, item.NotBefore = models.JobItemPending, &retryAt
+
+		s.Require().NoError(s.store.FinishJobItem(ctx, item))
+
+		items, err = s.store.ClaimJobItems(ctx, 5, time.Now().Add(time.Minute))
+
+		s.Require().NoError(err)
+		s.Require().Empty(items)
+
+		retryAt = time.Now().Add(-time.Second)
+		item.NotBefore = &retryAt
+
+		s.Require().NoError(s.store.FinishJobItem(ctx, item))
+
+		items, err = s.store.ClaimJobItems(ctx, 5, time.Time{})
+
+		s.Require().NoError(err)
+		s.Require().Equal(1, len(items))
+		s.Require().Equal(second.ID, items[0].JobID)
+	})
+	s.Run("uploaded file keeps its header and cells", func() {
+		job, err := s.store.CreateJob(ctx, models.Job{
+			ID:       uuid.NewString(),
+			FileName: "names.csv",
+			Header:   []string{"name", "team"},
+			Items: []models.JobItem{
+				{Position: 0, Request: models.RequestEnrich{Name: "Kate"}, Cells: []string{"Kate", "hr"}},
+				{Position: 1, Status: models.JobItemFailed, Error: "name is missing", Cells: []string{"", "it"}},
+			},
+		})
+
+		s.Require().NoError(err)
+		s.Require().Equal(models.JobQueued, job.Status)
+		s.Require().Equal(1, job.Failed)
+
+		job, err = s.store.GetJob(ctx, job.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal("names.csv", job.FileName)
+		s.Require().Equal([]string{"name", "team"}, job.Header)
+		s.Require().Equal([]string{"Kate", "hr"}, job.Items[0].Cells)
+		s.Require().Equal(models.JobItemPending, job.Items[0].Status)
+		s.Require().Equal(models.JobItemFailed, job.Items[1].Status)
+		s.Require().Equal("name is missing", job.Items[1].Error)
+	})
+	s.Run("job of failed items is completed at once", func() {
+		job, err := s.store.CreateJob(ctx, models.Job{
+			ID:    uuid.NewString(),
+			Items: []models.JobItem{{Position: 0, Status: models.JobItemFailed, Error: "name is missing"}},
+		})
+
+		s.Require().NoError(err)
+		s.Require().Equal(models.JobCompleted, job.Status)
+		s.Require().NotNil(job.FinishedAt)
+	})
+	s.Run("unknown job is not found", func() {
+		_, err := s.store.GetJob(ctx, uuid.NewString())
+		s.Require().ErrorIs(err, storage.ErrJobNotFound)
+
+		err = s.store.FinishJobItem(ctx, models.JobItem{JobID: uuid.NewString(), Status: models.JobItemDone})
+		s.Require().ErrorIs(err, storage.ErrJobNotFound)
+	})
+}
+
+func (s *StoreTestSuite) TestRefreshUsers() {
+	ctx := audit.WithMeta(context.Background(), audit.Meta{Actor: "refresh"})
+	fetchedAt := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+
+	kate := s.saveUser(ctx, models.ResponseEnrich{
+		RequestEnrich: models.RequestEnrich{Name: "Kate", Surname: "Kit"},
+		Age:           31,
+		Gender:        "female",
+		Country:       "CL",
+		Attributes:    map[string]interface{}{"grade": "junior"},
+		Provenance: map[string]models.Provenance{
+			models.FieldAge:     {Source: "api.agify.io"},
+			models.FieldGender:  {Source: models.SourceManual, Manual: true, Locked: true},
+			models.FieldCountry: {Source: "api.nationalize.io"},
+			"grade":             {Source: "hr"},
+		},
+	})
+	s.saveUser(ctx, models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: "Tom", Surname: "Tam"}, Age: 40})
+
+	s.Run("users created before the time are stale, the stalest first", func() {
+		users, err := s.store.GetStaleUsers(ctx, time.Now().Add(time.Hour), 10)
+
+		s.Require().NoError(err)
+		s.Require().Len(users, 2)
+		s.Require().Equal(kate.ID, users[0].ID)
+
+		users, err = s.store.GetStaleUsers(ctx, time.Now().Add(time.Hour), 1)
+
+		s.Require().NoError(err)
+		s.Require().Len(users, 1)
+
+		users, err = s.store.GetStaleUsers(ctx, kate.CreatedAt, 10)
+
+		s.Require().NoError(err)
+		s.Require().Empty(users)
+	})
+	s.Run("refresh keeps manual values and returns the changes", func() {
+		changes, err := s.store.RefreshUser(ctx, models.ResponseEnrich{
+			ID:         kate.ID,
+			Age:        33,
+			Gender:     "male",
+			Country:    "CL",
+			Attributes: map[string]interface{}{"grade": "senior"},
+			Provenance: map[string]models.Provenance{
+				models.FieldAge:     {Source: "api.agify.io", FetchedAt: fetchedAt},
+				models.FieldGender:  {Source: "api.genderize.io", FetchedAt: fetchedAt},
+				models.FieldCountry: {Source: "api.nationalize.io", FetchedAt: fetchedAt},
+				"grade":             {Source: "hr", FetchedAt: fetchedAt},
+			},
+		})
+
+		s.Require().NoError(err)
+		s.Require().Len(changes, 2)
+		s.Require().Equal(models.FieldAge, changes[0].Field)
+		s.Require().EqualValues(31, changes[0].OldValue)
+		s.Require().EqualValues(33, changes[0].NewValue)
+		s.Require().Equal(kate.ID, changes[0].UserID)
+		s.Require().Equal("grade", changes[1].Field)
+
+		storedUser, err := s.store.GetUser(ctx, kate.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(33, storedUser.Age)
+		s.Require().Equal("female", storedUser.Gender)
+		s.Require().Equal("senior", storedUser.Attributes["grade"])
+		s.Require().True(storedUser.Provenance[models.FieldGender].Manual)
+		s.Require().True(fetchedAt.Equal(storedUser.Provenance[models.FieldAge].FetchedAt))
+
+		history, err := s.store.GetUserHistory(ctx, kate.ID)
+
+		s.Require().NoError(err)
+		s.Require().Equal(models.OperationUpdate, history[len(history)-1].Operation)
+		s.Require().Equal("refresh", history[len(history)-1].Actor)
+	})
+	s.Run("refreshed users become the least stale", func() {
+		users, err := s.store.GetStaleUsers(ctx, time.Now().Add(time.Hour), 10)
+
+		s.Require().NoError(err)
+		s.Require().Len(users, 2)
+		s.Require().Equal(kate.ID, users[1].ID)
+	})
+	s.Run("refresh without new values changes nothing", func() {
+		changes, err := s.store.RefreshUser(ctx, models.ResponseEnrich{ID: kate.ID})
+
+		s.Require().NoError(err)
+		s.Require().Empty(changes)
+	})
+	s.Run("refresh of an unknown user fails", func() {
+		_, err := s.store.RefreshUser(ctx, models.ResponseEnrich{ID: uuid.NewString()})
+
+		s.Require().ErrorIs(err, storage.ErrUserNotFound)
+	})
+	s.Run("runs are listed without their changes, the latest first", func() {
+		startedAt := time.Now().Truncate(time.Millisecond)
+		first := models.RefreshRun{
+			ID:         uuid.NewString(),
+			StartedAt:  startedAt.Add(-time.Hour),
+			FinishedAt: startedAt.Add(-time.Hour),
+			Checked:    1,
+		}
+		second := models.RefreshRun{
+			ID:         uuid.NewString(),
+			StartedAt:  startedAt,
+			FinishedAt: startedAt.Add(time.Second),
+			Checked:    2,
+			Updated:    1,
+			Stopped:    "quota_exceeded",
+			Changes: []models.RefreshChange{
+				{UserID: kate.ID, Name: "Kate", Field: models.FieldAge, OldValue: 31, NewValue: 33},
+			},
+		}
+
+		s.Require().NoError(s.store.SaveRefreshRun(ctx, first))
+		s.Require().NoError(s.store.SaveRefreshRun(ctx, second))
+
+		runs, err := s.store.GetRefreshRuns(ctx, 10)
+
+		s.Require().NoError(err)
+		s.Require().Len(runs, 2)
+		s.Require().Equal(second.ID, runs[0].ID)
+		s.Require().Empty(runs[0].Changes)
+		s.Require().Equal("quota_exceeded", runs[0].Stopped)
+
+		run, err := s.store.GetRefreshRun(ctx, second.ID)
+
+		s.Require().NoError(err)
+		s.Require().True(second.StartedAt.Equal(run.StartedAt))
+		s.Require().Len(run.Changes, 1)
+		s.Require().EqualValues(33, run.Changes[0].NewValue)
+
+		_, err = s.store.GetRefreshRun(ctx, uuid.NewString())
+		s.Require().ErrorIs(err, storage.ErrRefreshRunNotFound)
+	})
+}
+
+func (s *StoreTestSuite) TestFindSimilarUsers() {
+	ctx := context.Background()
+
+	for _, userName := range []string{"Katherine", "Catherine", "Liza"} {
+		_ = s.saveUser(ctx, models.ResponseEnrich{RequestEnrich: models.RequestEnrich{Name: userName}})
+	}
+
+	users, err := s.store.FindSimilarUsers(ctx, "Kathryn")
+
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(users))
+	s.Require().Equal("Catherine", users[0].Name)
+	s.Require().Equal("Katherine", users[1].Name)
+}
+
+func intPtr(value int) *int {
+	return &value
+}